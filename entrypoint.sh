@@ -2,4 +2,13 @@
 exec ./tiny-ollama-chat \
   -port="${PORT}" \
   -ollama-url="${OLLAMA_URL}" \
-  -db-path="${DB_PATH}"
\ No newline at end of file
+  -db-path="${DB_PATH}" \
+  -auto-title="${AUTO_TITLE}" \
+  -title-model="${TITLE_MODEL}" \
+  -mcp-config="${MCP_CONFIG}" \
+  -builtin-tools="${BUILTIN_TOOLS}" \
+  -providers="${PROVIDERS_CONFIG}" \
+  -context-budget="${CONTEXT_BUDGET}" \
+  -summarize-history="${SUMMARIZE_HISTORY}" \
+  -auth="${AUTH}" \
+  -allow-signup="${ALLOW_SIGNUP}"
\ No newline at end of file
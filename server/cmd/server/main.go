@@ -1,23 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 
 	"ollama-tiny-chat/server/internal/api"
+	"ollama-tiny-chat/server/internal/auth"
 	"ollama-tiny-chat/server/internal/config"
 	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/mcp"
+	"ollama-tiny-chat/server/internal/openai"
+	"ollama-tiny-chat/server/internal/provider"
+	"ollama-tiny-chat/server/internal/tools"
 	"ollama-tiny-chat/server/internal/ws"
 
 	"github.com/gorilla/mux"
 )
 
+// shutdownTimeout bounds how long requests in progress may take to finish
+// once the server is asked to stop.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	// Display welcome banner
 	fmt.Println()
@@ -38,6 +52,33 @@ func main() {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
+	// Register the tools models may call
+	if config.Get().BuiltinTools {
+		if err := tools.RegisterBuiltins(tools.Default); err != nil {
+			log.Fatal("Failed to register tools:", err)
+		}
+	}
+
+	// Register the other model providers
+	if cfg := config.Get(); cfg.ProvidersConfig != "" {
+		providersConfig, err := provider.LoadConfig(cfg.ProvidersConfig)
+		if err != nil {
+			log.Fatal("Failed to load providers configuration:", err)
+		}
+		if err := provider.RegisterProviders(providersConfig); err != nil {
+			log.Fatal("Failed to register providers:", err)
+		}
+	}
+
+	// MCP servers run as child processes, which are stopped on shutdown below
+	if cfg := config.Get(); cfg.MCPConfig != "" {
+		mcpConfig, err := mcp.LoadConfig(cfg.MCPConfig)
+		if err != nil {
+			log.Fatal("Failed to load MCP configuration:", err)
+		}
+		mcp.StartServers(mcpConfig, tools.Default)
+	}
+
 	// Display configuration
 	log.Printf("Configuration: %s", config.String())
 
@@ -52,10 +93,18 @@ func main() {
 	// Create router
 	r := mux.NewRouter()
 
+	// Resolve the signed-in user, and turn away anonymous requests when
+	// accounts are enabled
+	r.Use(auth.Middleware)
+	r.HandleFunc("/login", auth.LoginPage)
+
 	// Register API routes
 	apiRouter := r.PathPrefix("/api").Subrouter()
 	api.RegisterRoutes(apiRouter)
 
+	// OpenAI-compatible API for scripts and editor plugins
+	openai.RegisterRoutes(r.PathPrefix("/v1").Subrouter())
+
 	// WebSocket endpoint
 	r.HandleFunc("/ws", ws.HandleWebSocket)
 
@@ -65,7 +114,7 @@ func main() {
 	// SPA handler that checks if file exists first
 	spaHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip API and WebSocket paths
-		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/ws" {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/v1/") || r.URL.Path == "/ws" {
 			http.NotFound(w, r)
 			return
 		}
@@ -100,7 +149,23 @@ func main() {
 	fmt.Println(color.GreenString("────────────────────────────────────"))
 	fmt.Println()
 
-	if err := http.ListenAndServe(serverAddr, r); err != nil {
+	server := &http.Server{Addr: serverAddr, Handler: r}
+
+	// Shut down on Ctrl+C or when the container stops
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.ListenAndServe()
+	// Don't leave the MCP servers' processes behind, however the server ends
+	mcp.StopServers()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal("Server failed to start:", err)
 	}
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+
+	"github.com/gorilla/mux"
+)
+
+const maxAttachmentSize = 20 << 20 // 20 MiB
+
+// Image formats Ollama's vision models accept.
+var allowedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+// UploadAttachment stores an image sent as the "file" field of a multipart
+// form. The returned ID is passed in a message's images list to attach it.
+func UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendErrorResponse(w, "Missing or oversized file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		sendErrorResponse(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		sendErrorResponse(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Trust the bytes, not the client's Content-Type header.
+	mimeType := http.DetectContentType(data)
+	if !allowedImageTypes[mimeType] {
+		sendErrorResponse(w, "Unsupported image type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	attachment, err := database.CreateAttachment(auth.UserID(r.Context()), header.Filename, mimeType, data)
+	if err != nil {
+		sendErrorResponse(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+func GetAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	attachment, err := database.GetAttachment(vars["id"])
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch attachment", http.StatusInternalServerError)
+		return
+	}
+	if attachment == nil || attachment.UserID != auth.UserID(r.Context()) {
+		sendErrorResponse(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Write(attachment.Data)
+}
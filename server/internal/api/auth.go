@@ -0,0 +1,356 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ws"
+
+	"github.com/gorilla/mux"
+)
+
+const minPasswordLength = 8
+
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_.@-]{3,64}$`)
+
+type CredentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"` // only read by POST /users
+}
+
+func (req *CredentialsRequest) validate() error {
+	if !validUsername.MatchString(req.Username) {
+		return errors.New("username must be 3 to 64 letters, digits or . _ - @")
+	}
+	return validatePassword(req.Password)
+}
+
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return errors.New("password must be at least 8 characters")
+	}
+	return nil
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type AuthStatusResponse struct {
+	Enabled       bool           `json:"enabled"`
+	SetupRequired bool           `json:"setup_required"` // no account exists yet
+	SignupAllowed bool           `json:"signup_allowed"`
+	User          *database.User `json:"user"`
+}
+
+// dummyHash is checked against when a username doesn't exist, so failed
+// sign-ins take as long whether or not the user exists.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("not a real password")
+	return hash
+})
+
+// requireAdmin sends a 403 unless the request comes from an admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !auth.IsAdmin(r.Context()) {
+		sendErrorResponse(w, "Admin role required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ownsConversation checks the conversation exists and belongs to the user
+// making the request, sending a 404 otherwise so other users' conversations
+// can't be told apart from missing ones.
+func ownsConversation(w http.ResponseWriter, r *http.Request, convoID string) bool {
+	convo, err := database.GetConversationMetadata(convoID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return false
+	}
+	if convo == nil || convo.UserID != auth.UserID(r.Context()) {
+		sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// ownsMessage is ownsConversation for the conversation of a message.
+func ownsMessage(w http.ResponseWriter, r *http.Request, messageID string) bool {
+	msg, err := database.GetMessageByID(messageID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch message", http.StatusInternalServerError)
+		return false
+	}
+	if msg == nil {
+		sendErrorResponse(w, "Message not found", http.StatusNotFound)
+		return false
+	}
+	convo, err := database.GetConversationMetadata(msg.ConversationID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch message", http.StatusInternalServerError)
+		return false
+	}
+	if convo == nil || convo.UserID != auth.UserID(r.Context()) {
+		sendErrorResponse(w, "Message not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// GetAuthStatus tells the sign-in page what to offer and the app who is
+// signed in.
+func GetAuthStatus(w http.ResponseWriter, r *http.Request) {
+	response := AuthStatusResponse{
+		Enabled: auth.Enabled(),
+		User:    auth.UserFromContext(r.Context()),
+	}
+	if response.Enabled {
+		count, err := database.CountUsers()
+		if err != nil {
+			sendErrorResponse(w, "Failed to check accounts", http.StatusInternalServerError)
+			return
+		}
+		response.SetupRequired = count == 0
+		response.SignupAllowed = count == 0 || config.Get().AllowSignup
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Register creates an account and signs it in. It is open while no account
+// exists, the first becoming the admin, and afterwards only with
+// -allow-signup.
+func Register(w http.ResponseWriter, r *http.Request) {
+	if !auth.Enabled() {
+		sendErrorResponse(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	var req CredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	count, err := database.CountUsers()
+	if err != nil {
+		sendErrorResponse(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 && !config.Get().AllowSignup {
+		sendErrorResponse(w, "Sign-up is disabled; ask an admin for an account", http.StatusForbidden)
+		return
+	}
+
+	user, ok := createUser(w, req.Username, req.Password, database.UserRoleMember)
+	if !ok {
+		return
+	}
+	if err := auth.StartSession(w, r, user); err != nil {
+		log.Printf("Failed to start session: %v", err)
+		sendErrorResponse(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+func createUser(w http.ResponseWriter, username, password, role string) (*database.User, bool) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		sendErrorResponse(w, "Failed to create account", http.StatusInternalServerError)
+		return nil, false
+	}
+	user, err := database.CreateUser(username, hash, role)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		sendErrorResponse(w, "Username already taken", http.StatusConflict)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to create user: %v", err)
+		sendErrorResponse(w, "Failed to create account", http.StatusInternalServerError)
+		return nil, false
+	}
+	log.Printf("Created %s account %s", user.Role, user.Username)
+	return user, true
+}
+
+func Login(w http.ResponseWriter, r *http.Request) {
+	if !auth.Enabled() {
+		sendErrorResponse(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	var req CredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := database.GetUserByUsername(req.Username)
+	if err != nil {
+		sendErrorResponse(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	hash := dummyHash()
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	if !auth.CheckPassword(hash, req.Password) || user == nil {
+		sendErrorResponse(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := auth.StartSession(w, r, user); err != nil {
+		log.Printf("Failed to start session: %v", err)
+		sendErrorResponse(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if err := auth.EndSession(w, r); err != nil {
+		sendErrorResponse(w, "Failed to sign out", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword replaces the signed-in user's password, signing out their
+// other sessions.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !auth.CheckPassword(user.PasswordHash, req.CurrentPassword) {
+		sendErrorResponse(w, "Current password is wrong", http.StatusForbidden)
+		return
+	}
+	if err := validatePassword(req.NewPassword); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err == nil {
+		err = database.SetPassword(user.ID, hash)
+	}
+	if err == nil {
+		err = auth.StartSession(w, r, user)
+	}
+	if err != nil {
+		log.Printf("Failed to change password: %v", err)
+		sendErrorResponse(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	users, err := database.ListUsers()
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// CreateUser lets an admin add an account with role "member" (the default)
+// or "admin".
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	if !auth.Enabled() {
+		sendErrorResponse(w, "Accounts are disabled", http.StatusNotFound)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req CredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = database.UserRoleMember
+	}
+	if req.Role != database.UserRoleMember && req.Role != database.UserRoleAdmin {
+		sendErrorResponse(w, "Role must be member or admin", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := createUser(w, req.Username, req.Password, req.Role)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUser removes an account and all its conversations.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	if vars["id"] == auth.UserID(r.Context()) {
+		sendErrorResponse(w, "You can't delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	ws.StopUserGenerations(vars["id"])
+	if err := database.DeleteUser(vars["id"]); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to delete user: %v", err)
+		sendErrorResponse(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+
+	"github.com/gorilla/mux"
+)
+
+// ListComparisons returns the user's model comparisons with every answer and
+// the preferred one, newest first.
+func ListComparisons(w http.ResponseWriter, r *http.Request) {
+	comparisons, err := database.ListComparisons(auth.UserID(r.Context()))
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch comparisons", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparisons)
+}
+
+// PreferAnswer marks an answer of a comparison as the preferred one and
+// continues the conversation from it, returning the comparison.
+func PreferAnswer(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["id"]
+	if !ownsMessage(w, r, messageID) {
+		return
+	}
+
+	comparison, err := database.PreferAnswer(messageID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotCompared) {
+			sendErrorResponse(w, "Message is not an answer of a comparison", http.StatusBadRequest)
+			return
+		}
+		sendErrorResponse(w, "Failed to prefer answer", http.StatusInternalServerError)
+		return
+	}
+	if err := database.SwitchBranch(comparison.ConversationID, messageID); err != nil {
+		sendErrorResponse(w, "Failed to switch branch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
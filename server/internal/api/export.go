@@ -0,0 +1,260 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/export"
+	"ollama-tiny-chat/server/internal/importer"
+
+	"github.com/gorilla/mux"
+)
+
+const maxImportSize = 256 << 20 // 256 MiB
+
+// exportFile is one rendered conversation.
+type exportFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+var exportContentTypes = map[string]string{
+	"md":   "text/markdown; charset=utf-8",
+	"json": "application/json",
+	"html": "text/html; charset=utf-8",
+}
+
+// exportConversation renders a conversation of the user in the given
+// format, or returns nil if they have none with that ID. Markdown and HTML
+// show the active branch; JSON holds every branch so it can be imported
+// again.
+func exportConversation(userID, convoID, format string, opts export.Options) (*exportFile, error) {
+	convo, err := database.GetConversationByID(convoID)
+	if err != nil || convo == nil || convo.UserID != userID {
+		return nil, err
+	}
+
+	attachments, err := database.GetConversationAttachments(convoID)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &exportFile{
+		Name:        export.Filename(convo, format),
+		ContentType: exportContentTypes[format],
+	}
+
+	switch format {
+	case "md":
+		opts.Attachments = attachments
+		file.Data = export.Markdown(convo, opts)
+	case "html":
+		opts.Attachments = attachments
+		file.Data, err = export.HTML(convo, opts)
+	case "json":
+		var messages []database.Message
+		messages, err = database.GetAllMessages(convoID)
+		if err != nil {
+			return nil, err
+		}
+		file.Data, err = json.MarshalIndent(export.NewDocument(export.FromDatabase(convo, messages, attachments)), "", "  ")
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// exportParams reads ?format= (md by default) and ?thinking=true.
+func exportParams(r *http.Request) (string, export.Options, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "md"
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		return "", export.Options{}, false
+	}
+	return format, export.Options{Thinking: r.URL.Query().Get("thinking") == "true"}, true
+}
+
+func setAttachmentFilename(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+}
+
+// ExportConversation downloads one conversation as ?format=md|json|html.
+// Thinking is left out unless ?thinking=true.
+func ExportConversation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	format, opts, ok := exportParams(r)
+	if !ok {
+		sendErrorResponse(w, "Invalid format: use md, json or html", http.StatusBadRequest)
+		return
+	}
+
+	file, err := exportConversation(auth.UserID(r.Context()), vars["id"], format, opts)
+	if err != nil {
+		log.Printf("Failed to export conversation %s: %v", vars["id"], err)
+		sendErrorResponse(w, "Failed to export conversation", http.StatusInternalServerError)
+		return
+	}
+	if file == nil {
+		sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", file.ContentType)
+	setAttachmentFilename(w, file.Name)
+	w.Write(file.Data)
+}
+
+// ExportAllConversations downloads every conversation of the user, archived
+// ones included, as a zip with one file per conversation.
+func ExportAllConversations(w http.ResponseWriter, r *http.Request) {
+	format, opts, ok := exportParams(r)
+	if !ok {
+		sendErrorResponse(w, "Invalid format: use md, json or html", http.StatusBadRequest)
+		return
+	}
+
+	userID := auth.UserID(r.Context())
+	ids, err := database.ListConversationIDs(userID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch conversations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	setAttachmentFilename(w, fmt.Sprintf("conversations-%s.zip", time.Now().Format("2006-01-02")))
+
+	// The response has started once the first file is written, so later
+	// failures can only cut the archive short.
+	archive := zip.NewWriter(w)
+	now := time.Now()
+	for _, id := range ids {
+		file, err := exportConversation(userID, id, format, opts)
+		if err != nil {
+			log.Printf("Failed to export conversation %s: %v", id, err)
+			return
+		}
+		if file == nil {
+			continue // deleted in the meantime
+		}
+
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = entry.Write(file.Data)
+		}
+		if err != nil {
+			log.Printf("Failed to write export archive: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write export archive: %v", err)
+	}
+}
+
+// Import outcomes reported per conversation.
+const (
+	ImportImported = "imported"
+	ImportSkipped  = "skipped" // already present, e.g. on re-import
+	ImportFailed   = "failed"
+)
+
+type ImportResult struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Format string `json:"format"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+func (resp *ImportResponse) add(result ImportResult) {
+	switch result.Status {
+	case ImportImported:
+		resp.Imported++
+	case ImportSkipped:
+		resp.Skipped++
+	default:
+		resp.Failed++
+	}
+	resp.Results = append(resp.Results, result)
+}
+
+// readImport returns the uploaded file, sent either as the "file" field of
+// a multipart form or as the request body.
+func readImport(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// ImportConversations stores the conversations of a ChatGPT export
+// (conversations.json or the whole zip), an Open WebUI export, or this
+// server's JSON export. Conversations that were imported before are skipped,
+// so importing the same file twice is harmless.
+func ImportConversations(w http.ResponseWriter, r *http.Request) {
+	data, err := readImport(w, r)
+	if err != nil {
+		sendErrorResponse(w, "Missing or oversized file", http.StatusBadRequest)
+		return
+	}
+
+	userID := auth.UserID(r.Context())
+	conversations, err := importer.Parse(data, userID)
+	if err != nil {
+		sendErrorResponse(w, "Invalid import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := ImportResponse{Results: []ImportResult{}}
+	for _, parsed := range conversations {
+		convo := parsed.Conversation
+		convo.UserID = userID
+		result := ImportResult{ID: convo.ID, Title: convo.Title, Format: parsed.Format, Status: ImportImported}
+
+		err := parsed.Err
+		if err == nil {
+			err = database.ImportConversation(convo, parsed.Attachments)
+		}
+		if errors.Is(err, database.ErrConversationExists) {
+			result.Status = ImportSkipped
+		} else if err != nil {
+			log.Printf("Failed to import conversation %q: %v", convo.Title, err)
+			result.Status = ImportFailed
+			result.Error = err.Error()
+		}
+		response.add(result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
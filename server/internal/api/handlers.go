@@ -2,25 +2,92 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"ollama-tiny-chat/server/internal/config"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"ollama-tiny-chat/server/internal/auth"
 	"ollama-tiny-chat/server/internal/database"
 	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+	"ollama-tiny-chat/server/internal/ws"
 
 	"github.com/gorilla/mux"
 )
 
 type CreateConversationRequest struct {
-	Model   string `json:"model"`
-	Message string `json:"message"`
+	Model        string          `json:"model"`
+	Message      string          `json:"message"`
+	SystemPrompt string          `json:"system_prompt"`
+	Options      *ollama.Options `json:"options"`
+	Images       []string        `json:"images"` // attachment IDs from POST /attachments
 }
 
 type CreateConversationResponse struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
-	Model string `json:"model"`
+	ID           string          `json:"id"`
+	Title        string          `json:"title"`
+	Model        string          `json:"model"`
+	SystemPrompt string          `json:"system_prompt"`
+	Options      *ollama.Options `json:"options"`
+}
+
+// UpdateConversationRequest is the body of PATCH /conversations/{id}. Only the
+// fields present are changed.
+type UpdateConversationRequest struct {
+	Title        *string `json:"title"`
+	Model        *string `json:"model"`
+	SystemPrompt *string `json:"system_prompt"`
+	Pinned       *bool   `json:"pinned"`
+	Archived     *bool   `json:"archived"`
+
+	// DisabledMCPServers replaces the list of MCP servers turned off for
+	// the conversation; an empty list enables them all.
+	DisabledMCPServers *[]string `json:"disabled_mcp_servers"`
+}
+
+func (req *UpdateConversationRequest) validate() error {
+	if req.Title == nil && req.Model == nil && req.SystemPrompt == nil && req.Pinned == nil && req.Archived == nil &&
+		req.DisabledMCPServers == nil {
+		return errors.New("no fields to update")
+	}
+	if req.Title != nil {
+		trimmed := strings.TrimSpace(*req.Title)
+		if trimmed == "" {
+			return errors.New("title must not be empty")
+		}
+		if utf8.RuneCountInString(trimmed) > maxTitleLength {
+			return fmt.Errorf("title must be at most %d characters", maxTitleLength)
+		}
+		req.Title = &trimmed
+	}
+	if req.Model != nil && strings.TrimSpace(*req.Model) == "" {
+		return errors.New("model must not be empty")
+	}
+	return nil
 }
 
+type EditMessageRequest struct {
+	Message string `json:"message"`
+}
+
+type SwitchBranchRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+type UpdateSystemPromptRequest struct {
+	SystemPrompt string `json:"system_prompt"`
+}
+
+const (
+	maxTitleLength = 200
+
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
 type ErrorResponse struct {
 	Message string `json:"message"`
 }
@@ -39,28 +106,37 @@ func CreateConversation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	title := req.Message
-	if len(title) > 30 {
-		title = title[:30] + "..."
+	if err := req.Options.Validate(); err != nil {
+		sendErrorResponse(w, "Invalid options: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	convoID, err := database.CreateConversation(title, req.Model)
+	title := database.TitleFromMessage(req.Message)
+
+	convoID, err := database.CreateConversation(auth.UserID(r.Context()), title, req.Model, req.SystemPrompt, req.Options)
 
 	if err != nil {
-		
+
 		sendErrorResponse(w, "Failed to create conversation", http.StatusInternalServerError)
 		return
 	}
 
-	if err := database.AddMessage(convoID, "user", req.Message); err != nil {
+	if _, err := database.AddMessage(convoID, "user", req.Message, req.Images); err != nil {
+		database.DeleteConversation(convoID)
+		if errors.Is(err, database.ErrInvalidAttachment) {
+			sendErrorResponse(w, "Invalid image attachment", http.StatusBadRequest)
+			return
+		}
 		sendErrorResponse(w, "Failed to add message to conversation", http.StatusInternalServerError)
 		return
 	}
 
 	response := CreateConversationResponse{
-		ID:    convoID,
-		Title: title,
-		Model: req.Model,
+		ID:           convoID,
+		Title:        title,
+		Model:        req.Model,
+		SystemPrompt: req.SystemPrompt,
+		Options:      req.Options,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -79,7 +155,7 @@ func GetConversation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if conversation == nil {
+	if conversation == nil || conversation.UserID != auth.UserID(r.Context()) {
 		sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
 		return
 	}
@@ -88,21 +164,247 @@ func GetConversation(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(conversation)
 }
 
-func ListModels(w http.ResponseWriter, r *http.Request) {
-	client := ollama.NewClient(config.Get().OllamaURL)
+func UpdateConversation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	convoID := vars["id"]
 
-	models, err := client.ListModels()
+	var req UpdateConversationRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		sendErrorResponse(w, "Invalid update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !ownsConversation(w, r, convoID) {
+		return
+	}
+
+	conversation, err := database.PatchConversation(convoID, database.ConversationPatch{
+		Title:        req.Title,
+		Model:        req.Model,
+		SystemPrompt: req.SystemPrompt,
+		Pinned:       req.Pinned,
+		Archived:     req.Archived,
+
+		DisabledMCPServers: req.DisabledMCPServers,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, "Failed to update conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+func UpdateSystemPrompt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	convoID := vars["id"]
+
+	var req UpdateSystemPromptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !ownsConversation(w, r, convoID) {
+		return
+	}
+
+	if err := database.UpdateSystemPrompt(convoID, req.SystemPrompt); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, "Failed to update system prompt", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateConversationOptions replaces the conversation's default generation
+// options. Sending null clears them so the model defaults apply again.
+func UpdateConversationOptions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	convoID := vars["id"]
+
+	var options *ollama.Options
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := options.Validate(); err != nil {
+		sendErrorResponse(w, "Invalid options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !ownsConversation(w, r, convoID) {
+		return
+	}
+
+	if err := database.UpdateConversationOptions(convoID, options); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, "Failed to update options", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EditMessage adds an edited copy of a user message as a new branch. The
+// client asks for the reply to it with a "regenerate" WebSocket request.
+func EditMessage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	messageID := vars["id"]
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !ownsMessage(w, r, messageID) {
+		return
+	}
+
+	edited, err := database.EditMessage(messageID, req.Message)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			sendErrorResponse(w, "Message not found", http.StatusNotFound)
+		case errors.Is(err, database.ErrNotUserMessage):
+			sendErrorResponse(w, "Only user messages can be edited", http.StatusBadRequest)
+		default:
+			sendErrorResponse(w, "Failed to edit message", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(edited)
+}
+
+// ListMessageSiblings returns the alternative branches at a message,
+// including the message itself, oldest first.
+func ListMessageSiblings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	messageID := vars["id"]
+
+	if !ownsMessage(w, r, messageID) {
+		return
+	}
+
+	siblings, err := database.GetSiblings(messageID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, "Failed to fetch siblings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(siblings)
+}
+
+// SwitchBranch makes the branch through the given message active and returns
+// the conversation with its new message path.
+func SwitchBranch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	convoID := vars["id"]
+
+	var req SwitchBranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !ownsConversation(w, r, convoID) {
+		return
+	}
+
+	if err := database.SwitchBranch(convoID, req.MessageID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "Message not found in conversation", http.StatusNotFound)
+			return
+		}
+		sendErrorResponse(w, "Failed to switch branch", http.StatusInternalServerError)
+		return
+	}
+
+	conversation, err := database.GetConversationByID(convoID)
+	if err != nil || conversation == nil {
+		sendErrorResponse(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// SearchConversations runs a full-text search over message contents and
+// conversation titles. The optional limit parameter caps the number of hits.
+func SearchConversations(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		sendErrorResponse(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := database.Search(auth.UserID(r.Context()), query, limit)
+	if err != nil {
+		sendErrorResponse(w, "Failed to search conversations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func ListModels(w http.ResponseWriter, r *http.Request) {
+	models, err := provider.ListModels(r.Context())
 	if err != nil {
 		sendErrorResponse(w, "Failed to fetch models", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models);
+	json.NewEncoder(w).Encode(models)
 }
 
+// ListConversations returns the active conversations, or the archived ones
+// when called with ?archived=true.
 func ListConversations(w http.ResponseWriter, r *http.Request) {
-	conversations, err := database.ListConversations()
+	archived := r.URL.Query().Get("archived") == "true"
+
+	conversations, err := database.ListConversations(auth.UserID(r.Context()), archived)
 	if err != nil {
 		sendErrorResponse(w, "Failed to fetch conversations", http.StatusInternalServerError)
 		return
@@ -116,8 +418,15 @@ func DeleteConversation(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	convoID := vars["id"]
 
+	if !ownsConversation(w, r, convoID) {
+		return
+	}
+
+	// A reply still streaming would be saved into the deleted conversation.
+	ws.StopGeneration(convoID)
+
 	if err := database.DeleteConversation(convoID); err != nil {
-		// http.Error(w, "Failed to delete conversation", http.StatusInternalServerError)
+		sendErrorResponse(w, "Failed to delete conversation", http.StatusInternalServerError)
 		return
 	}
 
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // RFC 3339; omitted for a key that doesn't expire
+}
+
+func (req *CreateAPIKeyRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("at least one scope is required: " + strings.Join(database.Scopes, ", "))
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(database.Scopes, scope) {
+			return errors.New("unknown scope " + scope + "; expected " + strings.Join(database.Scopes, ", "))
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+type CreateAPIKeyResponse struct {
+	Key   *database.APIKey `json:"key"`
+	Token string           `json:"token"` // only returned here; store it safely
+}
+
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := database.ListAPIKeys(auth.UserID(r.Context()))
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKey makes a key acting as the signed-in user, limited to the
+// requested scopes.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	key, token, err := auth.NewAPIKey(auth.UserID(r.Context()), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		sendErrorResponse(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created API key %s (%s) with scopes %s", key.Name, key.Prefix, strings.Join(key.Scopes, ", "))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{Key: key, Token: token})
+}
+
+// RevokeAPIKey deletes one of the signed-in user's keys.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := database.DeleteAPIKey(auth.UserID(r.Context()), vars["id"]); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			sendErrorResponse(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke API key: %v", err)
+		sendErrorResponse(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ollama-tiny-chat/server/internal/mcp"
+)
+
+// ListMCPServers reports the configured MCP servers, whether they are
+// running and which tools they provide.
+func ListMCPServers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mcp.Servers())
+}
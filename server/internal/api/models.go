@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+	"ollama-tiny-chat/server/internal/ws"
+
+	"github.com/gorilla/mux"
+)
+
+type PullModelRequest struct {
+	Name string `json:"name"`
+}
+
+// PullModel starts downloading a model and returns immediately. Progress is
+// broadcast to WebSocket clients as pull_progress, pull_done and pull_failed
+// events. Models are shared by every user, so only admins manage them.
+func PullModel(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req PullModelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := ws.StartPull(req.Name); err != nil {
+		if errors.Is(err, ws.ErrPullInProgress) {
+			sendErrorResponse(w, "Model is already being pulled", http.StatusConflict)
+			return
+		}
+		sendErrorResponse(w, "Failed to start pull", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func ShowModel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	client := provider.OllamaClient(r.Context(), vars["name"])
+
+	info, err := client.ShowModel(vars["name"])
+	if err != nil {
+		sendErrorResponse(w, "Failed to fetch model details", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func DeleteModel(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	// Every host holding the model loses it, even when some of them fail.
+	vars := mux.Vars(r)
+	clients := provider.OllamaHolders(vars["name"])
+	if len(clients) == 0 {
+		sendErrorResponse(w, "Model not found", http.StatusNotFound)
+		return
+	}
+	defer provider.RefreshOllama()
+
+	failed := 0
+	for _, client := range clients {
+		if err := client.DeleteModel(vars["name"]); err != nil {
+			log.Printf("Failed to delete model %s: %v", vars["name"], err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		sendErrorResponse(w, fmt.Sprintf("Failed to delete model from %d of %d hosts", failed, len(clients)), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRunningModels returns the models the healthy Ollama hosts currently
+// hold in memory.
+func ListRunningModels(w http.ResponseWriter, r *http.Request) {
+	models := []ollama.RunningModel{}
+	for _, client := range provider.OllamaClients("") {
+		running, err := client.ListRunningModels(r.Context())
+		if err != nil {
+			sendErrorResponse(w, "Failed to fetch running models", http.StatusInternalServerError)
+			return
+		}
+		models = append(models, running...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models)
+}
@@ -1,15 +1,55 @@
 package api
 
 import (
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+
 	"github.com/gorilla/mux"
 )
 
 func RegisterRoutes(r *mux.Router) {
+	// API keys only reach the routes their scopes cover, and none of those
+	// managing accounts and keys. Sessions reach everything.
+	read := auth.Scope(database.ScopeReadConversations)
+	chat := auth.Scope(database.ScopeChat)
+	manageModels := auth.Scope(database.ScopeManageModels)
 
-	r.HandleFunc("/conversations", CreateConversation).Methods("POST")
-	r.HandleFunc("/conversations", ListConversations).Methods("GET")
-	r.HandleFunc("/conversations/{id}", GetConversation).Methods("GET")
-	r.HandleFunc("/conversations/{id}", DeleteConversation).Methods("DELETE")
+	r.HandleFunc("/auth/status", GetAuthStatus).Methods("GET")
+	r.HandleFunc("/auth/register", Register).Methods("POST")
+	r.HandleFunc("/auth/login", Login).Methods("POST")
+	r.HandleFunc("/auth/logout", Logout).Methods("POST")
+	r.HandleFunc("/auth/password", auth.SessionOnly(ChangePassword)).Methods("PUT")
+	r.HandleFunc("/users", auth.SessionOnly(ListUsers)).Methods("GET")
+	r.HandleFunc("/users", auth.SessionOnly(CreateUser)).Methods("POST")
+	r.HandleFunc("/users/{id}", auth.SessionOnly(DeleteUser)).Methods("DELETE")
+	r.HandleFunc("/keys", auth.SessionOnly(ListAPIKeys)).Methods("GET")
+	r.HandleFunc("/keys", auth.SessionOnly(CreateAPIKey)).Methods("POST")
+	r.HandleFunc("/keys/{id}", auth.SessionOnly(RevokeAPIKey)).Methods("DELETE")
+	r.HandleFunc("/conversations", chat(CreateConversation)).Methods("POST")
+	r.HandleFunc("/conversations", read(ListConversations)).Methods("GET")
+	r.HandleFunc("/conversations/{id}", read(GetConversation)).Methods("GET")
+	r.HandleFunc("/conversations/{id}", chat(UpdateConversation)).Methods("PATCH")
+	r.HandleFunc("/conversations/{id}", chat(DeleteConversation)).Methods("DELETE")
+	r.HandleFunc("/conversations/{id}/system-prompt", chat(UpdateSystemPrompt)).Methods("PUT")
+	r.HandleFunc("/conversations/{id}/options", chat(UpdateConversationOptions)).Methods("PUT")
+	r.HandleFunc("/conversations/{id}/branch", chat(SwitchBranch)).Methods("PUT")
+	r.HandleFunc("/conversations/{id}/export", read(ExportConversation)).Methods("GET")
+	r.HandleFunc("/export", read(ExportAllConversations)).Methods("GET")
+	r.HandleFunc("/import", chat(ImportConversations)).Methods("POST")
+	r.HandleFunc("/messages/{id}/edit", chat(EditMessage)).Methods("POST")
+	r.HandleFunc("/messages/{id}/siblings", read(ListMessageSiblings)).Methods("GET")
+	r.HandleFunc("/messages/{id}/preferred", chat(PreferAnswer)).Methods("PUT")
+	r.HandleFunc("/comparisons", read(ListComparisons)).Methods("GET")
+	r.HandleFunc("/attachments", chat(UploadAttachment)).Methods("POST")
+	r.HandleFunc("/attachments/{id}", read(GetAttachment)).Methods("GET")
+	r.HandleFunc("/search", read(SearchConversations)).Methods("GET")
 	r.HandleFunc("/models", ListModels).Methods("GET")
+	r.HandleFunc("/models/running", ListRunningModels).Methods("GET")
+	r.HandleFunc("/models/pull", manageModels(PullModel)).Methods("POST")
+	// Model names may contain slashes, e.g. hf.co/org/model:tag
+	r.HandleFunc("/models/{name:.+}", ShowModel).Methods("GET")
+	r.HandleFunc("/models/{name:.+}", manageModels(DeleteModel)).Methods("DELETE")
+	r.HandleFunc("/stats", read(GetStats)).Methods("GET")
+	r.HandleFunc("/mcp/servers", ListMCPServers).Methods("GET")
 	r.HandleFunc("/config", GetConfig).Methods("GET")
 }
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 3650
+)
+
+// GetStats returns token usage and generation speed per model and per day
+// for the last ?days=N days, today included, in the user's conversations.
+// Admins may pass ?user=all for usage across users, with a per-user summary,
+// or ?user=<id> for one user's.
+func GetStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultStatsDays
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxStatsDays {
+			sendErrorResponse(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	userID := auth.UserID(r.Context())
+	scope := &userID
+	if user := r.URL.Query().Get("user"); user != "" {
+		if !requireAdmin(w, r) {
+			return
+		}
+		scope = &user
+		if user == "all" {
+			scope = nil
+		}
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, 1-days)
+	stats, err := database.GetUsageStats(since, scope)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
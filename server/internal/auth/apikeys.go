@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ollama-tiny-chat/server/internal/database"
+)
+
+// APIKeyPrefix starts every key, so leaked keys are easy to recognise.
+const APIKeyPrefix = "toc_"
+
+// touchInterval limits how often a key's last use is written back.
+const touchInterval = time.Minute
+
+type apiKeyContextKey struct{}
+
+// NewAPIKey creates a key for the user and returns it with the token, which
+// is shown only this once.
+func NewAPIKey(userID, name string, scopes []string, expiresAt *time.Time) (*database.APIKey, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	token := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	key := &database.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(APIKeyPrefix)+6],
+		TokenHash: hashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := database.CreateAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	return key, token, nil
+}
+
+// APIKeyFromContext returns the key the request was made with, or nil for
+// browser sessions and requests without accounts.
+func APIKeyFromContext(ctx context.Context) *database.APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*database.APIKey)
+	return key
+}
+
+// Allows reports whether the request may do what scope covers. Only API
+// keys are limited to scopes.
+func Allows(ctx context.Context, scope string) bool {
+	key := APIKeyFromContext(ctx)
+	return key == nil || key.HasScope(scope)
+}
+
+// Scope returns a wrapper that turns away API keys lacking scope.
+func Scope(scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !Allows(r.Context(), scope) {
+				writeError(w, http.StatusForbidden, "API key lacks the "+scope+" scope")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// SessionOnly turns away API keys, so a key can't manage accounts or mint
+// other keys.
+func SessionOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if APIKeyFromContext(r.Context()) != nil {
+			writeError(w, http.StatusForbidden, "Not available with an API key")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestToken returns the API key sent with the request: a Bearer header,
+// or a token parameter on the WebSocket upgrade, which browsers can't add
+// headers to.
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if r.URL.Path == "/ws" {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// apiKeyContext returns ctx carrying the key and its user, or nil when the
+// token isn't a valid key.
+func apiKeyContext(ctx context.Context, token string) (context.Context, error) {
+	key, err := database.GetAPIKey(hashToken(token))
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	// Without accounts everyone is the local user, whoever made the key.
+	if Enabled() {
+		user, err := database.GetUserByID(key.UserID)
+		if err != nil || user == nil {
+			return nil, err
+		}
+		ctx = WithUser(ctx, user)
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > touchInterval {
+		if err := database.TouchAPIKey(key.ID); err != nil {
+			log.Printf("Failed to record API key use: %v", err)
+		}
+	}
+	return context.WithValue(ctx, apiKeyContextKey{}, key), nil
+}
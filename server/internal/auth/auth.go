@@ -0,0 +1,198 @@
+// Package auth signs users in with passwords and session cookies or API
+// keys, and tells handlers whose request they are serving. Without -auth
+// every request is served as the one local user, who owns the conversations
+// with no owner.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+)
+
+const (
+	CookieName      = "tiny_ollama_session"
+	SessionLifetime = 30 * 24 * time.Hour
+)
+
+type contextKey struct{}
+
+// Enabled reports whether users have to sign in.
+func Enabled() bool {
+	return config.Get().Auth
+}
+
+// WithUser returns a context carrying the signed-in user.
+func WithUser(ctx context.Context, user *database.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the signed-in user, or nil when accounts are
+// disabled.
+func UserFromContext(ctx context.Context) *database.User {
+	user, _ := ctx.Value(contextKey{}).(*database.User)
+	return user
+}
+
+// UserID returns the owner of the conversations the request may touch,
+// which is empty when accounts are disabled.
+func UserID(ctx context.Context) string {
+	if user := UserFromContext(ctx); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// IsAdmin reports whether the request may manage users and the server.
+// Without accounts the only user runs the server, so they may.
+func IsAdmin(ctx context.Context) bool {
+	if !Enabled() {
+		return true
+	}
+	user := UserFromContext(ctx)
+	return user != nil && user.IsAdmin()
+}
+
+// publicPaths can be reached without signing in.
+var publicPaths = map[string]bool{
+	"/login":             true,
+	"/api/auth/status":   true,
+	"/api/auth/login":    true,
+	"/api/auth/register": true,
+}
+
+// Middleware resolves the API key or session cookie into a user on the
+// request's context. A key that isn't valid gets a 401, even without
+// accounts; other bearer tokens are ignored then, as OpenAI clients send one
+// whatever the server. When accounts are enabled, requests without a valid
+// session get a 401 on the APIs and WebSocket and are sent to the sign-in
+// page otherwise.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := requestToken(r); token != "" && (Enabled() || strings.HasPrefix(token, APIKeyPrefix)) {
+			ctx, err := apiKeyContext(r.Context(), token)
+			if err != nil {
+				log.Printf("Failed to check API key: %v", err)
+				http.Error(w, "Failed to check API key", http.StatusInternalServerError)
+				return
+			}
+			if ctx == nil {
+				Unauthorized(w, "Invalid or expired API key")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if !Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := sessionUser(r)
+		if err != nil {
+			log.Printf("Failed to check session: %v", err)
+			http.Error(w, "Failed to check session", http.StatusInternalServerError)
+			return
+		}
+		if user != nil {
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+			return
+		}
+
+		switch {
+		case publicPaths[r.URL.Path]:
+			next.ServeHTTP(w, r)
+		case strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/v1/") || r.URL.Path == "/ws":
+			Unauthorized(w, "Not signed in")
+		default:
+			http.Redirect(w, r, "/login", http.StatusFound)
+		}
+	})
+}
+
+// Unauthorized sends a 401 in the API's error format.
+func Unauthorized(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusUnauthorized, message)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func sessionUser(r *http.Request) (*database.User, error) {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	return database.GetSessionUser(hashToken(cookie.Value))
+}
+
+// hashToken is what the sessions and API keys tables store in place of the
+// token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession signs the user in on this browser.
+func StartSession(w http.ResponseWriter, r *http.Request, user *database.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	expires := time.Now().Add(SessionLifetime)
+
+	if err := database.CreateSession(user.ID, hashToken(token), expires); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// EndSession signs this browser out.
+func EndSession(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie(CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	return database.DeleteSession(hashToken(cookie.Value))
+}
+
+// isHTTPS also trusts the header set by TLS-terminating reverse proxies.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
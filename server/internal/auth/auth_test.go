@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914, section 11.
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)); got != want {
+		t.Errorf("pbkdf2 = %s, want %s", got, want)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Errorf("expected the password to match")
+	}
+	if CheckPassword(hash, "correct horsE") {
+		t.Errorf("expected another password not to match")
+	}
+	if CheckPassword("plain text", "plain text") {
+		t.Errorf("expected a malformed hash not to match")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	cfg := config.Get()
+	cfg.DBPath = t.TempDir() + "/chat.db"
+	cfg.Auth = true
+	defer func() { cfg.Auth = false }()
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	user, err := database.CreateUser("alice", "unused", database.UserRoleMember)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	login := httptest.NewRecorder()
+	if err := StartSession(login, httptest.NewRequest("POST", "/api/auth/login", nil), user); err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	cookie := login.Result().Cookies()[0]
+
+	var seen *database.User
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = UserFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		path     string
+		cookie   *http.Cookie
+		wantCode int
+		wantUser bool
+	}{
+		{"/api/conversations", cookie, http.StatusOK, true},
+		{"/api/conversations", nil, http.StatusUnauthorized, false},
+		{"/api/conversations", &http.Cookie{Name: CookieName, Value: "forged"}, http.StatusUnauthorized, false},
+		{"/ws", nil, http.StatusUnauthorized, false},
+		{"/api/auth/login", nil, http.StatusOK, false},
+		{"/", nil, http.StatusFound, false},
+	}
+	for _, test := range tests {
+		seen = nil
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.cookie != nil {
+			req.AddCookie(test.cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.wantCode {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.wantCode, rec.Code)
+		}
+		if test.wantUser && (seen == nil || seen.ID != user.ID) {
+			t.Errorf("%s: expected user %s on the context, got %+v", test.path, user.ID, seen)
+		}
+	}
+
+	// The first account is an admin whatever it asked for.
+	if !IsAdmin(WithUser(context.Background(), user)) || user.Role != database.UserRoleAdmin {
+		t.Errorf("expected the first user to be an admin")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	cfg := config.Get()
+	cfg.DBPath = t.TempDir() + "/chat.db"
+	cfg.Auth = true
+	defer func() { cfg.Auth = false }()
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	user, err := database.CreateUser("alice", "unused", database.UserRoleMember)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	_, token, err := NewAPIKey(user.ID, "ci", []string{database.ScopeReadConversations}, nil)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	_, expired, err := NewAPIKey(user.ID, "old", []string{database.ScopeChat}, &past)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+
+	var seen *database.User
+	read := Scope(database.ScopeReadConversations)
+	chat := Scope(database.ScopeChat)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/conversations", read(func(w http.ResponseWriter, r *http.Request) {
+		seen = UserFromContext(r.Context())
+	}))
+	mux.HandleFunc("/api/import", chat(func(w http.ResponseWriter, r *http.Request) {}))
+	mux.HandleFunc("/api/keys", SessionOnly(func(w http.ResponseWriter, r *http.Request) {}))
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		seen = UserFromContext(r.Context())
+	})
+	handler := Middleware(mux)
+
+	tests := []struct {
+		path     string
+		header   string
+		wantCode int
+		wantUser bool
+	}{
+		{"/api/conversations", "Bearer " + token, http.StatusOK, true},
+		{"/api/conversations", "bearer " + token, http.StatusOK, true},
+		{"/api/conversations", "Bearer " + expired, http.StatusUnauthorized, false},
+		{"/api/conversations", "Bearer toc_forged", http.StatusUnauthorized, false},
+		{"/api/import", "Bearer " + token, http.StatusForbidden, false},
+		{"/api/keys", "Bearer " + token, http.StatusForbidden, false},
+		{"/ws?token=" + token, "", http.StatusOK, true},
+		// The query parameter is only read on the upgrade.
+		{"/api/conversations?token=" + token, "", http.StatusUnauthorized, false},
+	}
+	for _, test := range tests {
+		seen = nil
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.wantCode {
+			t.Errorf("%s %q: expected status %d, got %d", test.path, test.header, test.wantCode, rec.Code)
+		}
+		if test.wantUser && (seen == nil || seen.ID != user.ID) {
+			t.Errorf("%s: expected user %s on the context, got %+v", test.path, user.ID, seen)
+		}
+	}
+
+	keys, err := database.ListAPIKeys(user.ID)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d, %v", len(keys), err)
+	}
+	for _, key := range keys {
+		if key.Name == "ci" && key.LastUsedAt == nil {
+			t.Errorf("expected the key's last use to be recorded")
+		}
+		if err := database.DeleteAPIKey(user.ID, key.ID); err != nil {
+			t.Errorf("failed to revoke key: %v", err)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/conversations", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a revoked key to be refused, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// loginPage signs users in, or creates the first admin account on a fresh
+// server. It is self-contained because the app's assets are only served to
+// signed-in users.
+const loginPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in · Tiny Ollama Chat</title>
+<style>
+body { margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; background: #111827; color: #e5e7eb; font-family: system-ui, sans-serif; }
+form { width: 20rem; padding: 2rem; background: #1f2937; border-radius: .75rem; box-shadow: 0 10px 30px rgba(0,0,0,.4); }
+h1 { font-size: 1.25rem; margin: 0 0 1.5rem; }
+label { display: block; font-size: .875rem; margin-bottom: 1rem; }
+input { display: block; width: 100%; box-sizing: border-box; margin-top: .25rem; padding: .5rem; border: 1px solid #374151; border-radius: .375rem; background: #111827; color: inherit; font-size: 1rem; }
+button { width: 100%; padding: .6rem; border: 0; border-radius: .375rem; background: #2563eb; color: white; font-size: 1rem; cursor: pointer; }
+button:disabled { opacity: .6; }
+.error { color: #f87171; font-size: .875rem; min-height: 1.25rem; margin: 0 0 .5rem; }
+.switch { margin-top: 1rem; font-size: .875rem; text-align: center; }
+.switch a { color: #60a5fa; cursor: pointer; }
+[hidden] { display: none; }
+</style>
+</head>
+<body>
+<form id="form">
+  <h1 id="heading">🤖 Sign in</h1>
+  <label>Username <input id="username" autocomplete="username" required></label>
+  <label>Password <input id="password" type="password" autocomplete="current-password" required></label>
+  <p class="error" id="error"></p>
+  <button id="submit">Sign in</button>
+  <p class="switch" id="switch" hidden><a id="toggle">Create an account</a></p>
+</form>
+<script>
+const $ = (id) => document.getElementById(id);
+let registering = false;
+
+function setMode(register, setup) {
+  registering = register;
+  $("heading").textContent = setup ? "🤖 Create the admin account" : register ? "🤖 Create an account" : "🤖 Sign in";
+  $("submit").textContent = register ? "Create account" : "Sign in";
+  $("toggle").textContent = register ? "Sign in instead" : "Create an account";
+  $("password").autocomplete = register ? "new-password" : "current-password";
+}
+
+fetch("/api/auth/status").then((r) => r.json()).then((status) => {
+  if (status.user) { location.replace("/"); return; }
+  if (status.setup_required) { setMode(true, true); return; }
+  $("switch").hidden = !status.signup_allowed;
+});
+
+$("toggle").onclick = () => setMode(!registering, false);
+
+$("form").onsubmit = async (event) => {
+  event.preventDefault();
+  $("error").textContent = "";
+  $("submit").disabled = true;
+  try {
+    const response = await fetch(registering ? "/api/auth/register" : "/api/auth/login", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ username: $("username").value, password: $("password").value }),
+    });
+    if (response.ok) { location.replace("/"); return; }
+    const body = await response.json().catch(() => ({}));
+    $("error").textContent = body.message || "Something went wrong";
+  } finally {
+    $("submit").disabled = false;
+  }
+};
+</script>
+</body>
+</html>
+`
+
+// LoginPage serves the sign-in form. Signed-in users go straight to the app.
+func LoginPage(w http.ResponseWriter, r *http.Request) {
+	if !Enabled() || UserFromContext(r.Context()) != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(loginPage))
+}
@@ -19,11 +19,45 @@ type Config struct {
 	// Server configuration
 	ServerPort int
 
-	// Ollama configuration
+	// Ollama configuration: one URL, or a comma-separated list of hosts
+	// that requests are spread across. See OllamaURLs.
 	OllamaURL string
 
 	// Database configuration
 	DBPath string
+
+	// Title generation: when enabled, the first reply of a conversation
+	// triggers a background request for a concise title. An empty TitleModel
+	// uses the conversation's own model.
+	AutoTitle  bool
+	TitleModel string
+
+	// MCPConfig is the path of a JSON file listing MCP servers whose tools
+	// are offered to models. Empty disables MCP.
+	MCPConfig string
+
+	// BuiltinTools offers the tools that ship with the server, such as the
+	// current time, to every model. Off by default, as models that can't
+	// call tools would otherwise be sent them with every message.
+	BuiltinTools bool
+
+	// ProvidersConfig is the path of a JSON file listing OpenAI-compatible
+	// servers whose models are offered next to Ollama's. Empty uses only
+	// Ollama.
+	ProvidersConfig string
+
+	// ContextBudget is the number of tokens of history sent to an Ollama
+	// model whose conversation doesn't set num_ctx; zero sends everything.
+	// Older turns beyond the budget are dropped, or folded into a
+	// model-written summary when SummarizeHistory is set.
+	ContextBudget    int
+	SummarizeHistory bool
+
+	// Auth requires users to sign in, and each sees only their own
+	// conversations. The first account created is an admin. AllowSignup
+	// lets anyone create an account; otherwise admins add users.
+	Auth        bool
+	AllowSignup bool
 }
 
 // Default configuration values
@@ -31,6 +65,9 @@ const (
 	DefaultServerPort = 8080
 	DefaultOllamaURL  = "http://localhost:11434"
 	DefaultDBPath     = "chat.db"
+
+	// DefaultContextBudget leaves room for the reply in a 4096-token window.
+	DefaultContextBudget = 3072
 )
 
 var (
@@ -45,6 +82,8 @@ func Get() *Config {
 			ServerPort: DefaultServerPort,
 			OllamaURL:  DefaultOllamaURL,
 			DBPath:     DefaultDBPath,
+
+			ContextBudget: DefaultContextBudget,
 		}
 	})
 	return instance
@@ -65,12 +104,22 @@ func ParseFlags() {
 		fmt.Fprintf(flag.CommandLine.Output(), "  Run with default settings:\n    %s\n\n", os.Args[0])
 		fmt.Fprintf(flag.CommandLine.Output(), "  Run on a different port:\n    %s -port=9000\n\n", os.Args[0])
 		fmt.Fprintf(flag.CommandLine.Output(), "  Connect to Ollama on a different machine:\n    %s -ollama-url=http://192.168.1.100:11434\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  Spread requests over several Ollama hosts:\n    %s -ollama-url=http://gpu1:11434,http://gpu2:11434\n\n", os.Args[0])
 	}
 
 	// Define command line flags
 	serverPort := flag.Int("port", DefaultServerPort, "Port for the server to listen on")
-	ollamaURL := flag.String("ollama-url", DefaultOllamaURL, "URL for the Ollama API")
+	ollamaURL := flag.String("ollama-url", DefaultOllamaURL, "URL for the Ollama API, or a comma-separated list of hosts")
 	dbPath := flag.String("db-path", DefaultDBPath, "Path to the SQLite database file")
+	autoTitle := flag.Bool("auto-title", false, "Generate conversation titles with a model after the first reply")
+	titleModel := flag.String("title-model", "", "Model used for generated titles (default: the conversation's model)")
+	mcpConfig := flag.String("mcp-config", "", "Path to a JSON file listing MCP servers to launch")
+	builtinTools := flag.Bool("builtin-tools", false, "Offer the built-in tools, such as the current time, to models")
+	providersConfig := flag.String("providers", "", "Path to a JSON file listing OpenAI-compatible model providers")
+	contextBudget := flag.Int("context-budget", DefaultContextBudget, "Tokens of history sent to Ollama when a conversation doesn't set num_ctx (0 sends everything)")
+	summarizeHistory := flag.Bool("summarize-history", false, "Summarize turns that no longer fit the context instead of dropping them")
+	auth := flag.Bool("auth", false, "Require users to sign in and keep each user's conversations private")
+	allowSignup := flag.Bool("allow-signup", false, "Let anyone create an account when -auth is set (default: admins add users)")
 
 	// Parse flags
 	flag.Parse()
@@ -79,11 +128,35 @@ func ParseFlags() {
 	cfg.ServerPort = *serverPort
 	cfg.OllamaURL = *ollamaURL
 	cfg.DBPath = *dbPath
+	cfg.AutoTitle = *autoTitle
+	cfg.TitleModel = *titleModel
+	cfg.MCPConfig = *mcpConfig
+	cfg.BuiltinTools = *builtinTools
+	cfg.ProvidersConfig = *providersConfig
+	cfg.ContextBudget = *contextBudget
+	cfg.SummarizeHistory = *summarizeHistory
+	cfg.Auth = *auth
+	cfg.AllowSignup = *allowSignup
+
+	// Validate and normalize the URLs
+	urls := cfg.OllamaURLs()
+	for i, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			urls[i] = "http://" + u
+		}
+	}
+	cfg.OllamaURL = strings.Join(urls, ",")
+}
 
-	// Validate and normalize the URL
-	if !strings.HasPrefix(cfg.OllamaURL, "http://") && !strings.HasPrefix(cfg.OllamaURL, "https://") {
-		cfg.OllamaURL = "http://" + cfg.OllamaURL
+// OllamaURLs returns the Ollama hosts listed in OllamaURL.
+func (c *Config) OllamaURLs() []string {
+	var urls []string
+	for _, u := range strings.Split(c.OllamaURL, ",") {
+		if u = strings.TrimSuffix(strings.TrimSpace(u), "/"); u != "" {
+			urls = append(urls, u)
+		}
 	}
+	return urls
 }
 
 // Validate checks if the configuration is valid
@@ -95,27 +168,55 @@ func Validate() error {
 		return fmt.Errorf("invalid port number: %d (must be between 1 and 65535)", cfg.ServerPort)
 	}
 
+	if cfg.ContextBudget < 0 {
+		return fmt.Errorf("invalid context budget: %d (must not be negative)", cfg.ContextBudget)
+	}
+
 	// Validate Ollama URL format
-	parsedURL, err := url.Parse(cfg.OllamaURL)
-	if err != nil {
-		return fmt.Errorf("invalid Ollama URL: %w", err)
+	urls := cfg.OllamaURLs()
+	if len(urls) == 0 {
+		return fmt.Errorf("no Ollama URL given")
 	}
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("unsupported URL scheme: %s (must be http or https)", parsedURL.Scheme)
+	for _, u := range urls {
+		parsedURL, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid Ollama URL: %w", err)
+		}
+		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+			return fmt.Errorf("unsupported URL scheme: %s (must be http or https)", parsedURL.Scheme)
+		}
 	}
 
-	// Check if Ollama is accessible
-	fmt.Printf("Checking Ollama connection at %s... ", cfg.OllamaURL)
+	// Check if Ollama is accessible. With several hosts, one is enough;
+	// the others are used once they come up.
+	var lastErr error
+	connected := 0
+	for _, u := range urls {
+		if err := checkOllama(u); err != nil {
+			lastErr = err
+			continue
+		}
+		connected++
+	}
+	if connected == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// checkOllama reports whether the Ollama server at u answers.
+func checkOllama(u string) error {
+	fmt.Printf("Checking Ollama connection at %s... ", u)
 	client := &http.Client{
 		Timeout: 5 * time.Second,
 	}
 	
-	resp, err := client.Get(cfg.OllamaURL + "/api/tags")
+	resp, err := client.Get(u + "/api/tags")
 	if err != nil {
 		fmt.Println(color.RedString("Failed"))
 		return fmt.Errorf("\n%s cannot connect to Ollama at %s: %w\n%s", 
 			color.RedString("ERROR:"),
-			cfg.OllamaURL,
+			u,
 			err,
 			color.YellowString("\nMake sure Ollama is running and accessible at the specified URL"))
 	}
@@ -141,8 +242,10 @@ func GetServerAddress() string {
 // String returns a string representation of the configuration
 func String() string {
 	cfg := Get()
-	return fmt.Sprintf("Server port: %s, Ollama URL: %s, DB Path: %s", 
+	return fmt.Sprintf("Server port: %s, Ollama URL: %s, DB Path: %s, Auto title: %s, Auth: %s", 
 		color.YellowString("%d", cfg.ServerPort), 
 		color.YellowString("%s", cfg.OllamaURL),
-		color.YellowString("%s", cfg.DBPath))
+		color.YellowString("%s", cfg.DBPath),
+		color.YellowString("%t", cfg.AutoTitle),
+		color.YellowString("%t", cfg.Auth))
 }
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API key scopes. A key can only do what its scopes allow, and never more
+// than its user could.
+const (
+	ScopeReadConversations = "conversations:read" // list, read, search and export conversations
+	ScopeChat              = "chat"               // create and change conversations and generate replies
+	ScopeManageModels      = "models:manage"      // pull and delete models
+)
+
+// Scopes lists every scope a key can be given.
+var Scopes = []string{ScopeReadConversations, ScopeChat, ScopeManageModels}
+
+// APIKey lets scripts call the API as a user without a session. Only a hash
+// of the key is stored; Prefix is kept to tell keys apart when listing them.
+type APIKey struct {
+	ID         string     `gorm:"primaryKey"`
+	UserID     string     `gorm:"not null;default:'';index"` // empty for keys made without accounts
+	Name       string     `gorm:"not null"`
+	Prefix     string     `gorm:"not null"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	Scopes     []string   `gorm:"serializer:json"`
+	ExpiresAt  *time.Time // nil for keys that don't expire
+	LastUsedAt *time.Time
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+func CreateAPIKey(key *APIKey) error {
+	if err := db.Create(key).Error; err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// ListAPIKeys returns the user's keys, newest first, expired ones included.
+func ListAPIKeys(userID string) ([]APIKey, error) {
+	keys := []APIKey{}
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// GetAPIKey returns the key with the given hash, or nil if there is none or
+// it has expired.
+func GetAPIKey(tokenHash string) (*APIKey, error) {
+	var key APIKey
+	err := db.Where("token_hash = ? AND (expires_at IS NULL OR expires_at > ?)", tokenHash, time.Now()).
+		First(&key).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return &key, nil
+}
+
+// TouchAPIKey records that the key was just used.
+func TouchAPIKey(keyID string) error {
+	if err := db.Model(&APIKey{}).Where("id = ?", keyID).Update("last_used_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	return nil
+}
+
+// DeleteAPIKey revokes one of the user's keys.
+func DeleteAPIKey(userID, keyID string) error {
+	result := db.Delete(&APIKey{}, "id = ? AND user_id = ?", keyID, userID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
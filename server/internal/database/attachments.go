@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidAttachment is returned when a message references an image that
+// doesn't exist or was uploaded for another conversation.
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// unclaimedExpiry is how long an upload that no message uses is kept.
+const unclaimedExpiry = 24 * time.Hour
+
+// Attachment is an uploaded image stored as a blob. It belongs to no
+// conversation until a message referencing it is saved.
+type Attachment struct {
+	ID             string    `gorm:"primaryKey"`
+	UserID         string    `gorm:"not null;default:'';index"` // uploader
+	ConversationID *string   `gorm:"index"`
+	Filename       string    `gorm:"not null"`
+	MimeType       string    `gorm:"not null"`
+	Size           int       `gorm:"not null"`
+	Data           []byte    `gorm:"not null" json:"-"`
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// CreateAttachment stores an upload, clearing out old ones that no message
+// ever used on the way.
+func CreateAttachment(userID, filename, mimeType string, data []byte) (*Attachment, error) {
+	attachment := Attachment{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Filename: filename,
+		MimeType: mimeType,
+		Size:     len(data),
+		Data:     data,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id IS NULL AND created_at < ?", time.Now().Add(-unclaimedExpiry)).Delete(&Attachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&attachment).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+func GetAttachment(attachmentID string) (*Attachment, error) {
+	var attachment Attachment
+	if err := db.First(&attachment, "id = ?", attachmentID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// GetAttachmentData returns the contents of the given attachments keyed by ID.
+// Only attachments of the conversation are returned, so a message naming
+// another conversation's image can't reach it.
+func GetAttachmentData(convoID string, attachmentIDs []string) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(attachmentIDs))
+	if len(attachmentIDs) == 0 {
+		return data, nil
+	}
+
+	var attachments []Attachment
+	if err := db.Select("id", "data").Where("id IN ? AND conversation_id = ?", attachmentIDs, convoID).Find(&attachments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	for _, attachment := range attachments {
+		data[attachment.ID] = attachment.Data
+	}
+	return data, nil
+}
+
+// claimAttachments binds uploaded attachments to a conversation, failing if
+// any is missing, was uploaded by another user or already belongs to a
+// different conversation.
+func claimAttachments(tx *gorm.DB, convoID, userID string, attachmentIDs []string) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	result := tx.Model(&Attachment{}).
+		Where("id IN ? AND user_id = ? AND (conversation_id IS NULL OR conversation_id = ?)", attachmentIDs, userID, convoID).
+		Update("conversation_id", convoID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(attachmentIDs)) {
+		return ErrInvalidAttachment
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimAttachments(t *testing.T) {
+	convoID := initTreeDB(t)
+	otherID, err := CreateConversation("", "other", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+
+	upload := func(userID string) string {
+		t.Helper()
+		attachment, err := CreateAttachment(userID, "cat.png", "image/png", []byte("png"))
+		if err != nil {
+			t.Fatalf("failed to create attachment: %v", err)
+		}
+		return attachment.ID
+	}
+	mine, theirs, elsewhere := upload(""), upload("someone-else"), upload("")
+	if _, err := AddMessage(otherID, RoleUser, "look", []string{elsewhere}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+
+	// A message claims its images for the conversation.
+	if _, err := AddMessage(convoID, RoleUser, "look", []string{mine}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	attachment, err := GetAttachment(mine)
+	if err != nil || attachment.ConversationID == nil || *attachment.ConversationID != convoID {
+		t.Errorf("expected the attachment to belong to the conversation, got %+v, %v", attachment, err)
+	}
+
+	// Reusing an image in the same conversation is fine.
+	if _, err := AddMessage(convoID, RoleUser, "again", []string{mine}); err != nil {
+		t.Errorf("expected an image to be reused in its conversation, got %v", err)
+	}
+
+	for name, id := range map[string]string{
+		"another user's upload":        theirs,
+		"another conversation's image": elsewhere,
+		"a missing attachment":         "missing",
+	} {
+		if _, err := AddMessage(convoID, RoleUser, "look", []string{id}); !errors.Is(err, ErrInvalidAttachment) {
+			t.Errorf("expected ErrInvalidAttachment for %s, got %v", name, err)
+		}
+	}
+	if attachment, _ := GetAttachment(theirs); attachment.ConversationID != nil {
+		t.Errorf("expected a refused claim to leave the attachment alone, got %v", *attachment.ConversationID)
+	}
+
+	data, err := GetAttachmentData(convoID, []string{mine, elsewhere})
+	if err != nil || len(data) != 1 || string(data[mine]) != "png" {
+		t.Errorf("expected only the conversation's image, got %v, %v", data, err)
+	}
+}
+
+func TestUnclaimedAttachmentsExpire(t *testing.T) {
+	convoID := initTreeDB(t)
+
+	stale, err := CreateAttachment("", "old.png", "image/png", []byte("png"))
+	if err != nil {
+		t.Fatalf("failed to create attachment: %v", err)
+	}
+	used, err := CreateAttachment("", "used.png", "image/png", []byte("png"))
+	if err != nil {
+		t.Fatalf("failed to create attachment: %v", err)
+	}
+	if _, err := AddMessage(convoID, RoleUser, "look", []string{used.ID}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	old := time.Now().Add(-unclaimedExpiry - time.Hour)
+	if err := db.Model(&Attachment{}).Where("id IN ?", []string{stale.ID, used.ID}).Update("created_at", old).Error; err != nil {
+		t.Fatalf("failed to age attachments: %v", err)
+	}
+
+	// The next upload clears out the old one no message used.
+	fresh, err := CreateAttachment("", "new.png", "image/png", []byte("png"))
+	if err != nil {
+		t.Fatalf("failed to create attachment: %v", err)
+	}
+	for id, kept := range map[string]bool{stale.ID: false, used.ID: true, fresh.ID: true} {
+		if attachment, err := GetAttachment(id); err != nil || (attachment != nil) != kept {
+			t.Errorf("expected attachment %s kept=%t, got %+v, %v", id, kept, attachment, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Comparison records several models answering the same prompt side by side.
+// The answers are sibling assistant messages below PromptID that point back
+// through their ComparisonID, and PreferredID keeps the one the user liked
+// best, for judging models against each other later.
+type Comparison struct {
+	ID             string    `gorm:"primaryKey"`
+	ConversationID string    `gorm:"not null;index"`
+	PromptID       string    `gorm:"not null"`        // user message the models answered
+	Models         []string  `gorm:"serializer:json"` // in the order they were asked for
+	PreferredID    *string   // preferred answer, nil until the user picks one
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	Answers        []Message `gorm:"foreignKey:ComparisonID"`
+}
+
+// ErrNotCompared is returned when preferring a message that isn't an answer
+// of a comparison.
+var ErrNotCompared = errors.New("message is not part of a comparison")
+
+func CreateComparison(convoID, promptID string, models []string) (*Comparison, error) {
+	comparison := Comparison{
+		ID:             uuid.New().String(),
+		ConversationID: convoID,
+		PromptID:       promptID,
+		Models:         models,
+	}
+	if err := db.Create(&comparison).Error; err != nil {
+		return nil, fmt.Errorf("failed to create comparison: %w", err)
+	}
+	return &comparison, nil
+}
+
+// DeleteComparison removes a comparison whose models all failed to answer.
+func DeleteComparison(comparisonID string) error {
+	if err := db.Delete(&Comparison{}, "id = ?", comparisonID).Error; err != nil {
+		return fmt.Errorf("failed to delete comparison: %w", err)
+	}
+	return nil
+}
+
+// PreferAnswer marks messageID as the preferred answer of its comparison,
+// replacing an earlier choice.
+func PreferAnswer(messageID string) (*Comparison, error) {
+	message, err := GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrNotFound
+	}
+	if message.ComparisonID == nil {
+		return nil, ErrNotCompared
+	}
+
+	var comparison Comparison
+	if err := db.First(&comparison, "id = ?", *message.ComparisonID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotCompared
+		}
+		return nil, fmt.Errorf("failed to get comparison: %w", err)
+	}
+	if err := db.Model(&comparison).Update("preferred_id", messageID).Error; err != nil {
+		return nil, fmt.Errorf("failed to prefer answer: %w", err)
+	}
+	comparison.PreferredID = &messageID
+	return &comparison, nil
+}
+
+// ListComparisons returns the comparisons in the user's conversations with
+// their answers, newest first.
+func ListComparisons(userID string) ([]Comparison, error) {
+	var comparisons []Comparison
+	err := db.Preload("Answers", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("created_at asc")
+	}).
+		Joins("JOIN conversations ON conversations.id = comparisons.conversation_id").
+		Where("conversations.user_id = ?", userID).
+		Order("comparisons.created_at desc").
+		Find(&comparisons).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comparisons: %w", err)
+	}
+	return comparisons, nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+)
+
+func TestComparisons(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	convoID, err := CreateConversation("alice", "compare", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	prompt, err := AddMessage(convoID, RoleUser, "hi", nil)
+	if err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+
+	comparison, err := CreateComparison(convoID, prompt.ID, []string{"llama3", "qwen3"})
+	if err != nil {
+		t.Fatalf("failed to create comparison: %v", err)
+	}
+	var answers []Message
+	for _, model := range comparison.Models {
+		answer := Message{
+			ConversationID: convoID,
+			ParentID:       &prompt.ID,
+			Role:           RoleAssistant,
+			Content:        "hello from " + model,
+			Model:          model,
+			ComparisonID:   &comparison.ID,
+		}
+		if err := AddMessageWithThinking(&answer); err != nil {
+			t.Fatalf("failed to add answer: %v", err)
+		}
+		answers = append(answers, answer)
+	}
+
+	if _, err := PreferAnswer(prompt.ID); !errors.Is(err, ErrNotCompared) {
+		t.Errorf("expected the prompt not to be preferable, got %v", err)
+	}
+	preferred, err := PreferAnswer(answers[1].ID)
+	if err != nil || preferred.PreferredID == nil || *preferred.PreferredID != answers[1].ID {
+		t.Fatalf("expected the second answer to be preferred, got %+v, %v", preferred, err)
+	}
+
+	comparisons, err := ListComparisons("alice")
+	if err != nil || len(comparisons) != 1 {
+		t.Fatalf("expected one comparison, got %d, %v", len(comparisons), err)
+	}
+	if got := comparisons[0]; len(got.Answers) != 2 || got.Answers[0].Model != "llama3" || *got.PreferredID != answers[1].ID {
+		t.Errorf("expected both answers and the preference, got %+v", got)
+	}
+	if others, _ := ListComparisons("bob"); len(others) != 0 {
+		t.Errorf("expected other users not to see the comparison, got %d", len(others))
+	}
+
+	if err := DeleteConversation(convoID); err != nil {
+		t.Fatalf("failed to delete conversation: %v", err)
+	}
+	var left int64
+	if db.Model(&Comparison{}).Count(&left); left != 0 {
+		t.Errorf("expected the comparison to go with its conversation, got %d", left)
+	}
+}
@@ -1,11 +1,15 @@
 package database
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/ollama"
 
 	"github.com/glebarez/sqlite"
 	"github.com/google/uuid"
@@ -14,6 +18,11 @@ import (
 
 var db *gorm.DB
 
+const maxTitleLength = 30
+
+// ErrNotFound is returned by updates that match no rows.
+var ErrNotFound = errors.New("record not found")
+
 func InitDB() error {
 	var err error
 
@@ -33,55 +42,128 @@ func InitDB() error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	if err := db.AutoMigrate(&Conversation{}, &Message{}); err != nil {
+	if err := db.AutoMigrate(&Conversation{}, &Message{}, &Attachment{}, &Summary{}, &User{}, &Session{}, &APIKey{}, &Comparison{}); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if err := backfillMessageTree(); err != nil {
+		return fmt.Errorf("failed to migrate message history: %w", err)
+	}
+
+	if err := initSearchIndex(); err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
 	return nil
 }
 
-func CreateConversation(title, model string) (string, error) {
+// TitleFromMessage derives a conversation title from its first message,
+// shortening it to maxTitleLength characters without splitting a rune.
+func TitleFromMessage(message string) string {
+	title := strings.Join(strings.Fields(message), " ")
+	runes := []rune(title)
+	if len(runes) > maxTitleLength {
+		return strings.TrimSpace(string(runes[:maxTitleLength])) + "..."
+	}
+	return title
+}
+
+// CreateConversation starts a conversation owned by userID, which is empty
+// when accounts are disabled.
+func CreateConversation(userID, title, model, systemPrompt string, options *ollama.Options) (string, error) {
 	convoID := uuid.New().String()
 	convo := Conversation{
-		ID:    convoID,
-		Title: title,
-		Model: model,
+		ID:           convoID,
+		UserID:       userID,
+		Title:        title,
+		Model:        model,
+		SystemPrompt: systemPrompt,
+		Options:      options,
 	}
 
-	if err := db.Create(&convo).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&convo).Error; err != nil {
+			return err
+		}
+		return indexTitle(tx, convoID, title)
+	})
+	if err != nil {
 		return "", fmt.Errorf("failed to create conversation: %w", err)
 	}
 
 	return convoID, nil
 }
 
-func AddMessage(convoID, role, content string) error {
+// AddMessage appends a message to the end of the conversation's active branch.
+// images lists previously uploaded attachment IDs to send along with it.
+func AddMessage(convoID, role, content string, images []string) (*Message, error) {
 	message := Message{
-		ID:             uuid.New().String(),
 		ConversationID: convoID,
 		Role:           role,
 		Content:        content,
 		RawContent:     content,
+		Images:         images,
 	}
 
-	if err := db.Create(&message).Error; err != nil {
-		return fmt.Errorf("failed to add message: %w", err)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var convo Conversation
+		if err := tx.Select("active_leaf_id", "user_id").First(&convo, "id = ?", convoID).Error; err != nil {
+			return err
+		}
+		if err := claimAttachments(tx, convoID, convo.UserID, images); err != nil {
+			return err
+		}
+		message.ParentID = convo.ActiveLeafID
+		return insertMessage(tx, &message)
+	})
+	if errors.Is(err, ErrInvalidAttachment) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add message: %w", err)
 	}
 
-	return nil
+	return &message, nil
 }
 
+// GetMessagesByConversationID returns the active branch of the conversation,
+// from its first message down to the active leaf.
 func GetMessagesByConversationID(convoID string) ([]Message, error) {
-	var messages []Message
-	if err := db.Where("conversation_id = ?", convoID).Order("created_at asc").Find(&messages).Error; err != nil {
+	var convo Conversation
+	if err := db.Select("active_leaf_id").First(&convo, "id = ?", convoID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+
+	messages, err := activePath(db, convoID, convo.ActiveLeafID)
+	if err != nil {
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
 	return messages, nil
 }
 
+// GetConversationByID loads a conversation with Messages set to its active
+// branch.
 func GetConversationByID(convoID string) (*Conversation, error) {
 	var convo Conversation
-	if err := db.Preload("Messages").First(&convo, "id = ?", convoID).Error; err != nil {
+	if err := db.First(&convo, "id = ?", convoID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	messages, err := activePath(db, convoID, convo.ActiveLeafID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	convo.Messages = messages
+	return &convo, nil
+}
+
+// GetConversationMetadata loads a conversation without its messages.
+func GetConversationMetadata(convoID string) (*Conversation, error) {
+	var convo Conversation
+	if err := db.First(&convo, "id = ?", convoID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, nil
 		}
@@ -90,9 +172,12 @@ func GetConversationByID(convoID string) (*Conversation, error) {
 	return &convo, nil
 }
 
-func ListConversations() ([]Conversation, error) {
+// ListConversations returns the user's pinned conversations first, then the
+// most recently updated. Archived conversations are listed instead of the
+// others when archived is true.
+func ListConversations(userID string, archived bool) ([]Conversation, error) {
 	var convos []Conversation
-	if err := db.Order("updated_at desc").Find(&convos).Error; err != nil {
+	if err := db.Where("user_id = ? AND archived = ?", userID, archived).Order("pinned desc").Order("updated_at desc").Find(&convos).Error; err != nil {
 		return nil, fmt.Errorf("failed to list conversations: %w", err)
 	}
 	return convos, nil
@@ -105,6 +190,133 @@ func UpdateConversation(convoID string) error {
 	return nil
 }
 
+// ConversationPatch lists the metadata fields to change. Nil fields are left
+// as they are.
+type ConversationPatch struct {
+	Title        *string
+	Model        *string
+	SystemPrompt *string
+	Pinned       *bool
+	Archived     *bool
+
+	DisabledMCPServers *[]string
+}
+
+// PatchConversation applies the patch and returns the updated conversation
+// without its messages.
+func PatchConversation(convoID string, patch ConversationPatch) (*Conversation, error) {
+	updates := map[string]interface{}{
+		"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+	}
+	if patch.Title != nil {
+		updates["title"] = *patch.Title
+	}
+	if patch.Model != nil {
+		updates["model"] = *patch.Model
+	}
+	if patch.SystemPrompt != nil {
+		updates["system_prompt"] = *patch.SystemPrompt
+	}
+	if patch.Pinned != nil {
+		updates["pinned"] = *patch.Pinned
+	}
+	if patch.Archived != nil {
+		updates["archived"] = *patch.Archived
+	}
+	if patch.DisabledMCPServers != nil {
+		// Map updates bypass the field's serializer.
+		data, err := json.Marshal(*patch.DisabledMCPServers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode disabled servers: %w", err)
+		}
+		updates["disabled_mcp_servers"] = string(data)
+	}
+
+	var convo Conversation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Conversation{}).Where("id = ?", convoID).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if patch.Title != nil {
+			if err := indexTitle(tx, convoID, *patch.Title); err != nil {
+				return err
+			}
+		}
+		return tx.First(&convo, "id = ?", convoID).Error
+	})
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+	return &convo, nil
+}
+
+// ReplaceTitle sets a new title only if the current one is still
+// expectedTitle, so a rename made in the meantime is never overwritten. It
+// reports whether the title was changed.
+func ReplaceTitle(convoID, expectedTitle, title string) (bool, error) {
+	replaced := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Conversation{}).Where("id = ? AND title = ?", convoID, expectedTitle).Update("title", title)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		replaced = true
+		return indexTitle(tx, convoID, title)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to replace title: %w", err)
+	}
+	return replaced, nil
+}
+
+func UpdateSystemPrompt(convoID, systemPrompt string) error {
+	result := db.Model(&Conversation{}).Where("id = ?", convoID).Updates(map[string]interface{}{
+		"system_prompt": systemPrompt,
+		"updated_at":    gorm.Expr("CURRENT_TIMESTAMP"),
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update system prompt: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func UpdateConversationOptions(convoID string, options *ollama.Options) error {
+	// Map updates bypass the field's serializer, and nil clears the options.
+	var stored interface{}
+	if options != nil {
+		data, err := json.Marshal(options)
+		if err != nil {
+			return fmt.Errorf("failed to encode options: %w", err)
+		}
+		stored = string(data)
+	}
+
+	result := db.Model(&Conversation{}).Where("id = ?", convoID).Updates(map[string]interface{}{
+		"options":    stored,
+		"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update options: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func DeleteConversation(convoID string) error {
 	tx := db.Begin()
 	defer func() {
@@ -118,26 +330,41 @@ func DeleteConversation(convoID string) error {
 		return fmt.Errorf("failed to delete messages: %w", err)
 	}
 
+	if err := tx.Where("conversation_id = ?", convoID).Delete(&Attachment{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	if err := tx.Where("conversation_id = ?", convoID).Delete(&Summary{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete summaries: %w", err)
+	}
+
+	if err := tx.Where("conversation_id = ?", convoID).Delete(&Comparison{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete comparisons: %w", err)
+	}
+
 	if err := tx.Delete(&Conversation{}, "id = ?", convoID).Error; err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to delete conversation: %w", err)
 	}
 
+	if err := unindexConversation(tx, convoID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to remove conversation from search index: %w", err)
+	}
+
 	return tx.Commit().Error
 }
 
-func AddMessageWithThinking(convoID, role, content, rawContent string, thinking *string, thinkingTime *float64) error {
-	message := Message{
-		ID:             uuid.New().String(),
-		ConversationID: convoID,
-		Role:           role,
-		Content:        content,
-		RawContent:     rawContent,
-		Thinking:       thinking,
-		ThinkingTime:   thinkingTime,
-	}
-
-	if err := db.Create(&message).Error; err != nil {
+// AddMessageWithThinking stores a reply built by the caller, including its
+// thinking and interruption state, under message.ParentID and makes it the
+// active leaf. The ID is assigned here.
+func AddMessageWithThinking(message *Message) error {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return insertMessage(tx, message)
+	}); err != nil {
 		return fmt.Errorf("failed to add message with thinking: %w", err)
 	}
 
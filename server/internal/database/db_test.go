@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+func TestTitleFromMessage(t *testing.T) {
+	cases := map[string]string{
+		"short question":                          "short question",
+		"  spread\nover   lines ":                 "spread over lines",
+		"this message is definitely longer than":  "this message is definitely lon...",
+		"ünïcödé ünïcödé ünïcödé ünïcödé ünïcödé": "ünïcödé ünïcödé ünïcödé ünïcöd...",
+	}
+
+	for input, expected := range cases {
+		if got := TitleFromMessage(input); got != expected {
+			t.Errorf("TitleFromMessage(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestConversationOptions(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	seed := 1
+	convoID, err := CreateConversation("", "options", "llama3", "", &ollama.Options{Seed: &seed})
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	options := func() *ollama.Options {
+		t.Helper()
+		convo, err := GetConversationMetadata(convoID)
+		if err != nil || convo == nil {
+			t.Fatalf("failed to get conversation: %v", err)
+		}
+		return convo.Options
+	}
+	if got := options(); got == nil || got.Seed == nil || *got.Seed != 1 {
+		t.Errorf("expected the stored options, got %+v", got)
+	}
+
+	// Options are kept as JSON text.
+	if err := db.Exec("UPDATE conversations SET options = ? WHERE id = ?", `{"seed":2}`, convoID).Error; err != nil {
+		t.Fatalf("failed to write options: %v", err)
+	}
+	if got := options(); got == nil || got.Seed == nil || *got.Seed != 2 {
+		t.Errorf("expected options stored as text to be read, got %+v", got)
+	}
+
+	seed = 3
+	if err := UpdateConversationOptions(convoID, &ollama.Options{Seed: &seed}); err != nil {
+		t.Fatalf("failed to update options: %v", err)
+	}
+	if got := options(); got == nil || got.Seed == nil || *got.Seed != 3 {
+		t.Errorf("expected the updated options, got %+v", got)
+	}
+
+	if err := UpdateConversationOptions(convoID, nil); err != nil {
+		t.Fatalf("failed to clear options: %v", err)
+	}
+	if got := options(); got != nil {
+		t.Errorf("expected no options, got %+v", got)
+	}
+	var stored *string
+	if err := db.Raw("SELECT options FROM conversations WHERE id = ?", convoID).Scan(&stored).Error; err != nil || stored != nil {
+		t.Errorf("expected cleared options to be stored as NULL, got %v, %v", stored, err)
+	}
+
+	if err := UpdateConversationOptions("missing", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -2,30 +2,81 @@ package database
 
 import (
 	"time"
+
+	"ollama-tiny-chat/server/internal/ollama"
 )
 
 type Conversation struct {
-    ID        string    `gorm:"primaryKey"`
-    Title     string    `gorm:"not null"`
-    Model     string    `gorm:"not null"`
-    CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
-    UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
-    Messages  []Message `gorm:"foreignKey:ConversationID"`
+	ID           string          `gorm:"primaryKey"`
+	UserID       string          `gorm:"not null;default:'';index"` // owner; empty for conversations made without accounts
+	Title        string          `gorm:"not null"`
+	Model        string          `gorm:"not null"`
+	SystemPrompt string          `gorm:"not null;default:''"` // sent as the first message of every request
+	Options      *ollama.Options `gorm:"serializer:json"`     // default generation options, nil for model defaults
+	ActiveLeafID *string         // last message of the branch currently shown and sent to the model
+	Pinned       bool            `gorm:"not null;default:false"`
+	Archived     bool            `gorm:"not null;default:false"` // hidden from the default conversation list
+
+	DisabledMCPServers []string `gorm:"serializer:json"` // MCP servers whose tools are not offered in this conversation
+
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	Messages  []Message `gorm:"foreignKey:ConversationID"`
 }
 
 type Message struct {
-    ID             string    `gorm:"primaryKey"`
-    ConversationID string    `gorm:"not null;index"`
-    Role           string    `gorm:"not null"` // "user" or "assistant"
-    Content        string    `gorm:"not null"` // full message text
-    RawContent     string    `gorm:"not null"` // message without extra formatting
-    Thinking       *string   // optional for Ollama thinking tags
-    ThinkingTime   *float64  // optional, time spent in thinking (seconds)
-    CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	ID             string            `gorm:"primaryKey"`
+	ConversationID string            `gorm:"not null;index"`
+	ParentID       *string           `gorm:"index"`               // previous turn, nil for the first message; siblings are alternative branches
+	Role           string            `gorm:"not null"`            // "user", "assistant" or "tool"
+	Content        string            `gorm:"not null"`            // full message text
+	RawContent     string            `gorm:"not null"`            // message without extra formatting
+	Images         []string          `gorm:"serializer:json"`     // attachment IDs sent with the message
+	ToolCalls      []ollama.ToolCall `gorm:"serializer:json"`     // functions an assistant message asked to run
+	ToolName       string            `gorm:"not null;default:''"` // tool whose result a "tool" message holds
+	Thinking       *string           // optional for Ollama thinking tags
+	ThinkingTime   *float64          // optional, time spent in thinking (seconds)
+	Interrupted    bool              `gorm:"not null;default:false"` // generation was cancelled before completion
+	Model          string            `gorm:"not null;default:''"`    // model that wrote an assistant message
+	ComparisonID   *string           `gorm:"index"`                  // comparison the assistant message answered, if any
+	Stats          *MessageStats     `gorm:"embedded;embeddedPrefix:stats_"`
+	CreatedAt      time.Time         `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// MessageStats are Ollama's token counts and timings for an assistant reply,
+// nil when the stream ended before Ollama reported them. Durations are in
+// nanoseconds.
+type MessageStats struct {
+	PromptTokens       int     `json:"prompt_tokens"`
+	CompletionTokens   int     `json:"completion_tokens"`
+	TotalDuration      int64   `json:"total_duration"`
+	LoadDuration       int64   `json:"load_duration"`
+	PromptEvalDuration int64   `json:"prompt_eval_duration"`
+	EvalDuration       int64   `json:"eval_duration"`
+	TokensPerSecond    float64 `json:"tokens_per_second"`
+}
+
+// NewMessageStats converts the metrics of a final chunk, returning nil when
+// Ollama didn't report any.
+func NewMessageStats(metrics ollama.Metrics) *MessageStats {
+	if metrics.TotalDuration == 0 && metrics.EvalCount == 0 {
+		return nil
+	}
+	return &MessageStats{
+		PromptTokens:       metrics.PromptEvalCount,
+		CompletionTokens:   metrics.EvalCount,
+		TotalDuration:      metrics.TotalDuration,
+		LoadDuration:       metrics.LoadDuration,
+		PromptEvalDuration: metrics.PromptEvalDuration,
+		EvalDuration:       metrics.EvalDuration,
+		TokensPerSecond:    metrics.TokensPerSecond(),
+	}
 }
 
 // Constants for role types
 const (
-    RoleUser      = "user"
-    RoleAssistant = "assistant"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleSystem    = "system"
+	RoleTool      = "tool"
 )
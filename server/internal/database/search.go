@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// searchTable is an FTS5 index holding one row per message and one per
+// conversation title. Title rows have an empty message_id.
+const searchTable = "search_index"
+
+// Markers wrapped around matched terms in SearchResult.Snippet.
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// FTS5 marks matches with control characters, which become the highlight
+// markers once the text around them has been escaped.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+var highlighter = strings.NewReplacer(matchStart, HighlightStart, matchEnd, HighlightEnd)
+
+type SearchResult struct {
+	ConversationID string  `json:"conversation_id"`
+	Title          string  `json:"title"`
+	MessageID      string  `json:"message_id,omitempty"` // empty when the title matched
+	Role           string  `json:"role,omitempty"`
+	Snippet        string  `json:"snippet"` // escaped HTML with matches in <mark>
+	Rank           float64 `json:"rank"`    // bm25 score, lower is more relevant
+}
+
+// initSearchIndex creates the FTS5 table on first start and fills it from the
+// existing conversations and messages.
+func initSearchIndex() error {
+	if db.Migrator().HasTable(searchTable) {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`CREATE VIRTUAL TABLE ` + searchTable + ` USING fts5(
+			conversation_id UNINDEXED,
+			message_id UNINDEXED,
+			role UNINDEXED,
+			title,
+			content,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)`).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(`INSERT INTO ` + searchTable + ` (conversation_id, message_id, role, title, content)
+			SELECT id, '', '', title, '' FROM conversations`).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`INSERT INTO ` + searchTable + ` (conversation_id, message_id, role, title, content)
+			SELECT conversation_id, id, role, '', content FROM messages`).Error
+	})
+}
+
+func indexMessage(tx *gorm.DB, message *Message) error {
+	return tx.Exec(`INSERT INTO `+searchTable+` (conversation_id, message_id, role, title, content) VALUES (?, ?, ?, '', ?)`,
+		message.ConversationID, message.ID, message.Role, message.Content).Error
+}
+
+// indexTitle replaces the title row of a conversation.
+func indexTitle(tx *gorm.DB, convoID, title string) error {
+	if err := tx.Exec(`DELETE FROM `+searchTable+` WHERE conversation_id = ? AND message_id = ''`, convoID).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`INSERT INTO `+searchTable+` (conversation_id, message_id, role, title, content) VALUES (?, '', '', ?, '')`,
+		convoID, title).Error
+}
+
+func unindexConversation(tx *gorm.DB, convoID string) error {
+	return tx.Exec(`DELETE FROM `+searchTable+` WHERE conversation_id = ?`, convoID).Error
+}
+
+// ftsQuery turns free text into an FTS5 query that matches every word,
+// quoting each one so user input can't inject query syntax. The last word
+// is matched as a prefix to support search-as-you-type.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// Search returns messages and conversation titles of the user's
+// conversations matching every word of text, most relevant first.
+func Search(userID, text string, limit int) ([]SearchResult, error) {
+	results := []SearchResult{}
+
+	query := ftsQuery(text)
+	if query == "" {
+		return results, nil
+	}
+
+	err := db.Raw(`SELECT `+searchTable+`.conversation_id, c.title, message_id, role,
+			snippet(`+searchTable+`, -1, ?, ?, '…', 16) AS snippet,
+			bm25(`+searchTable+`) AS rank
+		FROM `+searchTable+`
+		JOIN conversations c ON c.id = `+searchTable+`.conversation_id
+		WHERE `+searchTable+` MATCH ? AND c.user_id = ?
+		ORDER BY rank
+		LIMIT ?`, matchStart, matchEnd, query, userID, limit).Scan(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	// Messages hold model output and imported text, so the snippet is
+	// escaped before it is marked up.
+	for i := range results {
+		results[i].Snippet = highlighter.Replace(html.EscapeString(results[i].Snippet))
+	}
+	return results, nil
+}
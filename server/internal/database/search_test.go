@@ -0,0 +1,90 @@
+package database
+
+import (
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+)
+
+func TestFTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"":                   "",
+		"   ":                "",
+		"hello":              `"hello"*`,
+		"hello  world":       `"hello" "world"*`,
+		`say "hi" OR NOT x*`: `"say" """hi""" "OR" "NOT" "x*"*`,
+	}
+
+	for input, expected := range cases {
+		if got := ftsQuery(input); got != expected {
+			t.Errorf("ftsQuery(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	convoID, err := CreateConversation("alice", "Penguin facts", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	if _, err := AddMessage(convoID, RoleUser, "Where do penguins live?", nil); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	reply := Message{ConversationID: convoID, Role: RoleAssistant, Content: "Mostly in <b>Antarctica</b>.", RawContent: "Mostly in Antarctica."}
+	if err := AddMessageWithThinking(&reply); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+
+	search := func(text string) []SearchResult {
+		t.Helper()
+		results, err := Search("alice", text, 10)
+		if err != nil {
+			t.Fatalf("failed to search: %v", err)
+		}
+		return results
+	}
+
+	if results := search("penguin"); len(results) != 2 {
+		t.Errorf("expected the title and the prompt to match, got %+v", results)
+	}
+	results := search("antarc")
+	if len(results) != 1 || results[0].MessageID != reply.ID {
+		t.Fatalf("expected the reply to match, got %+v", results)
+	}
+	if expected := "Mostly in &lt;b&gt;<mark>Antarctica</mark>&lt;/b&gt;."; results[0].Snippet != expected {
+		t.Errorf("expected the snippet escaped around the highlight, got %q", results[0].Snippet)
+	}
+	if results, _ := Search("bob", "antarctica", 10); len(results) != 0 {
+		t.Errorf("expected other users' conversations to be left out, got %+v", results)
+	}
+
+	title := "Seabirds"
+	if _, err := PatchConversation(convoID, ConversationPatch{Title: &title}); err != nil {
+		t.Fatalf("failed to rename conversation: %v", err)
+	}
+	if results := search("seabirds"); len(results) != 1 || results[0].MessageID != "" {
+		t.Errorf("expected the new title to match, got %+v", results)
+	}
+	if results := search("facts"); len(results) != 0 {
+		t.Errorf("expected the old title to be gone, got %+v", results)
+	}
+	if ok, err := ReplaceTitle(convoID, title, "Flightless birds"); !ok || err != nil {
+		t.Fatalf("failed to replace title: %t, %v", ok, err)
+	}
+	if results := search("flightless"); len(results) != 1 {
+		t.Errorf("expected the replaced title to match, got %+v", results)
+	}
+
+	if err := DeleteConversation(convoID); err != nil {
+		t.Fatalf("failed to delete conversation: %v", err)
+	}
+	var rows int64
+	if err := db.Table(searchTable).Where("conversation_id = ?", convoID).Count(&rows).Error; err != nil || rows != 0 {
+		t.Errorf("expected the deleted conversation to leave the index, got %d rows, %v", rows, err)
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ModelStats aggregates the replies of one model, over a day in
+// UsageStats.Daily or over the whole period in UsageStats.Models. Durations
+// are in nanoseconds and speeds in tokens per second.
+type ModelStats struct {
+	Model                 string  `json:"model"`
+	Day                   string  `json:"day,omitempty"` // UTC date, YYYY-MM-DD
+	Messages              int     `json:"messages"`
+	PromptTokens          int64   `json:"prompt_tokens"`
+	CompletionTokens      int64   `json:"completion_tokens"`
+	TotalDuration         int64   `json:"total_duration"`
+	LoadDuration          int64   `json:"load_duration"`
+	PromptEvalDuration    int64   `json:"prompt_eval_duration"`
+	EvalDuration          int64   `json:"eval_duration"`
+	TokensPerSecond       float64 `json:"tokens_per_second"`
+	PromptTokensPerSecond float64 `json:"prompt_tokens_per_second"`
+}
+
+// UserStats sums the replies written in one user's conversations.
+type UserStats struct {
+	UserID           string `json:"user_id"`
+	Username         string `json:"username"`
+	Messages         int    `json:"messages"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+type UsageStats struct {
+	Since  string       `json:"since"`
+	Models []ModelStats `json:"models"`
+	Daily  []ModelStats `json:"daily"`
+	Users  []UserStats  `json:"users,omitempty"` // only across all users
+}
+
+// statsModel attributes messages saved before they recorded their model to
+// their conversation's model.
+const statsModel = `COALESCE(NULLIF(messages.model, ''), conversations.model)`
+
+// statsColumns sums the stats of assistant messages.
+const statsColumns = statsModel + ` AS model,
+	COUNT(*) AS messages,
+	SUM(messages.stats_prompt_tokens) AS prompt_tokens,
+	SUM(messages.stats_completion_tokens) AS completion_tokens,
+	SUM(messages.stats_total_duration) AS total_duration,
+	SUM(messages.stats_load_duration) AS load_duration,
+	SUM(messages.stats_prompt_eval_duration) AS prompt_eval_duration,
+	SUM(messages.stats_eval_duration) AS eval_duration`
+
+// GetUsageStats aggregates token counts and timings of the replies written
+// since the given day, per model and per model and day. Speeds are computed
+// from the summed counts and durations, so long replies weigh more than
+// short ones. A nil userID covers every user and adds a per-user summary.
+func GetUsageStats(since time.Time, userID *string) (*UsageStats, error) {
+	stats := &UsageStats{
+		Since:  since.UTC().Format(time.DateOnly),
+		Models: []ModelStats{},
+		Daily:  []ModelStats{},
+	}
+
+	base := db.Table("messages").
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("messages.role = ? AND messages.stats_completion_tokens IS NOT NULL", RoleAssistant).
+		Where("date(messages.created_at) >= ?", stats.Since)
+	if userID != nil {
+		base = base.Where("conversations.user_id = ?", *userID)
+	}
+	base = base.Session(&gorm.Session{})
+
+	if err := base.Select(statsColumns).
+		Group(statsModel).Order("completion_tokens DESC").
+		Scan(&stats.Models).Error; err != nil {
+		return nil, fmt.Errorf("failed to get model stats: %w", err)
+	}
+
+	if err := base.Select(statsColumns + `, date(messages.created_at) AS day`).
+		Group(statsModel + ", day").Order("day DESC, model").
+		Scan(&stats.Daily).Error; err != nil {
+		return nil, fmt.Errorf("failed to get daily stats: %w", err)
+	}
+
+	if userID == nil {
+		stats.Users = []UserStats{}
+		if err := base.Select(`conversations.user_id AS user_id,
+				COALESCE(users.username, '') AS username,
+				COUNT(*) AS messages,
+				SUM(messages.stats_prompt_tokens) AS prompt_tokens,
+				SUM(messages.stats_completion_tokens) AS completion_tokens`).
+			Joins("LEFT JOIN users ON users.id = conversations.user_id").
+			Group("conversations.user_id").Order("completion_tokens DESC").
+			Scan(&stats.Users).Error; err != nil {
+			return nil, fmt.Errorf("failed to get user stats: %w", err)
+		}
+	}
+
+	for _, list := range [][]ModelStats{stats.Models, stats.Daily} {
+		for i := range list {
+			list[i].TokensPerSecond = perSecond(list[i].CompletionTokens, list[i].EvalDuration)
+			list[i].PromptTokensPerSecond = perSecond(list[i].PromptTokens, list[i].PromptEvalDuration)
+		}
+	}
+	return stats, nil
+}
+
+func perSecond(tokens, duration int64) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return float64(tokens) / (float64(duration) / 1e9)
+}
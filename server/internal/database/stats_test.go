@@ -0,0 +1,83 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+)
+
+func TestGetUsageStats(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	convoID, err := CreateConversation("", "stats", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	prompt, err := AddMessage(convoID, RoleUser, "hi", nil)
+	if err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+
+	replies := []Message{
+		// Written before messages recorded their model.
+		{Stats: &MessageStats{PromptTokens: 10, CompletionTokens: 20, PromptEvalDuration: 1e8, EvalDuration: 1e9}},
+		{Model: "llama3", Stats: &MessageStats{PromptTokens: 10, CompletionTokens: 60, PromptEvalDuration: 1e8, EvalDuration: 1e9}},
+		{Model: "qwen3", Stats: &MessageStats{PromptTokens: 5, CompletionTokens: 10, PromptEvalDuration: 5e7, EvalDuration: 2e9}},
+		// Cancelled before Ollama reported anything.
+		{Model: "qwen3"},
+	}
+	for _, reply := range replies {
+		reply.ConversationID = convoID
+		reply.ParentID = &prompt.ID
+		reply.Role = RoleAssistant
+		if err := AddMessageWithThinking(&reply); err != nil {
+			t.Fatalf("failed to add reply: %v", err)
+		}
+	}
+
+	stats, err := GetUsageStats(time.Now().AddDate(0, 0, -1), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(stats.Models) != 2 {
+		t.Fatalf("expected 2 models, got %+v", stats.Models)
+	}
+	llama := stats.Models[0]
+	if llama.Model != "llama3" || llama.Messages != 2 || llama.CompletionTokens != 80 || llama.PromptTokens != 20 {
+		t.Errorf("unexpected llama3 totals: %+v", llama)
+	}
+	if llama.TokensPerSecond != 40 || llama.PromptTokensPerSecond != 100 {
+		t.Errorf("expected 40 tokens/s and 100 prompt tokens/s, got %v and %v", llama.TokensPerSecond, llama.PromptTokensPerSecond)
+	}
+	if qwen := stats.Models[1]; qwen.Model != "qwen3" || qwen.Messages != 1 || qwen.TokensPerSecond != 5 {
+		t.Errorf("unexpected qwen3 totals: %+v", qwen)
+	}
+
+	if len(stats.Daily) != 2 {
+		t.Fatalf("expected 2 daily rows, got %+v", stats.Daily)
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	for _, day := range stats.Daily {
+		if day.Day != today {
+			t.Errorf("expected day %s, got %s", today, day.Day)
+		}
+	}
+
+	if len(stats.Users) != 1 || stats.Users[0].UserID != "" || stats.Users[0].CompletionTokens != 90 {
+		t.Errorf("unexpected user totals: %+v", stats.Users)
+	}
+
+	other := "someone-else"
+	stats, err = GetUsageStats(time.Now().AddDate(0, 0, -1), &other)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(stats.Models) != 0 || stats.Users != nil {
+		t.Errorf("expected no stats for another user, got %+v", stats)
+	}
+}
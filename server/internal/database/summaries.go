@@ -0,0 +1,54 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// Summary caches a model-written summary of a conversation from its first
+// message up to and including MessageID. Messages never change once stored,
+// so the summary stays valid for every branch passing through MessageID.
+type Summary struct {
+	ID             string    `gorm:"primaryKey"`
+	ConversationID string    `gorm:"not null;index"`
+	MessageID      string    `gorm:"not null;uniqueIndex"` // last message the summary covers
+	Content        string    `gorm:"not null"`
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// GetSummaries returns the cached summaries of a conversation keyed by the
+// last message they cover.
+func GetSummaries(convoID string) (map[string]Summary, error) {
+	var summaries []Summary
+	if err := db.Where("conversation_id = ?", convoID).Find(&summaries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get summaries: %w", err)
+	}
+
+	byMessage := make(map[string]Summary, len(summaries))
+	for _, summary := range summaries {
+		byMessage[summary.MessageID] = summary
+	}
+	return byMessage, nil
+}
+
+// SaveSummary stores the summary of a conversation up to messageID,
+// replacing an earlier one for the same message.
+func SaveSummary(convoID, messageID, content string) error {
+	summary := Summary{
+		ID:             uuid.New().String(),
+		ConversationID: convoID,
+		MessageID:      messageID,
+		Content:        content,
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content"}),
+	}).Create(&summary).Error
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+	return nil
+}
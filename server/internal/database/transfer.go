@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrConversationExists is returned when importing a conversation the user
+// already has.
+var ErrConversationExists = errors.New("conversation already exists")
+
+// GetAllMessages returns every message of a conversation, all branches
+// included, oldest first.
+func GetAllMessages(convoID string) ([]Message, error) {
+	var messages []Message
+	if err := db.Where("conversation_id = ?", convoID).Order("created_at asc").Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ListConversationIDs returns the IDs of every conversation of the user,
+// archived ones included, most recently updated first.
+func ListConversationIDs(userID string) ([]string, error) {
+	var ids []string
+	if err := db.Model(&Conversation{}).Where("user_id = ?", userID).Order("updated_at desc").Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	return ids, nil
+}
+
+// GetConversationAttachments returns the attachments of a conversation with
+// their data.
+func GetConversationAttachments(convoID string) ([]Attachment, error) {
+	var attachments []Attachment
+	if err := db.Where("conversation_id = ?", convoID).Order("created_at asc").Find(&attachments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// ImportConversation stores a conversation with every message in
+// convo.Messages and the given attachments, keeping their IDs and
+// timestamps. The attachments go to the conversation's owner, and messages
+// may only show images among them. An ActiveLeafID that doesn't name one of
+// the messages is replaced by the newest message.
+func ImportConversation(convo *Conversation, attachments []Attachment) error {
+	messages := convo.Messages
+	if convo.ID == "" {
+		return errors.New("failed to import conversation: missing id")
+	}
+
+	byID := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		if msg.ID == "" {
+			return errors.New("failed to import conversation: message without id")
+		}
+		byID[msg.ID] = true
+	}
+	imported := make(map[string]bool, len(attachments))
+	for _, attachment := range attachments {
+		imported[attachment.ID] = true
+	}
+	for _, msg := range messages {
+		if msg.ParentID != nil && !byID[*msg.ParentID] {
+			return fmt.Errorf("failed to import conversation: message %s has unknown parent %s", msg.ID, *msg.ParentID)
+		}
+		for _, image := range msg.Images {
+			if !imported[image] {
+				return fmt.Errorf("failed to import conversation: message %s has unknown image %s", msg.ID, image)
+			}
+		}
+	}
+
+	if convo.ActiveLeafID == nil || !byID[*convo.ActiveLeafID] {
+		convo.ActiveLeafID = nil
+		var latest time.Time
+		for i := range messages {
+			if convo.ActiveLeafID == nil || !messages[i].CreatedAt.Before(latest) {
+				convo.ActiveLeafID = &messages[i].ID
+				latest = messages[i].CreatedAt
+			}
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing []Conversation
+		if err := tx.Select("user_id").Where("id = ?", convo.ID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if len(existing) > 0 && existing[0].UserID == convo.UserID {
+			return ErrConversationExists
+		}
+		if len(existing) > 0 {
+			return errors.New("its id is used by another user's conversation")
+		}
+
+		if err := tx.Omit("Messages").Create(convo).Error; err != nil {
+			return err
+		}
+		if err := indexTitle(tx, convo.ID, convo.Title); err != nil {
+			return err
+		}
+
+		for i := range messages {
+			messages[i].ConversationID = convo.ID
+			if err := tx.Create(&messages[i]).Error; err != nil {
+				return err
+			}
+			if err := indexMessage(tx, &messages[i]); err != nil {
+				return err
+			}
+		}
+
+		for i := range attachments {
+			attachments[i].ConversationID = &convo.ID
+			attachments[i].UserID = convo.UserID
+			attachments[i].Size = len(attachments[i].Data)
+			if err := tx.Create(&attachments[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrConversationExists) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import conversation: %w", err)
+	}
+	return nil
+}
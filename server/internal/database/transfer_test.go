@@ -0,0 +1,114 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+)
+
+func TestImportConversation(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	root := "m1"
+	importConvo := func() *Conversation {
+		return &Conversation{
+			ID:        "c1",
+			Title:     "Imported chat",
+			Model:     "llama3",
+			CreatedAt: created,
+			UpdatedAt: created,
+			// A leaf that doesn't exist falls back to the newest message.
+			ActiveLeafID: strPtr("missing"),
+			Messages: []Message{
+				{ID: "m1", Role: RoleUser, Content: "hello", CreatedAt: created},
+				{ID: "m2", ParentID: &root, Role: RoleAssistant, Content: "first answer", CreatedAt: created.Add(time.Second)},
+				{ID: "m3", ParentID: &root, Role: RoleAssistant, Content: "second answer", CreatedAt: created.Add(2 * time.Second)},
+			},
+		}
+	}
+
+	if err := ImportConversation(importConvo(), nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	convo, err := GetConversationByID("c1")
+	if err != nil || convo == nil {
+		t.Fatalf("expected imported conversation, got %v, %v", convo, err)
+	}
+	if !convo.CreatedAt.Equal(created) {
+		t.Errorf("expected created at %v, got %v", created, convo.CreatedAt)
+	}
+	if len(convo.Messages) != 2 || convo.Messages[1].ID != "m3" {
+		t.Errorf("expected active branch m1, m3, got %+v", convo.Messages)
+	}
+
+	all, err := GetAllMessages("c1")
+	if err != nil || len(all) != 3 {
+		t.Errorf("expected 3 messages, got %d, %v", len(all), err)
+	}
+
+	results, err := Search("", "second", 10)
+	if err != nil || len(results) != 1 {
+		t.Errorf("expected imported messages to be searchable, got %+v, %v", results, err)
+	}
+
+	if err := ImportConversation(importConvo(), nil); !errors.Is(err, ErrConversationExists) {
+		t.Errorf("expected ErrConversationExists on re-import, got %v", err)
+	}
+}
+
+func TestImportAttachments(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	// Another user's upload, which an import must not be able to show.
+	other, err := CreateAttachment("other", "secret.png", "image/png", []byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to create attachment: %v", err)
+	}
+	otherConvo, err := CreateConversation("other", "Private", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	if _, err := AddMessage(otherConvo, RoleUser, "look", []string{other.ID}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+
+	withImages := func(id string, images ...string) *Conversation {
+		return &Conversation{
+			ID:       id,
+			UserID:   "importer",
+			Title:    "Imported chat",
+			Model:    "llava",
+			Messages: []Message{{ID: id + "-m1", Role: RoleUser, Content: "what is this?", Images: images}},
+		}
+	}
+	if err := ImportConversation(withImages("c1", other.ID), nil); err == nil {
+		t.Errorf("expected an image outside the import to be refused")
+	}
+
+	bundled := []Attachment{{ID: "a1", Filename: "cat.png", MimeType: "image/png", Data: []byte("cat")}}
+	if err := ImportConversation(withImages("c2", "a1"), bundled); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	data, err := GetAttachmentData("c2", []string{"a1", other.ID})
+	if err != nil {
+		t.Fatalf("failed to get attachment data: %v", err)
+	}
+	if len(data) != 1 || string(data["a1"]) != "cat" {
+		t.Errorf("expected only the conversation's own image, got %d", len(data))
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,272 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Messages form a tree through ParentID. Editing a prompt or regenerating a
+// reply adds a sibling instead of overwriting, and Conversation.ActiveLeafID
+// selects which root-to-leaf path is shown and sent to the model.
+
+// ErrNotUserMessage is returned when editing a message the user didn't write.
+var ErrNotUserMessage = errors.New("only user messages can be edited")
+
+// insertMessage creates the message under its ParentID and makes it the
+// active leaf of its conversation. Callers run it inside a transaction.
+func insertMessage(tx *gorm.DB, message *Message) error {
+	if message.ID == "" {
+		message.ID = uuid.New().String()
+	}
+
+	if err := tx.Create(message).Error; err != nil {
+		return err
+	}
+
+	if err := indexMessage(tx, message); err != nil {
+		return err
+	}
+
+	return setActiveLeaf(tx, message.ConversationID, &message.ID)
+}
+
+func setActiveLeaf(tx *gorm.DB, convoID string, leafID *string) error {
+	return tx.Model(&Conversation{}).Where("id = ?", convoID).Updates(map[string]interface{}{
+		"active_leaf_id": leafID,
+		"updated_at":     gorm.Expr("CURRENT_TIMESTAMP"),
+	}).Error
+}
+
+// activePath walks from leafID up to the root and returns the messages in
+// conversation order.
+func activePath(tx *gorm.DB, convoID string, leafID *string) ([]Message, error) {
+	messages := []Message{}
+	if leafID == nil {
+		return messages, nil
+	}
+
+	var all []Message
+	if err := tx.Where("conversation_id = ?", convoID).Find(&all).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]Message, len(all))
+	for _, msg := range all {
+		byID[msg.ID] = msg
+	}
+
+	for id := leafID; id != nil; {
+		msg, ok := byID[*id]
+		if !ok || len(messages) > len(all) {
+			return nil, fmt.Errorf("broken message tree at %s", *id)
+		}
+		messages = append(messages, msg)
+		id = msg.ParentID
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// GetBranchMessages returns the path from the root down to leafID, which
+// need not be the active leaf.
+func GetBranchMessages(convoID string, leafID *string) ([]Message, error) {
+	messages, err := activePath(db, convoID, leafID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	return messages, nil
+}
+
+func GetMessageByID(messageID string) (*Message, error) {
+	var message Message
+	if err := db.First(&message, "id = ?", messageID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	return &message, nil
+}
+
+// GetActiveLeaf returns the last message on the active branch, or nil for a
+// conversation without messages.
+func GetActiveLeaf(convoID string) (*Message, error) {
+	var convo Conversation
+	if err := db.Select("active_leaf_id").First(&convo, "id = ?", convoID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get active leaf: %w", err)
+	}
+	if convo.ActiveLeafID == nil {
+		return nil, nil
+	}
+	return GetMessageByID(*convo.ActiveLeafID)
+}
+
+// SetActiveLeaf moves the end of the active branch to messageID, or to the
+// empty conversation when messageID is nil. The next message added is
+// attached below it.
+func SetActiveLeaf(convoID string, messageID *string) error {
+	if err := setActiveLeaf(db, convoID, messageID); err != nil {
+		return fmt.Errorf("failed to set active leaf: %w", err)
+	}
+	return nil
+}
+
+// EditMessage adds an edited copy of a user message as its sibling and makes
+// it the active leaf, leaving the original branch intact. Images attached to
+// the original are kept.
+func EditMessage(messageID, content string) (*Message, error) {
+	original, err := GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, ErrNotFound
+	}
+	if original.Role != RoleUser {
+		return nil, ErrNotUserMessage
+	}
+
+	edited := Message{
+		ConversationID: original.ConversationID,
+		ParentID:       original.ParentID,
+		Role:           RoleUser,
+		Content:        content,
+		RawContent:     content,
+		Images:         original.Images,
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return insertMessage(tx, &edited)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	return &edited, nil
+}
+
+// GetSiblings returns every alternative of a message, the message itself
+// included, oldest first.
+func GetSiblings(messageID string) ([]Message, error) {
+	message, err := GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrNotFound
+	}
+
+	query := db.Where("conversation_id = ?", message.ConversationID)
+	if message.ParentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *message.ParentID)
+	}
+
+	var siblings []Message
+	if err := query.Order("created_at asc").Find(&siblings).Error; err != nil {
+		return nil, fmt.Errorf("failed to get siblings: %w", err)
+	}
+	return siblings, nil
+}
+
+// SwitchBranch makes the branch through messageID active. The new leaf is
+// found by following the most recent child from messageID downwards, so
+// switching back to a branch returns to where it left off.
+func SwitchBranch(convoID, messageID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var message Message
+		if err := tx.First(&message, "id = ? AND conversation_id = ?", messageID, convoID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrNotFound
+			}
+			return fmt.Errorf("failed to switch branch: %w", err)
+		}
+
+		leafID := message.ID
+		for {
+			var child Message
+			err := tx.Where("parent_id = ?", leafID).Order("created_at desc").First(&child).Error
+			if err == gorm.ErrRecordNotFound {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to switch branch: %w", err)
+			}
+			leafID = child.ID
+		}
+
+		if err := setActiveLeaf(tx, convoID, &leafID); err != nil {
+			return fmt.Errorf("failed to switch branch: %w", err)
+		}
+		return nil
+	})
+}
+
+// backfillMessageTree links the flat message lists written before messages
+// had parents. Replies archived by regenerate become siblings of the reply
+// that replaced them.
+type legacyMessage struct {
+	ID       string
+	ParentID *string
+	Archived bool
+}
+
+func isLinked(messages []legacyMessage) bool {
+	for _, msg := range messages {
+		if msg.ParentID != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func backfillMessageTree() error {
+	var convoIDs []string
+	if err := db.Model(&Conversation{}).Where("active_leaf_id IS NULL").Pluck("id", &convoIDs).Error; err != nil {
+		return err
+	}
+
+	hasArchived := db.Migrator().HasColumn(&Message{}, "archived")
+
+	for _, convoID := range convoIDs {
+		query := db.Table("messages").Where("conversation_id = ?", convoID).Order("created_at asc")
+		if hasArchived {
+			query = query.Select("id", "parent_id", "archived")
+		} else {
+			query = query.Select("id", "parent_id")
+		}
+
+		var messages []legacyMessage
+		if err := query.Scan(&messages).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 || isLinked(messages) {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var leaf *string
+			for _, msg := range messages {
+				if err := tx.Table("messages").Where("id = ?", msg.ID).Update("parent_id", leaf).Error; err != nil {
+					return err
+				}
+				if !msg.Archived {
+					id := msg.ID
+					leaf = &id
+				}
+			}
+			return tx.Model(&Conversation{}).Where("id = ?", convoID).Update("active_leaf_id", leaf).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
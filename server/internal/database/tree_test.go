@@ -0,0 +1,253 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+)
+
+func initTreeDB(t *testing.T) string {
+	t.Helper()
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	convoID, err := CreateConversation("", "tree", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	return convoID
+}
+
+// insertAt adds a message below parentID written at the given second, so
+// that ordering by creation time doesn't depend on how fast the test runs.
+func insertAt(t *testing.T, convoID, id string, parentID *string, role string, second int) {
+	t.Helper()
+	message := Message{
+		ID:             id,
+		ConversationID: convoID,
+		ParentID:       parentID,
+		Role:           role,
+		Content:        id,
+		RawContent:     id,
+		CreatedAt:      time.Date(2024, 3, 1, 12, 0, second, 0, time.UTC),
+	}
+	if err := insertMessage(db, &message); err != nil {
+		t.Fatalf("failed to insert message %s: %v", id, err)
+	}
+}
+
+// ids joins the IDs of messages, for comparing paths.
+func ids(messages []Message) string {
+	list := make([]string, len(messages))
+	for i, msg := range messages {
+		list[i] = msg.ID
+	}
+	return strings.Join(list, " ")
+}
+
+func activeIDs(t *testing.T, convoID string) string {
+	t.Helper()
+	messages, err := GetMessagesByConversationID(convoID)
+	if err != nil {
+		t.Fatalf("failed to get active branch: %v", err)
+	}
+	return ids(messages)
+}
+
+func TestEditMessage(t *testing.T) {
+	convoID := initTreeDB(t)
+
+	prompt, err := AddMessage(convoID, RoleUser, "hi", nil)
+	if err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	reply, err := AddMessage(convoID, RoleAssistant, "hello", nil)
+	if err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	if reply.ParentID == nil || *reply.ParentID != prompt.ID {
+		t.Fatalf("expected the reply below the prompt, got parent %v", reply.ParentID)
+	}
+
+	edited, err := EditMessage(prompt.ID, "hey")
+	if err != nil {
+		t.Fatalf("failed to edit message: %v", err)
+	}
+	if edited.ParentID != nil || edited.Content != "hey" {
+		t.Errorf("expected an edited root message, got %+v", edited)
+	}
+	if got := activeIDs(t, convoID); got != edited.ID {
+		t.Errorf("expected the edit to be the active branch, got %q", got)
+	}
+
+	siblings, err := GetSiblings(prompt.ID)
+	if err != nil || len(siblings) != 2 {
+		t.Fatalf("expected the prompt and its edit as siblings, got %+v, %v", siblings, err)
+	}
+
+	// The original branch is left intact.
+	branch, err := GetBranchMessages(convoID, &reply.ID)
+	if err != nil || ids(branch) != prompt.ID+" "+reply.ID {
+		t.Errorf("expected the original branch kept, got %q, %v", ids(branch), err)
+	}
+
+	if _, err := EditMessage(reply.ID, "changed"); !errors.Is(err, ErrNotUserMessage) {
+		t.Errorf("expected ErrNotUserMessage editing a reply, got %v", err)
+	}
+	if _, err := EditMessage("missing", "changed"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound editing a missing message, got %v", err)
+	}
+}
+
+func TestActivePath(t *testing.T) {
+	convoID := initTreeDB(t)
+
+	if got := activeIDs(t, convoID); got != "" {
+		t.Errorf("expected an empty conversation to have no active branch, got %q", got)
+	}
+
+	// u1 ─┬─ a1 ── u2
+	//     └─ a2
+	insertAt(t, convoID, "u1", nil, RoleUser, 0)
+	insertAt(t, convoID, "a1", strPtr("u1"), RoleAssistant, 1)
+	insertAt(t, convoID, "u2", strPtr("a1"), RoleUser, 2)
+	insertAt(t, convoID, "a2", strPtr("u1"), RoleAssistant, 3)
+
+	if got := activeIDs(t, convoID); got != "u1 a2" {
+		t.Errorf("expected the last inserted message to be the active leaf, got %q", got)
+	}
+	if err := SetActiveLeaf(convoID, strPtr("u2")); err != nil {
+		t.Fatalf("failed to set active leaf: %v", err)
+	}
+	if got := activeIDs(t, convoID); got != "u1 a1 u2" {
+		t.Errorf("expected the path to follow the active leaf, got %q", got)
+	}
+	convo, err := GetConversationByID(convoID)
+	if err != nil || ids(convo.Messages) != "u1 a1 u2" {
+		t.Errorf("expected the conversation to hold the active branch, got %+v, %v", convo, err)
+	}
+}
+
+func TestSwitchBranch(t *testing.T) {
+	convoID := initTreeDB(t)
+
+	insertAt(t, convoID, "u1", nil, RoleUser, 0)
+	insertAt(t, convoID, "a1", strPtr("u1"), RoleAssistant, 1)
+	insertAt(t, convoID, "u2", strPtr("a1"), RoleUser, 2)
+	insertAt(t, convoID, "a2", strPtr("u1"), RoleAssistant, 3)
+
+	// Switching to a message continues down its most recent children.
+	if err := SwitchBranch(convoID, "a1"); err != nil {
+		t.Fatalf("failed to switch branch: %v", err)
+	}
+	if got := activeIDs(t, convoID); got != "u1 a1 u2" {
+		t.Errorf("expected to switch to the end of the a1 branch, got %q", got)
+	}
+	if err := SwitchBranch(convoID, "u1"); err != nil {
+		t.Fatalf("failed to switch branch: %v", err)
+	}
+	if got := activeIDs(t, convoID); got != "u1 a2" {
+		t.Errorf("expected to follow the newest reply, got %q", got)
+	}
+
+	otherID, err := CreateConversation("", "other", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	insertAt(t, otherID, "o1", nil, RoleUser, 4)
+	if err := SwitchBranch(convoID, "o1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound switching to another conversation's message, got %v", err)
+	}
+	if got := activeIDs(t, convoID); got != "u1 a2" {
+		t.Errorf("expected the active branch unchanged, got %q", got)
+	}
+}
+
+func TestGetSiblings(t *testing.T) {
+	convoID := initTreeDB(t)
+
+	// Inserted out of order, listed oldest first.
+	insertAt(t, convoID, "u1", nil, RoleUser, 0)
+	insertAt(t, convoID, "b", strPtr("u1"), RoleAssistant, 2)
+	insertAt(t, convoID, "a", strPtr("u1"), RoleAssistant, 1)
+	insertAt(t, convoID, "c", strPtr("u1"), RoleAssistant, 3)
+	insertAt(t, convoID, "u2", strPtr("b"), RoleUser, 4)
+
+	siblings, err := GetSiblings("b")
+	if err != nil || ids(siblings) != "a b c" {
+		t.Errorf("expected siblings a b c, got %q, %v", ids(siblings), err)
+	}
+	siblings, err = GetSiblings("u1")
+	if err != nil || ids(siblings) != "u1" {
+		t.Errorf("expected a root message to be its only sibling, got %q, %v", ids(siblings), err)
+	}
+	if _, err := GetSiblings("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing message, got %v", err)
+	}
+}
+
+func TestBackfillMessageTree(t *testing.T) {
+	convoID := initTreeDB(t)
+
+	// A conversation from before messages had parents: a flat list where
+	// regenerate archived the reply it replaced.
+	if err := db.Exec("ALTER TABLE messages ADD COLUMN archived numeric NOT NULL DEFAULT false").Error; err != nil {
+		t.Fatalf("failed to add archived column: %v", err)
+	}
+	legacy := []struct {
+		id       string
+		role     string
+		archived bool
+	}{
+		{"u1", RoleUser, false},
+		{"a1", RoleAssistant, true},
+		{"a2", RoleAssistant, false},
+		{"u2", RoleUser, false},
+		{"a3", RoleAssistant, false},
+	}
+	for i, msg := range legacy {
+		message := Message{
+			ID:             msg.id,
+			ConversationID: convoID,
+			Role:           msg.role,
+			Content:        msg.id,
+			RawContent:     msg.id,
+			CreatedAt:      time.Date(2024, 3, 1, 12, 0, i, 0, time.UTC),
+		}
+		if err := db.Create(&message).Error; err != nil {
+			t.Fatalf("failed to insert message %s: %v", msg.id, err)
+		}
+		if err := db.Exec("UPDATE messages SET archived = ? WHERE id = ?", msg.archived, msg.id).Error; err != nil {
+			t.Fatalf("failed to archive message %s: %v", msg.id, err)
+		}
+	}
+
+	if err := backfillMessageTree(); err != nil {
+		t.Fatalf("failed to backfill message tree: %v", err)
+	}
+
+	if got := activeIDs(t, convoID); got != "u1 a2 u2 a3" {
+		t.Errorf("expected the unarchived messages on the active branch, got %q", got)
+	}
+	siblings, err := GetSiblings("a2")
+	if err != nil || ids(siblings) != "a1 a2" {
+		t.Errorf("expected the archived reply as a sibling of its replacement, got %q, %v", ids(siblings), err)
+	}
+
+	// Messages that already have parents are left alone, even without an
+	// active leaf.
+	if err := SetActiveLeaf(convoID, nil); err != nil {
+		t.Fatalf("failed to clear active leaf: %v", err)
+	}
+	if err := backfillMessageTree(); err != nil {
+		t.Fatalf("failed to backfill message tree: %v", err)
+	}
+	branch, err := GetBranchMessages(convoID, strPtr("a3"))
+	if err != nil || ids(branch) != "u1 a2 u2 a3" {
+		t.Errorf("expected a linked conversation to be left alone, got %q, %v", ids(branch), err)
+	}
+}
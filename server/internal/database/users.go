@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Account roles. Admins manage users and see usage across all of them.
+const (
+	UserRoleAdmin  = "admin"
+	UserRoleMember = "member"
+)
+
+// ErrUsernameTaken is returned when creating a user whose name is in use.
+var ErrUsernameTaken = errors.New("username already taken")
+
+type User struct {
+	ID           string    `gorm:"primaryKey"`
+	Username     string    `gorm:"not null;uniqueIndex"`
+	PasswordHash string    `gorm:"not null" json:"-"`
+	Role         string    `gorm:"not null;default:'member'"`
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+// Session is a signed-in browser. Only a hash of the cookie's token is
+// stored, so the table can't be used to sign in.
+type Session struct {
+	TokenHash string    `gorm:"primaryKey"`
+	UserID    string    `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+// CountUsers reports how many accounts exist; none means the server still
+// needs its first admin.
+func CountUsers() (int64, error) {
+	var count int64
+	if err := db.Model(&User{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// CreateUser adds an account. The first account becomes an admin whatever
+// role is asked for, and takes over the conversations, attachments and API
+// keys created before accounts were enabled.
+func CreateUser(username, passwordHash, role string) (*User, error) {
+	user := User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: passwordHash,
+		Role:         role,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&User{}).Where("username = ?", username).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrUsernameTaken
+		}
+
+		var count int64
+		if err := tx.Model(&User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		first := count == 0
+		if first {
+			user.Role = UserRoleAdmin
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if !first {
+			return nil
+		}
+		if err := tx.Model(&Conversation{}).Where("user_id = ''").Update("user_id", user.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Attachment{}).Where("user_id = ''").Update("user_id", user.ID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&APIKey{}).Where("user_id = ''").Update("user_id", user.ID).Error
+	})
+	if err == ErrUsernameTaken {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return &user, nil
+}
+
+func GetUserByUsername(username string) (*User, error) {
+	var user User
+	if err := db.First(&user, "username = ?", username).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+func GetUserByID(userID string) (*User, error) {
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+func ListUsers() ([]User, error) {
+	var users []User
+	if err := db.Order("username").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+// SetPassword replaces a user's password and signs out their sessions.
+func SetPassword(userID, passwordHash string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userID).Update("password_hash", passwordHash)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Where("user_id = ?", userID).Delete(&Session{}).Error
+	})
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser removes an account together with its sessions, API keys and
+// conversations.
+func DeleteUser(userID string) error {
+	var convoIDs []string
+	if err := db.Model(&Conversation{}).Where("user_id = ?", userID).Pluck("id", &convoIDs).Error; err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	for _, convoID := range convoIDs {
+		if err := DeleteConversation(convoID); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&APIKey{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&User{}, "id = ?", userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// CreateSession stores a new session, clearing out expired ones on the way.
+func CreateSession(userID, tokenHash string, expiresAt time.Time) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&Session{TokenHash: tokenHash, UserID: userID, ExpiresAt: expiresAt}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetSessionUser returns the user signed in with the session, or nil if the
+// session doesn't exist or has expired.
+func GetSessionUser(tokenHash string) (*User, error) {
+	var user User
+	err := db.Joins("JOIN sessions ON sessions.user_id = users.id").
+		Where("sessions.token_hash = ? AND sessions.expires_at > ?", tokenHash, time.Now()).
+		First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return &user, nil
+}
+
+func DeleteSession(tokenHash string) error {
+	if err := db.Delete(&Session{}, "token_hash = ?", tokenHash).Error; err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+)
+
+func TestUsers(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	// Made before accounts were enabled.
+	before, err := CreateConversation("", "old chat", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+
+	admin, err := CreateUser("alice", "hash", UserRoleMember)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if admin.Role != UserRoleAdmin {
+		t.Errorf("expected the first user to be an admin, got %s", admin.Role)
+	}
+	if convos, _ := ListConversations(admin.ID, false); len(convos) != 1 || convos[0].ID != before {
+		t.Errorf("expected the admin to take over existing conversations, got %+v", convos)
+	}
+
+	member, err := CreateUser("bob", "hash", UserRoleMember)
+	if err != nil || member.Role != UserRoleMember {
+		t.Fatalf("expected a member, got %+v, %v", member, err)
+	}
+	if _, err := CreateUser("bob", "hash", UserRoleMember); err != ErrUsernameTaken {
+		t.Errorf("expected ErrUsernameTaken, got %v", err)
+	}
+	if convos, _ := ListConversations(member.ID, false); len(convos) != 0 {
+		t.Errorf("expected no conversations for a new user, got %+v", convos)
+	}
+
+	if err := CreateSession(member.ID, "token-hash", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if err := CreateSession(member.ID, "expired", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if user, err := GetSessionUser("token-hash"); err != nil || user == nil || user.ID != member.ID {
+		t.Errorf("expected the session to belong to bob, got %+v, %v", user, err)
+	}
+	if user, _ := GetSessionUser("expired"); user != nil {
+		t.Errorf("expected an expired session to be rejected")
+	}
+
+	convoID, err := CreateConversation(member.ID, "bob's chat", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	if err := DeleteUser(member.ID); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if convo, _ := GetConversationMetadata(convoID); convo != nil {
+		t.Errorf("expected the user's conversations to be deleted")
+	}
+	if user, _ := GetSessionUser("token-hash"); user != nil {
+		t.Errorf("expected the user's sessions to be deleted")
+	}
+}
@@ -0,0 +1,141 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+func testConversation() (*database.Conversation, []database.Attachment) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	root := "m1"
+	call := "m2"
+	thinking := "The user greets me."
+	thinkingTime := 1.5
+
+	convo := &database.Conversation{
+		ID:           "c1",
+		Title:        "Greetings",
+		Model:        "qwen3",
+		SystemPrompt: "Be brief.",
+		ActiveLeafID: strPtr("m4"),
+		CreatedAt:    created,
+		UpdatedAt:    created,
+		Messages: []database.Message{
+			{ID: "m1", ConversationID: "c1", Role: database.RoleUser, Content: "hi", RawContent: "hi", Images: []string{"a1"}, CreatedAt: created},
+			{ID: "m2", ConversationID: "c1", ParentID: &root, Role: database.RoleAssistant, Model: "qwen3",
+				ToolCalls: []ollama.ToolCall{{Function: ollama.ToolCallFunction{Name: "current_time", Arguments: json.RawMessage(`{}`)}}},
+				CreatedAt: created},
+			{ID: "m3", ConversationID: "c1", ParentID: &call, Role: database.RoleTool, ToolName: "current_time", Content: "12:00", RawContent: "12:00", CreatedAt: created},
+			{ID: "m4", ConversationID: "c1", ParentID: strPtr("m3"), Role: database.RoleAssistant, Model: "qwen3",
+				Content: "Hello!", RawContent: "<think>The user greets me.</think>Hello!", Thinking: &thinking, ThinkingTime: &thinkingTime,
+				Stats: &database.MessageStats{PromptTokens: 3, CompletionTokens: 4}, CreatedAt: created},
+		},
+	}
+	attachments := []database.Attachment{
+		{ID: "a1", Filename: "cat.png", MimeType: "image/png", Size: 3, Data: []byte{1, 2, 3}, CreatedAt: created},
+	}
+	return convo, attachments
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	convo, attachments := testConversation()
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(NewDocument(FromDatabase(convo, convo.Messages, attachments))); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	doc, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(doc.Conversations) != 1 {
+		t.Fatalf("expected 1 conversation, got %d", len(doc.Conversations))
+	}
+
+	gotConvo, gotAttachments := doc.Conversations[0].ToDatabase()
+	if !reflect.DeepEqual(gotConvo, convo) {
+		t.Errorf("conversation changed in round trip:\n got %+v\nwant %+v", gotConvo, convo)
+	}
+	if !reflect.DeepEqual(gotAttachments, attachments) {
+		t.Errorf("attachments changed in round trip:\n got %+v\nwant %+v", gotAttachments, attachments)
+	}
+}
+
+func TestDecodeRejectsOtherDocuments(t *testing.T) {
+	for _, input := range []string{
+		`[{"title": "ChatGPT export", "mapping": {}}]`,
+		`{"format": "something-else", "version": 1}`,
+		`{"format": "tiny-ollama-chat", "version": 99}`,
+	} {
+		if _, err := Decode(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for %s", input)
+		} else if strings.HasPrefix(input, "{") && !errors.Is(err, ErrUnsupportedDocument) {
+			t.Errorf("expected ErrUnsupportedDocument for %s, got %v", input, err)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	convo, attachments := testConversation()
+
+	plain := string(Markdown(convo, Options{Attachments: attachments}))
+	for _, want := range []string{
+		"# Greetings",
+		"> Be brief.",
+		"## User\n\n*Image: cat.png*\n\nhi",
+		"**Tool call:** `current_time`",
+		"## Tool · current_time\n\n```\n12:00\n```",
+		"## Assistant · qwen3\n\nHello!",
+	} {
+		if !strings.Contains(plain, want) {
+			t.Errorf("expected markdown to contain %q, got:\n%s", want, plain)
+		}
+	}
+	if strings.Contains(plain, "greets") {
+		t.Errorf("expected thinking to be left out, got:\n%s", plain)
+	}
+
+	withThinking := string(Markdown(convo, Options{Thinking: true}))
+	if !strings.Contains(withThinking, "<summary>Thinking (1.5s)</summary>\n\nThe user greets me.") {
+		t.Errorf("expected thinking block, got:\n%s", withThinking)
+	}
+}
+
+func TestHTMLEscapesContent(t *testing.T) {
+	convo, attachments := testConversation()
+	convo.Messages[0].Content = "<script>alert(1)</script>"
+
+	page, err := HTML(convo, Options{Attachments: attachments})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bytes.Contains(page, []byte("<script>")) {
+		t.Errorf("expected content to be escaped")
+	}
+	if !bytes.Contains(page, []byte(`src="data:image/png;base64,AQID"`)) {
+		t.Errorf("expected embedded image, got:\n%s", page)
+	}
+}
+
+func TestFilename(t *testing.T) {
+	convo := &database.Conversation{ID: "0123456789abcdef", Title: "What's up? / Ça va"}
+	if got := Filename(convo, "md"); got != "what-s-up-ça-va-01234567.md" {
+		t.Errorf("unexpected filename %q", got)
+	}
+	convo.Title = "???"
+	if got := Filename(convo, "json"); got != "01234567.json" {
+		t.Errorf("unexpected filename %q", got)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
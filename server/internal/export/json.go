@@ -0,0 +1,203 @@
+// Package export converts conversations to files people can keep or move to
+// another server: Markdown and HTML for reading, JSON for importing again.
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// The JSON export identifies itself so imports can tell it from other
+// formats and from future revisions.
+const (
+	FormatName    = "tiny-ollama-chat"
+	FormatVersion = 1
+)
+
+// ErrUnsupportedDocument is returned by Decode for JSON that isn't an export
+// of a version this server understands.
+var ErrUnsupportedDocument = errors.New("not a tiny-ollama-chat export")
+
+type Document struct {
+	Format        string         `json:"format"`
+	Version       int            `json:"version"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	Conversations []Conversation `json:"conversations"`
+}
+
+// Conversation holds every message of every branch, so an import restores
+// the conversation exactly, alternatives included.
+type Conversation struct {
+	ID                 string          `json:"id"`
+	Title              string          `json:"title"`
+	Model              string          `json:"model"`
+	SystemPrompt       string          `json:"system_prompt,omitempty"`
+	Options            *ollama.Options `json:"options,omitempty"`
+	Pinned             bool            `json:"pinned,omitempty"`
+	Archived           bool            `json:"archived,omitempty"`
+	DisabledMCPServers []string        `json:"disabled_mcp_servers,omitempty"`
+	ActiveLeafID       *string         `json:"active_leaf_id,omitempty"`
+	CreatedAt          time.Time       `json:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at"`
+	Messages           []Message       `json:"messages"`
+	Attachments        []Attachment    `json:"attachments,omitempty"`
+}
+
+type Message struct {
+	ID           string                 `json:"id"`
+	ParentID     *string                `json:"parent_id,omitempty"`
+	Role         string                 `json:"role"`
+	Content      string                 `json:"content"`
+	RawContent   string                 `json:"raw_content,omitempty"`
+	Images       []string               `json:"images,omitempty"`
+	ToolCalls    []ollama.ToolCall      `json:"tool_calls,omitempty"`
+	ToolName     string                 `json:"tool_name,omitempty"`
+	Thinking     *string                `json:"thinking,omitempty"`
+	ThinkingTime *float64               `json:"thinking_time,omitempty"`
+	Interrupted  bool                   `json:"interrupted,omitempty"`
+	Model        string                 `json:"model,omitempty"`
+	Stats        *database.MessageStats `json:"stats,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+type Attachment struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	MimeType  string    `json:"mime_type"`
+	Data      []byte    `json:"data"` // base64 in JSON
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewDocument(conversations ...Conversation) *Document {
+	if conversations == nil {
+		conversations = []Conversation{}
+	}
+	return &Document{
+		Format:        FormatName,
+		Version:       FormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		Conversations: conversations,
+	}
+}
+
+// Decode reads a JSON export, refusing other documents.
+func Decode(r io.Reader) (*Document, error) {
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode export: %w", err)
+	}
+	if doc.Format != FormatName || doc.Version < 1 || doc.Version > FormatVersion {
+		return nil, ErrUnsupportedDocument
+	}
+	return &doc, nil
+}
+
+// FromDatabase converts a conversation, all its messages and its attachments.
+func FromDatabase(convo *database.Conversation, messages []database.Message, attachments []database.Attachment) Conversation {
+	exported := Conversation{
+		ID:                 convo.ID,
+		Title:              convo.Title,
+		Model:              convo.Model,
+		SystemPrompt:       convo.SystemPrompt,
+		Options:            convo.Options,
+		Pinned:             convo.Pinned,
+		Archived:           convo.Archived,
+		DisabledMCPServers: convo.DisabledMCPServers,
+		ActiveLeafID:       convo.ActiveLeafID,
+		CreatedAt:          convo.CreatedAt,
+		UpdatedAt:          convo.UpdatedAt,
+		Messages:           make([]Message, len(messages)),
+	}
+
+	for i, msg := range messages {
+		exported.Messages[i] = Message{
+			ID:           msg.ID,
+			ParentID:     msg.ParentID,
+			Role:         msg.Role,
+			Content:      msg.Content,
+			RawContent:   msg.RawContent,
+			Images:       msg.Images,
+			ToolCalls:    msg.ToolCalls,
+			ToolName:     msg.ToolName,
+			Thinking:     msg.Thinking,
+			ThinkingTime: msg.ThinkingTime,
+			Interrupted:  msg.Interrupted,
+			Model:        msg.Model,
+			Stats:        msg.Stats,
+			CreatedAt:    msg.CreatedAt,
+		}
+	}
+
+	for _, attachment := range attachments {
+		exported.Attachments = append(exported.Attachments, Attachment{
+			ID:        attachment.ID,
+			Filename:  attachment.Filename,
+			MimeType:  attachment.MimeType,
+			Data:      attachment.Data,
+			CreatedAt: attachment.CreatedAt,
+		})
+	}
+	return exported
+}
+
+// ToDatabase is the inverse of FromDatabase, with Messages holding every
+// message of the conversation.
+func (c Conversation) ToDatabase() (*database.Conversation, []database.Attachment) {
+	convo := &database.Conversation{
+		ID:                 c.ID,
+		Title:              c.Title,
+		Model:              c.Model,
+		SystemPrompt:       c.SystemPrompt,
+		Options:            c.Options,
+		Pinned:             c.Pinned,
+		Archived:           c.Archived,
+		DisabledMCPServers: c.DisabledMCPServers,
+		ActiveLeafID:       c.ActiveLeafID,
+		CreatedAt:          c.CreatedAt,
+		UpdatedAt:          c.UpdatedAt,
+		Messages:           make([]database.Message, len(c.Messages)),
+	}
+
+	for i, msg := range c.Messages {
+		rawContent := msg.RawContent
+		if rawContent == "" {
+			rawContent = msg.Content
+		}
+		convo.Messages[i] = database.Message{
+			ID:             msg.ID,
+			ConversationID: c.ID,
+			ParentID:       msg.ParentID,
+			Role:           msg.Role,
+			Content:        msg.Content,
+			RawContent:     rawContent,
+			Images:         msg.Images,
+			ToolCalls:      msg.ToolCalls,
+			ToolName:       msg.ToolName,
+			Thinking:       msg.Thinking,
+			ThinkingTime:   msg.ThinkingTime,
+			Interrupted:    msg.Interrupted,
+			Model:          msg.Model,
+			Stats:          msg.Stats,
+			CreatedAt:      msg.CreatedAt,
+		}
+	}
+
+	attachments := make([]database.Attachment, len(c.Attachments))
+	for i, attachment := range c.Attachments {
+		attachments[i] = database.Attachment{
+			ID:        attachment.ID,
+			Filename:  attachment.Filename,
+			MimeType:  attachment.MimeType,
+			Size:      len(attachment.Data),
+			Data:      attachment.Data,
+			CreatedAt: attachment.CreatedAt,
+		}
+	}
+	return convo, attachments
+}
@@ -0,0 +1,243 @@
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/database"
+)
+
+// Options control the Markdown and HTML renderings, which show the active
+// branch of a conversation.
+type Options struct {
+	Thinking bool // include the model's reasoning
+
+	// Attachments are embedded as images in HTML; Markdown names them.
+	Attachments []database.Attachment
+}
+
+// entry is a message prepared for rendering.
+type entry struct {
+	Role         string
+	Heading      string
+	Content      string
+	Thinking     string
+	ThinkingTime float64
+	Images       []image
+	ToolCalls    []toolCall
+	Interrupted  bool
+}
+
+type image struct {
+	Filename string
+	DataURL  template.URL
+}
+
+type toolCall struct {
+	Name      string
+	Arguments string
+}
+
+func entries(convo *database.Conversation, opts Options) []entry {
+	attachments := make(map[string]database.Attachment, len(opts.Attachments))
+	for _, attachment := range opts.Attachments {
+		attachments[attachment.ID] = attachment
+	}
+
+	list := make([]entry, 0, len(convo.Messages))
+	for _, msg := range convo.Messages {
+		e := entry{
+			Role:        msg.Role,
+			Content:     msg.Content,
+			Interrupted: msg.Interrupted,
+		}
+
+		switch msg.Role {
+		case database.RoleUser:
+			e.Heading = "User"
+		case database.RoleTool:
+			e.Heading = "Tool · " + msg.ToolName
+		default:
+			e.Heading = "Assistant"
+			if model := msg.Model; model != "" {
+				e.Heading += " · " + model
+			} else if convo.Model != "" {
+				e.Heading += " · " + convo.Model
+			}
+		}
+
+		if opts.Thinking && msg.Thinking != nil {
+			e.Thinking = strings.TrimSpace(*msg.Thinking)
+			if msg.ThinkingTime != nil {
+				e.ThinkingTime = *msg.ThinkingTime
+			}
+		}
+
+		for _, id := range msg.Images {
+			img := image{Filename: id}
+			if attachment, ok := attachments[id]; ok {
+				img.Filename = attachment.Filename
+				img.DataURL = template.URL("data:" + attachment.MimeType + ";base64," +
+					base64.StdEncoding.EncodeToString(attachment.Data))
+			}
+			e.Images = append(e.Images, img)
+		}
+
+		for _, call := range msg.ToolCalls {
+			arguments := string(call.Function.Arguments)
+			var indented bytes.Buffer
+			if json.Indent(&indented, call.Function.Arguments, "", "  ") == nil {
+				arguments = indented.String()
+			}
+			e.ToolCalls = append(e.ToolCalls, toolCall{Name: call.Function.Name, Arguments: arguments})
+		}
+
+		list = append(list, e)
+	}
+	return list
+}
+
+var backticks = regexp.MustCompile("`+")
+
+// fence returns a code fence longer than any run of backticks in text, so
+// the text can't close it early.
+func fence(text string) string {
+	longest := 2
+	for _, run := range backticks.FindAllString(text, -1) {
+		longest = max(longest, len(run))
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// Markdown renders the conversation's active branch as a Markdown document.
+func Markdown(convo *database.Conversation, opts Options) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", convo.Title)
+	fmt.Fprintf(&b, "*Model: %s · Created: %s*\n\n", convo.Model, formatTime(convo.CreatedAt))
+	if convo.SystemPrompt != "" {
+		b.WriteString("> **System prompt**\n>\n")
+		for _, line := range strings.Split(convo.SystemPrompt, "\n") {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+		b.WriteString("\n")
+	}
+
+	for _, e := range entries(convo, opts) {
+		fmt.Fprintf(&b, "---\n\n## %s\n\n", e.Heading)
+
+		if e.Thinking != "" {
+			b.WriteString("<details>\n")
+			if e.ThinkingTime > 0 {
+				fmt.Fprintf(&b, "<summary>Thinking (%.1fs)</summary>\n\n", e.ThinkingTime)
+			} else {
+				b.WriteString("<summary>Thinking</summary>\n\n")
+			}
+			fmt.Fprintf(&b, "%s\n\n</details>\n\n", e.Thinking)
+		}
+
+		for _, img := range e.Images {
+			fmt.Fprintf(&b, "*Image: %s*\n\n", img.Filename)
+		}
+
+		for _, call := range e.ToolCalls {
+			f := fence(call.Arguments)
+			fmt.Fprintf(&b, "**Tool call:** `%s`\n\n%sjson\n%s\n%s\n\n", call.Name, f, call.Arguments, f)
+		}
+
+		switch {
+		case e.Role == database.RoleTool:
+			f := fence(e.Content)
+			fmt.Fprintf(&b, "%s\n%s\n%s\n\n", f, e.Content, f)
+		case e.Content != "":
+			fmt.Fprintf(&b, "%s\n\n", e.Content)
+		}
+
+		if e.Interrupted {
+			b.WriteString("*(interrupted)*\n\n")
+		}
+	}
+
+	return []byte(b.String())
+}
+
+var htmlTemplate = template.Must(template.New("conversation").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #1f2937; line-height: 1.5; }
+.meta { color: #6b7280; }
+section { border-top: 1px solid #e5e7eb; padding: 1rem 0; }
+h2 { font-size: 1rem; margin: 0 0 .5rem; color: #374151; }
+.user h2 { color: #2563eb; }
+.content, pre { white-space: pre-wrap; word-wrap: break-word; }
+pre { background: #f3f4f6; padding: .75rem; border-radius: .375rem; overflow-x: auto; }
+details { color: #6b7280; margin-bottom: .5rem; }
+img { max-width: 100%; border-radius: .375rem; }
+.system { background: #f9fafb; border-left: 3px solid #d1d5db; padding: .5rem 1rem; }
+.interrupted { color: #b45309; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Model: {{.Model}} · Created: {{.Created}}</p>
+{{if .SystemPrompt}}<div class="system"><strong>System prompt</strong><div class="content">{{.SystemPrompt}}</div></div>
+{{end}}{{range .Entries}}<section class="{{.Role}}">
+<h2>{{.Heading}}</h2>
+{{if .Thinking}}<details><summary>Thinking{{if .ThinkingTime}} ({{printf "%.1f" .ThinkingTime}}s){{end}}</summary><div class="content">{{.Thinking}}</div></details>
+{{end}}{{range .Images}}{{if .DataURL}}<img src="{{.DataURL}}" alt="{{.Filename}}">{{else}}<p><em>Image: {{.Filename}}</em></p>{{end}}
+{{end}}{{range .ToolCalls}}<p>Tool call: <code>{{.Name}}</code></p><pre>{{.Arguments}}</pre>
+{{end}}{{if eq .Role "tool"}}<pre>{{.Content}}</pre>{{else if .Content}}<div class="content">{{.Content}}</div>{{end}}
+{{if .Interrupted}}<p class="interrupted">(interrupted)</p>{{end}}
+</section>
+{{end}}</body>
+</html>
+`))
+
+// HTML renders the conversation's active branch as a standalone web page.
+func HTML(convo *database.Conversation, opts Options) ([]byte, error) {
+	var b bytes.Buffer
+	err := htmlTemplate.Execute(&b, map[string]any{
+		"Title":        convo.Title,
+		"Model":        convo.Model,
+		"Created":      formatTime(convo.CreatedAt),
+		"SystemPrompt": convo.SystemPrompt,
+		"Entries":      entries(convo, opts),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render html: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+var unsafeFilename = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// Filename returns a file name for an exported conversation built from its
+// title, with a prefix of its ID to keep names in a bulk export unique.
+func Filename(convo *database.Conversation, extension string) string {
+	name := strings.Trim(unsafeFilename.ReplaceAllString(strings.ToLower(convo.Title), "-"), "-")
+	if runes := []rune(name); len(runes) > 60 {
+		name = strings.Trim(string(runes[:60]), "-")
+	}
+	id := convo.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	if name == "" {
+		return id + "." + extension
+	}
+	return name + "-" + id + "." + extension
+}
@@ -0,0 +1,197 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/database"
+)
+
+// chatGPTConversation is one entry of ChatGPT's conversations.json. Messages
+// form a tree in mapping, like ours: editing a prompt or regenerating a
+// reply adds a sibling.
+type chatGPTConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	UpdateTime     float64                `json:"update_time"`
+	CurrentNode    string                 `json:"current_node"`
+	DefaultModel   string                 `json:"default_model_slug"`
+	IsArchived     bool                   `json:"is_archived"`
+	Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID      string          `json:"id"`
+	Parent  string          `json:"parent"`
+	Message *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+		Thoughts    []struct {
+			Content string `json:"content"`
+		} `json:"thoughts"`
+	} `json:"content"`
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// chatGPTPart is a non-text part of a multimodal message.
+type chatGPTPart struct {
+	ContentType  string `json:"content_type"`
+	AssetPointer string `json:"asset_pointer"`
+}
+
+func parseChatGPT(data json.RawMessage, owner string, files *zipFiles) Parsed {
+	parsed := Parsed{Format: FormatChatGPT}
+
+	var source chatGPTConversation
+	if err := json.Unmarshal(data, &source); err != nil {
+		parsed.Conversation = &database.Conversation{}
+		parsed.Err = fmt.Errorf("failed to decode conversation: %w", err)
+		return parsed
+	}
+
+	sourceID := source.ConversationID
+	if sourceID == "" {
+		sourceID = source.ID
+	}
+	if sourceID == "" {
+		sourceID = fmt.Sprintf("%s@%f", source.Title, source.CreateTime)
+	}
+	convo := newConversation(stableID(owner, FormatChatGPT, sourceID), source.Title, source.DefaultModel,
+		unixTime(source.CreateTime), unixTime(source.UpdateTime))
+	convo.Archived = source.IsArchived
+	parsed.Conversation = convo
+
+	nodes := make([]node, 0, len(source.Mapping))
+	for id, sourceNode := range source.Mapping {
+		if sourceNode.ID == "" {
+			sourceNode.ID = id
+		}
+		n := node{id: sourceNode.ID, parent: sourceNode.Parent}
+		if msg := sourceNode.Message; msg != nil {
+			n.message, n.thinking = chatGPTMessageToDatabase(convo, msg, files, &parsed.Attachments)
+		}
+		nodes = append(nodes, n)
+	}
+	sortNodes(nodes)
+
+	buildTree(convo, nodes, source.CurrentNode)
+	if len(convo.Messages) == 0 {
+		parsed.Err = fmt.Errorf("conversation has no messages")
+	}
+	if convo.Model == "" {
+		for _, msg := range convo.Messages {
+			if msg.Model != "" {
+				convo.Model = msg.Model
+			}
+		}
+	}
+	return parsed
+}
+
+// chatGPTMessageToDatabase converts the messages people see in ChatGPT.
+// Tool traffic, hidden context and empty messages are dropped. Reasoning is
+// its own message in ChatGPT's tree and is returned as thinking for the reply
+// below it.
+func chatGPTMessageToDatabase(convo *database.Conversation, msg *chatGPTMessage, files *zipFiles, attachments *[]database.Attachment) (*database.Message, string) {
+	if msg.Metadata.Hidden {
+		return nil, ""
+	}
+
+	content := msg.Content
+	switch msg.Author.Role {
+	case "system":
+		if text := strings.TrimSpace(chatGPTText(content.Parts)); text != "" && convo.SystemPrompt == "" {
+			convo.SystemPrompt = text
+		}
+		return nil, ""
+	case database.RoleUser, database.RoleAssistant:
+	default:
+		return nil, ""
+	}
+	if msg.Recipient != "" && msg.Recipient != "all" {
+		return nil, "" // a call to one of ChatGPT's tools
+	}
+
+	created := unixTime(msg.CreateTime)
+	var text string
+	var images []string
+	switch content.ContentType {
+	case "text", "multimodal_text":
+		text = chatGPTText(content.Parts)
+		for _, part := range content.Parts {
+			var p chatGPTPart
+			if json.Unmarshal(part, &p) != nil || p.ContentType != "image_asset_pointer" {
+				continue
+			}
+			if image, ok := chatGPTImage(convo.ID, p.AssetPointer, files, created); ok {
+				*attachments = append(*attachments, image)
+				images = append(images, image.ID)
+			}
+		}
+	case "code":
+		text = "```\n" + content.Text + "\n```"
+	case "thoughts":
+		thoughts := make([]string, 0, len(content.Thoughts))
+		for _, thought := range content.Thoughts {
+			thoughts = append(thoughts, thought.Content)
+		}
+		return nil, strings.Join(thoughts, "\n\n")
+	default:
+		return nil, ""
+	}
+
+	if strings.TrimSpace(text) == "" && len(images) == 0 {
+		return nil, ""
+	}
+	message := &database.Message{
+		Role:       msg.Author.Role,
+		Content:    text,
+		RawContent: text,
+		Images:     images,
+		CreatedAt:  created,
+	}
+	if msg.Author.Role == database.RoleAssistant {
+		message.Model = msg.Metadata.ModelSlug
+	}
+	return message, ""
+}
+
+// chatGPTText joins the text parts of a message.
+func chatGPTText(parts []json.RawMessage) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		var text string
+		if json.Unmarshal(part, &text) == nil && text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// chatGPTImage loads an uploaded image from a data export, where the file
+// is named after the asset ID: "file-service://file-abc" is "file-abc-cat.png".
+func chatGPTImage(convoID, pointer string, files *zipFiles, created time.Time) (database.Attachment, bool) {
+	assetID := pointer[strings.LastIndex(pointer, "/")+1:]
+	name, data, ok := files.find(assetID)
+	if !ok {
+		return database.Attachment{}, false
+	}
+	return newImage(stableID(convoID, assetID), name, data, created)
+}
@@ -0,0 +1,362 @@
+// Package importer reads conversation histories exported by this server and
+// by other chat applications, so people can bring their history along.
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/export"
+
+	"github.com/google/uuid"
+)
+
+// Formats an import can be in.
+const (
+	FormatNative    = export.FormatName
+	FormatChatGPT   = "chatgpt"
+	FormatOpenWebUI = "open-webui"
+)
+
+// maxEntrySize bounds each file read from a zip, which would otherwise let a
+// small upload expand without limit.
+const maxEntrySize = 512 << 20
+
+// ErrUnknownFormat is returned for files that are none of the supported
+// exports.
+var ErrUnknownFormat = errors.New("unrecognized export format")
+
+// Parsed is one conversation read from an import. Err is set when it could
+// not be converted; Conversation then still carries its ID and title for
+// the report.
+type Parsed struct {
+	Format       string
+	Conversation *database.Conversation // Messages holds every message, all branches
+	Attachments  []database.Attachment
+	Err          error
+}
+
+// Parse detects the format of an upload and reads every conversation in it.
+// Zip files are searched for supported JSON files, which covers both the
+// bulk export and ChatGPT's data export. IDs derived from other
+// applications' IDs depend on owner, the user importing, so two users can
+// import the same file.
+func Parse(data []byte, owner string) ([]Parsed, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseZip(data, owner)
+	}
+	return parseJSON(data, owner, nil)
+}
+
+func parseZip(data []byte, owner string) ([]Parsed, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip: %w", err)
+	}
+
+	files := &zipFiles{archive: archive}
+	var conversations []Parsed
+	found := false
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || path.Ext(entry.Name) != ".json" {
+			continue
+		}
+		content, err := files.read(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := parseJSON(content, owner, files)
+		if errors.Is(err, ErrUnknownFormat) {
+			// Data exports carry other JSON files, e.g. ChatGPT's user.json.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		found = true
+		conversations = append(conversations, parsed...)
+	}
+	if !found {
+		return nil, ErrUnknownFormat
+	}
+	return conversations, nil
+}
+
+// zipFiles looks up the files referenced by an export, such as the images
+// in a ChatGPT data export.
+type zipFiles struct {
+	archive *zip.Reader
+}
+
+func (z *zipFiles) read(entry *zip.File) ([]byte, error) {
+	file, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxEntrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+	}
+	if len(content) > maxEntrySize {
+		return nil, fmt.Errorf("%s is too large", entry.Name)
+	}
+	return content, nil
+}
+
+// find returns the first file whose base name starts with prefix.
+func (z *zipFiles) find(prefix string) (string, []byte, bool) {
+	if z == nil || prefix == "" {
+		return "", nil, false
+	}
+	for _, entry := range z.archive.File {
+		name := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		content, err := z.read(entry)
+		if err != nil {
+			return "", nil, false
+		}
+		return name, content, true
+	}
+	return "", nil, false
+}
+
+// parseJSON tells the formats apart by their shape: our export is an object
+// naming its format, ChatGPT lists conversations with a "mapping" of
+// messages, and Open WebUI lists chats with a "chat" or "history".
+func parseJSON(data []byte, owner string, files *zipFiles) ([]Parsed, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("\xef\xbb\xbf"))
+	if len(data) == 0 {
+		return nil, ErrUnknownFormat
+	}
+
+	var items []json.RawMessage
+	switch data[0] {
+	case '{':
+		var probe struct {
+			Format string `json:"format"`
+		}
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return nil, fmt.Errorf("failed to decode json: %w", err)
+		}
+		if probe.Format != "" {
+			return parseNative(data)
+		}
+		items = []json.RawMessage{data}
+	case '[':
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("failed to decode json: %w", err)
+		}
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	if len(items) == 0 {
+		return []Parsed{}, nil
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(items[0], &probe); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	var parse func(json.RawMessage, string, *zipFiles) Parsed
+	switch {
+	case probe["mapping"] != nil:
+		parse = parseChatGPT
+	case probe["chat"] != nil || probe["history"] != nil:
+		parse = parseOpenWebUI
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	conversations := make([]Parsed, len(items))
+	for i, item := range items {
+		conversations[i] = parse(item, owner, files)
+	}
+	return conversations, nil
+}
+
+func parseNative(data []byte) ([]Parsed, error) {
+	doc, err := export.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	conversations := make([]Parsed, len(doc.Conversations))
+	for i, exported := range doc.Conversations {
+		convo, attachments := exported.ToDatabase()
+		conversations[i] = Parsed{Format: FormatNative, Conversation: convo, Attachments: attachments}
+	}
+	return conversations, nil
+}
+
+// idNamespace keeps IDs derived from other applications' IDs apart from
+// randomly generated ones.
+var idNamespace = uuid.MustParse("6f0d3c6e-2f55-4c1b-9a43-8f1f4b8b5d27")
+
+// stableID derives an ID from IDs in the source export, so importing the
+// same file again yields the same conversation, which is then skipped.
+func stableID(parts ...string) string {
+	return uuid.NewSHA1(idNamespace, []byte(strings.Join(parts, "/"))).String()
+}
+
+// unixTime converts a Unix timestamp in seconds, milliseconds, microseconds
+// or nanoseconds, which exports use interchangeably.
+func unixTime(value float64) time.Time {
+	switch {
+	case value <= 0:
+		return time.Time{}
+	case value > 1e17:
+		return time.Unix(0, int64(value)).UTC()
+	case value > 1e14:
+		return time.UnixMicro(int64(value)).UTC()
+	case value > 1e11:
+		return time.UnixMilli(int64(value)).UTC()
+	}
+	seconds := int64(value)
+	return time.Unix(seconds, int64((value-float64(seconds))*1e9)).UTC().Round(time.Millisecond)
+}
+
+// Images Ollama's vision models accept; other files are left out.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+// newImage returns an attachment for data if it is a supported image.
+func newImage(id, filename string, data []byte, created time.Time) (database.Attachment, bool) {
+	mimeType := http.DetectContentType(data)
+	if !imageTypes[mimeType] {
+		return database.Attachment{}, false
+	}
+	return database.Attachment{
+		ID:        id,
+		Filename:  filename,
+		MimeType:  mimeType,
+		Size:      len(data),
+		Data:      data,
+		CreatedAt: created,
+	}, true
+}
+
+// node is a message of another application's export before it is placed in
+// the tree. Nodes without a message, like hidden system or tool traffic, are
+// dropped and their children attached to the nearest kept ancestor.
+type node struct {
+	id      string
+	parent  string
+	message *database.Message
+
+	// thinking is reasoning stored apart from the reply it belongs to; it is
+	// given to the next assistant message below the node.
+	thinking string
+}
+
+// sortNodes orders nodes read from a JSON object, whose order is random, so
+// imports come out the same every time.
+func sortNodes(nodes []node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+}
+
+// buildTree sets the conversation's messages from nodes, deriving message
+// IDs from the node IDs, and makes the message at or above current the
+// active leaf.
+func buildTree(convo *database.Conversation, nodes []node, current string) {
+	byID := make(map[string]*node, len(nodes))
+	for i := range nodes {
+		byID[nodes[i].id] = &nodes[i]
+	}
+
+	// resolved is what a node's children attach to.
+	type resolved struct {
+		id       string
+		created  time.Time
+		thinking string
+	}
+	memo := map[string]resolved{}
+	var resolve func(id string, depth int) resolved
+	resolve = func(id string, depth int) resolved {
+		n, ok := byID[id]
+		if !ok || depth > len(nodes) { // unknown parent, or a cycle
+			return resolved{created: convo.CreatedAt}
+		}
+		if r, ok := memo[id]; ok {
+			return r
+		}
+
+		parent := resolve(n.parent, depth+1)
+		r := parent
+		if msg := n.message; msg != nil {
+			msg.ID = stableID(convo.ID, n.id)
+			msg.ConversationID = convo.ID
+			if parent.id != "" {
+				parentID := parent.id
+				msg.ParentID = &parentID
+			}
+			if msg.CreatedAt.IsZero() {
+				msg.CreatedAt = parent.created
+			}
+			if msg.Role == database.RoleAssistant && msg.Thinking == nil && parent.thinking != "" {
+				thinking := parent.thinking
+				msg.Thinking = &thinking
+			}
+			r = resolved{id: msg.ID, created: msg.CreatedAt}
+		} else if n.thinking != "" {
+			r.thinking = strings.TrimSpace(parent.thinking + "\n\n" + n.thinking)
+		}
+		memo[id] = r
+		return r
+	}
+
+	convo.Messages = nil
+	for i := range nodes {
+		if nodes[i].message == nil {
+			continue
+		}
+		resolve(nodes[i].id, 0)
+		convo.Messages = append(convo.Messages, *nodes[i].message)
+	}
+	sort.SliceStable(convo.Messages, func(i, j int) bool {
+		return convo.Messages[i].CreatedAt.Before(convo.Messages[j].CreatedAt)
+	})
+
+	if leaf := resolve(current, 0).id; leaf != "" {
+		convo.ActiveLeafID = &leaf
+	}
+}
+
+// newConversation fills in what every imported conversation needs.
+func newConversation(id, title, model string, created, updated time.Time) *database.Conversation {
+	if title = strings.TrimSpace(title); title == "" {
+		title = "Imported conversation"
+	}
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+	if updated.Before(created) {
+		updated = created
+	}
+	return &database.Conversation{
+		ID:        id,
+		Title:     title,
+		Model:     model,
+		CreatedAt: created,
+		UpdatedAt: updated,
+	}
+}
@@ -0,0 +1,242 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/export"
+)
+
+// activeBranch walks from the active leaf up to the first message.
+func activeBranch(convo *database.Conversation) []database.Message {
+	byID := map[string]database.Message{}
+	for _, msg := range convo.Messages {
+		byID[msg.ID] = msg
+	}
+	var branch []database.Message
+	for id := convo.ActiveLeafID; id != nil; id = byID[*id].ParentID {
+		branch = append([]database.Message{byID[*id]}, branch...)
+	}
+	return branch
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return data
+}
+
+func zipFile(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseChatGPT(t *testing.T) {
+	conversations, err := Parse(readTestdata(t, "chatgpt.json"), "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("expected 2 conversations, got %d", len(conversations))
+	}
+	if conversations[1].Err == nil {
+		t.Errorf("expected an error for the conversation without messages")
+	}
+
+	parsed := conversations[0]
+	convo := parsed.Conversation
+	if parsed.Err != nil || parsed.Format != FormatChatGPT {
+		t.Fatalf("unexpected result %+v", parsed)
+	}
+	if convo.Title != "Prime numbers" || convo.Model != "gpt-4o" {
+		t.Errorf("unexpected conversation %+v", convo)
+	}
+	if want := time.Date(2024, 3, 1, 12, 0, 0, 123e6, time.UTC); !convo.CreatedAt.Equal(want) {
+		t.Errorf("expected created at %v, got %v", want, convo.CreatedAt)
+	}
+
+	// Hidden system messages, reasoning and tool traffic are dropped.
+	if len(convo.Messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d: %+v", len(convo.Messages), convo.Messages)
+	}
+
+	branch := activeBranch(convo)
+	if len(branch) != 2 || branch[0].Content != "Is 97 prime? Check with code." || branch[1].Content != "Yes, 97 is prime." {
+		t.Fatalf("unexpected active branch %+v", branch)
+	}
+	if branch[0].ParentID != nil {
+		t.Errorf("expected the prompt to be the first message")
+	}
+	// The image is only available in the zip export.
+	if len(branch[0].Images) != 0 {
+		t.Errorf("expected no images, got %v", branch[0].Images)
+	}
+
+	var first database.Message
+	for _, msg := range convo.Messages {
+		if msg.Content == "No, 91 is 7 × 13." {
+			first = msg
+		}
+	}
+	if first.Thinking == nil || *first.Thinking != "91 = 7 * 13." || first.Model != "o3" {
+		t.Errorf("expected the reasoning to move to the reply, got %+v", first)
+	}
+	if first.ParentID == nil || *first.ParentID == branch[0].ID {
+		t.Errorf("expected the first reply to be on the other branch")
+	}
+}
+
+func TestParseChatGPTZip(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	data := zipFile(t, map[string][]byte{
+		"conversations.json":    readTestdata(t, "chatgpt.json"),
+		"user.json":             []byte(`{"id": "user-1", "email": "someone@example.com"}`),
+		"file-abc123-cat.png":   png,
+		"chat.html":             []byte("<html></html>"),
+		"message_feedback.json": []byte(`[]`),
+	})
+
+	conversations, err := Parse(data, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("expected 2 conversations, got %d", len(conversations))
+	}
+
+	parsed := conversations[0]
+	if len(parsed.Attachments) != 1 || parsed.Attachments[0].Filename != "file-abc123-cat.png" || parsed.Attachments[0].MimeType != "image/png" {
+		t.Fatalf("expected the image to be attached, got %+v", parsed.Attachments)
+	}
+	branch := activeBranch(parsed.Conversation)
+	if len(branch[0].Images) != 1 || branch[0].Images[0] != parsed.Attachments[0].ID {
+		t.Errorf("expected the prompt to reference the image, got %v", branch[0].Images)
+	}
+}
+
+func TestParseOpenWebUI(t *testing.T) {
+	conversations, err := Parse(readTestdata(t, "openwebui.json"), "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(conversations) != 1 || conversations[0].Err != nil {
+		t.Fatalf("unexpected result %+v", conversations)
+	}
+
+	parsed := conversations[0]
+	convo := parsed.Conversation
+	if convo.Title != "🦙 Llama facts" || convo.Model != "llama3.2:latest" || convo.SystemPrompt != "Answer in one sentence." || !convo.Pinned {
+		t.Errorf("unexpected conversation %+v", convo)
+	}
+	if want := time.Unix(1717243200, 0); !convo.CreatedAt.Equal(want) {
+		t.Errorf("expected created at %v, got %v", want, convo.CreatedAt)
+	}
+	if len(convo.Messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(convo.Messages))
+	}
+
+	branch := activeBranch(convo)
+	if len(branch) != 2 {
+		t.Fatalf("expected 2 messages on the active branch, got %+v", branch)
+	}
+	if len(branch[0].Images) != 1 || len(parsed.Attachments) != 1 || parsed.Attachments[0].Filename != "llama.png" {
+		t.Errorf("expected the image to be attached, got %v, %+v", branch[0].Images, parsed.Attachments)
+	}
+	reply := branch[1]
+	if reply.Content != "Llamas usually live 15 to 25 years." || reply.Model != "qwen3:8b" {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+	if reply.Thinking == nil || *reply.Thinking != "Llamas live\n15 to 25 years." {
+		t.Errorf("expected reasoning to be extracted, got %v", reply.Thinking)
+	}
+
+	for _, msg := range convo.Messages {
+		if msg.Model == "llama3.2:latest" && (msg.Content != "About 20 years." || msg.Thinking == nil || *msg.Thinking != "Short answer.") {
+			t.Errorf("expected think tags to be extracted, got %+v", msg)
+		}
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	for _, name := range []string{"chatgpt.json", "openwebui.json"} {
+		first, err := Parse(readTestdata(t, name), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, _ := Parse(readTestdata(t, name), "")
+		if first[0].Conversation.ID != second[0].Conversation.ID || *first[0].Conversation.ActiveLeafID != *second[0].Conversation.ActiveLeafID {
+			t.Errorf("%s: expected the same IDs on every import", name)
+		}
+		other, _ := Parse(readTestdata(t, name), "another-user")
+		if other[0].Conversation.ID == first[0].Conversation.ID || *other[0].Conversation.ActiveLeafID == *first[0].Conversation.ActiveLeafID {
+			t.Errorf("%s: expected other IDs for another user", name)
+		}
+	}
+}
+
+func TestParseNative(t *testing.T) {
+	doc := export.NewDocument(export.Conversation{
+		ID:       "c1",
+		Title:    "Ours",
+		Messages: []export.Message{{ID: "m1", Role: database.RoleUser, Content: "hi"}},
+	})
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range [][]byte{data, zipFile(t, map[string][]byte{"ours-c1.json": data})} {
+		conversations, err := Parse(input, "")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(conversations) != 1 || conversations[0].Format != FormatNative || conversations[0].Conversation.ID != "c1" {
+			t.Errorf("unexpected result %+v", conversations)
+		}
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	for _, input := range []string{
+		`hello`,
+		`[{"question": "?", "answer": "!"}]`,
+		`{"format": "tiny-ollama-chat", "version": 99}`,
+	} {
+		if _, err := Parse([]byte(input), ""); err == nil {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+	if _, err := Parse(zipFile(t, map[string][]byte{"user.json": []byte(`{}`)}), ""); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("expected ErrUnknownFormat for a zip without conversations, got %v", err)
+	}
+}
+
+func TestUnixTime(t *testing.T) {
+	want := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, value := range []float64{1717243200, 1717243200000, 1717243200000000, 1717243200000000000} {
+		if got := unixTime(value); !got.Equal(want) {
+			t.Errorf("unixTime(%v) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// openWebUIExport is one chat of an Open WebUI export. "Export All Chats"
+// wraps each chat with its database row; exporting a single chat may give
+// the bare chat object.
+type openWebUIExport struct {
+	ID        string        `json:"id"`
+	Title     string        `json:"title"`
+	Chat      openWebUIChat `json:"chat"`
+	CreatedAt float64       `json:"created_at"`
+	UpdatedAt float64       `json:"updated_at"`
+	Archived  bool          `json:"archived"`
+	Pinned    bool          `json:"pinned"`
+}
+
+type openWebUIChat struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Models    []string `json:"models"`
+	System    string   `json:"system"`
+	Timestamp float64  `json:"timestamp"`
+	Params    struct {
+		System string `json:"system"`
+	} `json:"params"`
+	History struct {
+		Messages  map[string]openWebUIMessage `json:"messages"`
+		CurrentID string                      `json:"currentId"`
+	} `json:"history"`
+	Messages []openWebUIMessage `json:"messages"` // the current branch only
+}
+
+type openWebUIMessage struct {
+	ID        string  `json:"id"`
+	ParentID  string  `json:"parentId"`
+	Role      string  `json:"role"`
+	Content   string  `json:"content"`
+	Model     string  `json:"model"`
+	Timestamp float64 `json:"timestamp"`
+	Files     []struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"files"`
+}
+
+// reasoningBlock is how Open WebUI stores a model's reasoning inside the
+// reply: a collapsed <details type="reasoning"> with the thoughts quoted.
+var reasoningBlock = regexp.MustCompile(`(?s)<details type="reasoning"[^>]*>\s*(?:<summary>.*?</summary>)?(.*?)</details>\s*`)
+
+func parseOpenWebUI(data json.RawMessage, owner string, _ *zipFiles) Parsed {
+	parsed := Parsed{Format: FormatOpenWebUI}
+
+	var source openWebUIExport
+	err := json.Unmarshal(data, &source)
+	if err == nil && source.Chat.History.Messages == nil && source.Chat.Messages == nil {
+		// A bare chat object.
+		err = json.Unmarshal(data, &source.Chat)
+	}
+	if err != nil {
+		parsed.Conversation = &database.Conversation{}
+		parsed.Err = fmt.Errorf("failed to decode chat: %w", err)
+		return parsed
+	}
+	chat := source.Chat
+
+	sourceID := source.ID
+	if sourceID == "" {
+		sourceID = chat.ID
+	}
+	title := source.Title
+	if title == "" {
+		title = chat.Title
+	}
+	if sourceID == "" {
+		sourceID = fmt.Sprintf("%s@%f", title, chat.Timestamp)
+	}
+	created := unixTime(source.CreatedAt)
+	if created.IsZero() {
+		created = unixTime(chat.Timestamp)
+	}
+	model := ""
+	if len(chat.Models) > 0 {
+		model = chat.Models[0]
+	}
+
+	convo := newConversation(stableID(owner, FormatOpenWebUI, sourceID), title, model, created, unixTime(source.UpdatedAt))
+	convo.Archived = source.Archived
+	convo.Pinned = source.Pinned
+	convo.SystemPrompt = chat.System
+	if convo.SystemPrompt == "" {
+		convo.SystemPrompt = chat.Params.System
+	}
+	parsed.Conversation = convo
+
+	// The history holds every branch; older exports only have the flat list
+	// of the current one.
+	messages := chat.History.Messages
+	current := chat.History.CurrentID
+	if len(messages) == 0 {
+		messages = make(map[string]openWebUIMessage, len(chat.Messages))
+		for i, msg := range chat.Messages {
+			if msg.ID == "" {
+				msg.ID = fmt.Sprint(i)
+			}
+			if msg.ParentID == "" && i > 0 {
+				msg.ParentID = chat.Messages[i-1].ID
+			}
+			messages[msg.ID] = msg
+			current = msg.ID
+		}
+	}
+
+	nodes := make([]node, 0, len(messages))
+	for id, msg := range messages {
+		if msg.ID == "" {
+			msg.ID = id
+		}
+		n := node{id: msg.ID, parent: msg.ParentID}
+		n.message = openWebUIMessageToDatabase(convo.ID, msg, &parsed.Attachments)
+		nodes = append(nodes, n)
+	}
+	sortNodes(nodes)
+
+	buildTree(convo, nodes, current)
+	if len(convo.Messages) == 0 {
+		parsed.Err = fmt.Errorf("chat has no messages")
+	}
+	return parsed
+}
+
+func openWebUIMessageToDatabase(convoID string, msg openWebUIMessage, attachments *[]database.Attachment) *database.Message {
+	if msg.Role != database.RoleUser && msg.Role != database.RoleAssistant {
+		return nil
+	}
+
+	message := &database.Message{
+		Role:      msg.Role,
+		CreatedAt: unixTime(msg.Timestamp),
+	}
+	content := msg.Content
+
+	if msg.Role == database.RoleAssistant {
+		message.Model = msg.Model
+
+		var thoughts []string
+		for _, match := range reasoningBlock.FindAllStringSubmatch(content, -1) {
+			thoughts = append(thoughts, unquote(match[1]))
+		}
+		content = reasoningBlock.ReplaceAllString(content, "")
+
+		// Replies of models that think in tags keep them in the content.
+		var parser ollama.ThinkParser
+		var text strings.Builder
+		for _, segment := range append(parser.Feed(content), parser.Flush()...) {
+			if segment.Thinking {
+				thoughts = append(thoughts, segment.Text)
+			} else {
+				text.WriteString(segment.Text)
+			}
+		}
+		content = strings.TrimSpace(text.String())
+
+		if thinking := strings.TrimSpace(strings.Join(thoughts, "\n\n")); thinking != "" {
+			message.Thinking = &thinking
+		}
+	}
+
+	for i, file := range msg.Files {
+		data, ok := dataURL(file.URL)
+		if file.Type != "image" || !ok {
+			continue
+		}
+		name := file.Name
+		if name == "" {
+			name = fmt.Sprintf("image-%d", i+1)
+		}
+		if image, ok := newImage(stableID(convoID, msg.ID, fmt.Sprint(i)), name, data, message.CreatedAt); ok {
+			*attachments = append(*attachments, image)
+			message.Images = append(message.Images, image.ID)
+		}
+	}
+
+	if content == "" && len(message.Images) == 0 && message.Thinking == nil {
+		return nil
+	}
+	message.Content = content
+	message.RawContent = content
+	return message
+}
+
+// unquote strips the "> " Open WebUI puts before each line of reasoning.
+func unquote(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(strings.TrimPrefix(line, ">"), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dataURL decodes a base64 data: URL, which is how Open WebUI embeds images
+// in chats.
+func dataURL(url string) ([]byte, bool) {
+	header, payload, ok := strings.Cut(url, ",")
+	if !ok || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	return data, err == nil
+}
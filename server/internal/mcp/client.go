@@ -0,0 +1,375 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protocolVersion is the MCP revision this client speaks.
+const protocolVersion = "2024-11-05"
+
+const (
+	initTimeout    = 30 * time.Second
+	maxMessageSize = 16 * 1024 * 1024
+)
+
+// ErrClosed is returned by calls made after the server process exited.
+var ErrClosed = errors.New("mcp server is not running")
+
+// ServerConfig describes how to launch one MCP server. It uses the same shape
+// as the "mcpServers" entries of other MCP clients, so existing configuration
+// can be copied over.
+type ServerConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// ToolDefinition is a tool as listed by tools/list.
+type ToolDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+}
+
+// Content is one item of a tools/call result. Only text is passed on to
+// models; other kinds are summarised.
+type Content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Resource *struct {
+		URI  string `json:"uri"`
+		Text string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+type CallToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Text flattens the result into the string handed back to the model.
+func (r *CallToolResult) Text() string {
+	parts := make([]string, 0, len(r.Content))
+	for _, item := range r.Content {
+		switch {
+		case item.Type == "text":
+			parts = append(parts, item.Text)
+		case item.Type == "resource" && item.Resource != nil && item.Resource.Text != "":
+			parts = append(parts, item.Resource.Text)
+		case item.Type == "resource" && item.Resource != nil:
+			parts = append(parts, fmt.Sprintf("[resource %s]", item.Resource.URI))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content omitted]", item.Type))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// rpcMessage covers requests, notifications and responses; which one it is
+// follows from the fields present.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("mcp error %d: %s", e.Code, e.Message)
+}
+
+// Client talks JSON-RPC to one MCP server running as a child process, one
+// message per line on its stdin and stdout.
+type Client struct {
+	name string
+	cmd  *exec.Cmd
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcMessage
+	done    chan struct{} // closed once the process has exited
+	exitErr error
+}
+
+// Start launches the server and performs the initialize handshake.
+func Start(ctx context.Context, name string, config ServerConfig) (*Client, error) {
+	if config.Command == "" {
+		return nil, fmt.Errorf("mcp server %s has no command", name)
+	}
+
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range config.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stderr: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mcp server %s: %w", name, err)
+	}
+
+	c := &Client{
+		name:    name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: map[int64]chan rpcMessage{},
+		done:    make(chan struct{}),
+	}
+	go c.logStderr(stderr)
+	go c.readLoop(stdout)
+
+	initCtx, cancel := context.WithTimeout(ctx, initTimeout)
+	defer cancel()
+
+	params := map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "tiny-ollama-chat", "version": "1.0.0"},
+	}
+	if err := c.call(initCtx, "initialize", params, nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize mcp server %s: %w", name, err)
+	}
+	if err := c.notify("notifications/initialized"); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to initialize mcp server %s: %w", name, err)
+	}
+
+	return c, nil
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Running reports whether the server process is still alive.
+func (c *Client) Running() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// ListTools returns every tool the server offers, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]ToolDefinition, error) {
+	var definitions []ToolDefinition
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var page struct {
+			Tools      []ToolDefinition `json:"tools"`
+			NextCursor string           `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, fmt.Errorf("failed to list tools: %w", err)
+		}
+		definitions = append(definitions, page.Tools...)
+
+		if page.NextCursor == "" {
+			return definitions, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CallTool runs a tool. A result with IsError set is returned as is; the
+// error is only set when the call itself failed.
+func (c *Client) CallTool(ctx context.Context, name string, args json.RawMessage) (*CallToolResult, error) {
+	params := map[string]any{
+		"name":      name,
+		"arguments": args,
+	}
+
+	var result CallToolResult
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return nil, fmt.Errorf("failed to call tool %s: %w", name, err)
+	}
+	return &result, nil
+}
+
+// Close stops the server: closing stdin asks it to exit, and it is killed if
+// it hasn't within a few seconds.
+func (c *Client) Close() error {
+	c.stdin.Close()
+	select {
+	case <-c.done:
+	case <-time.After(3 * time.Second):
+		c.cmd.Process.Kill()
+		<-c.done
+	}
+	return nil
+}
+
+func (c *Client) call(ctx context.Context, method string, params any, result any) error {
+	c.mu.Lock()
+	if !c.Running() {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	response := make(chan rpcMessage, 1)
+	c.pending[id] = response
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	idJSON, _ := json.Marshal(id)
+	if err := c.write(rpcMessage{JSONRPC: "2.0", ID: idJSON, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case msg := <-response:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		c.notifyCancelled(id, ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (c *Client) notify(method string) error {
+	return c.write(rpcMessage{JSONRPC: "2.0", Method: method})
+}
+
+// notifyCancelled tells the server a request was abandoned so it can stop
+// working on it.
+func (c *Client) notifyCancelled(id int64, reason error) {
+	c.write(rpcMessage{
+		JSONRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  map[string]any{"requestId": id, "reason": reason.Error()},
+	})
+}
+
+func (c *Client) write(msg rpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to mcp server %s: %w", c.name, err)
+	}
+	return nil
+}
+
+// readLoop dispatches responses to the waiting calls and answers the few
+// requests a server may send us, until the process closes stdout.
+func (c *Client) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("MCP server %s sent invalid JSON: %v", c.name, err)
+			continue
+		}
+
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			c.handleServerRequest(msg)
+		case msg.Method != "":
+			log.Printf("MCP server %s sent notification %s", c.name, msg.Method)
+		default:
+			var id int64
+			if err := json.Unmarshal(msg.ID, &id); err != nil {
+				log.Printf("MCP server %s sent a response with unknown id %s", c.name, msg.ID)
+				continue
+			}
+			c.mu.Lock()
+			response, ok := c.pending[id]
+			c.mu.Unlock()
+			if ok {
+				response <- msg
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read from MCP server %s: %v", c.name, err)
+	}
+
+	err := c.cmd.Wait()
+	c.mu.Lock()
+	c.exitErr = err
+	close(c.done)
+	c.mu.Unlock()
+	log.Printf("MCP server %s exited: %v", c.name, err)
+}
+
+// handleServerRequest answers pings and refuses everything else, as this
+// client offers no sampling or roots capabilities.
+func (c *Client) handleServerRequest(msg rpcMessage) {
+	reply := rpcMessage{JSONRPC: "2.0", ID: msg.ID}
+	if msg.Method == "ping" {
+		reply.Result = json.RawMessage("{}")
+	} else {
+		reply.Error = &rpcError{Code: -32601, Message: "method not found: " + msg.Method}
+	}
+	c.write(reply)
+}
+
+func (c *Client) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Printf("[mcp %s] %s", c.name, scanner.Text())
+	}
+}
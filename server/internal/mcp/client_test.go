@@ -0,0 +1,157 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/tools"
+)
+
+// fakeServer is the path of the testdata/fakemcp binary built by TestMain.
+var fakeServer string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fakemcp")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fakeServer = filepath.Join(dir, "fakemcp")
+	build := exec.Command("go", "build", "-o", fakeServer, "./testdata/fakemcp")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to build fake mcp server:", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestClientListAndCallTools(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := Start(ctx, "fake", ServerConfig{Command: fakeServer})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer client.Close()
+
+	definitions, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(definitions) != 3 {
+		t.Fatalf("expected 3 tools across both pages, got %d", len(definitions))
+	}
+	if definitions[0].Name != "echo" || len(definitions[0].InputSchema) == 0 {
+		t.Errorf("unexpected first tool: %+v", definitions[0])
+	}
+
+	result, err := client.CallTool(ctx, "echo", json.RawMessage(`{"text":"hello"}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result.IsError || result.Text() != "hello" {
+		t.Errorf("expected 'hello', got %+v", result)
+	}
+
+	result, err = client.CallTool(ctx, "fail", json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !result.IsError || result.Text() != "something went wrong" {
+		t.Errorf("expected an error result, got %+v", result)
+	}
+
+	if _, err := client.CallTool(ctx, "missing", json.RawMessage(`{}`)); err == nil {
+		t.Error("expected an error calling an unknown tool, got nil")
+	}
+}
+
+func TestServersRegisterAndRestart(t *testing.T) {
+	registry := tools.NewRegistry()
+	StartServers(&Config{Servers: map[string]ServerConfig{
+		"fake":   {Command: fakeServer},
+		"broken": {Command: filepath.Join(t.TempDir(), "missing")},
+	}}, registry)
+	defer StopServers()
+
+	statuses := Servers()
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(statuses))
+	}
+	if statuses[0].Name != "broken" || statuses[0].Running || statuses[0].Error == "" {
+		t.Errorf("expected broken server to report an error, got %+v", statuses[0])
+	}
+	if statuses[1].Name != "fake" || !statuses[1].Running || len(statuses[1].Tools) != 3 {
+		t.Errorf("expected fake server running with 3 tools, got %+v", statuses[1])
+	}
+
+	tool, ok := registry.Get("fake__echo")
+	if !ok {
+		t.Fatal("expected fake__echo to be registered")
+	}
+	if tool.(*Tool).Server() != "fake" {
+		t.Errorf("expected server 'fake', got '%s'", tool.(*Tool).Server())
+	}
+
+	call := func(name, args string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return registry.Call(ctx, ollama.ToolCall{
+			Function: ollama.ToolCallFunction{Name: name, Arguments: json.RawMessage(args)},
+		})
+	}
+
+	if output, err := call("fake__echo", `{"text":"hi"}`); err != nil || output != "hi" {
+		t.Errorf("expected 'hi', got '%s' (%v)", output, err)
+	}
+	if _, err := call("fake__fail", `{}`); err == nil || err.Error() != "something went wrong" {
+		t.Errorf("expected the tool's error text, got %v", err)
+	}
+	if _, err := call("fake__crash", `{}`); err == nil {
+		t.Error("expected an error when the server exits mid-call, got nil")
+	}
+
+	// The next call starts the server again.
+	if output, err := call("fake__echo", `{"text":"back"}`); err != nil || output != "back" {
+		t.Errorf("expected 'back' after restart, got '%s' (%v)", output, err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "mcp.json")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	config, err := LoadConfig(write(`{"mcpServers": {"files": {"command": "mcp-files", "args": ["/tmp"], "env": {"DEBUG": "1"}}}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	files := config.Servers["files"]
+	if files.Command != "mcp-files" || len(files.Args) != 1 || files.Env["DEBUG"] != "1" {
+		t.Errorf("unexpected server config: %+v", files)
+	}
+
+	if _, err := LoadConfig(write(`{"mcpServers": {"bad name": {"command": "x"}}}`)); err == nil {
+		t.Error("expected an error for an invalid server name, got nil")
+	}
+	if _, err := LoadConfig(write(`{"mcpServers": {"files": {}}}`)); err == nil {
+		t.Error("expected an error for a server without command, got nil")
+	}
+}
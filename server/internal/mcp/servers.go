@@ -0,0 +1,231 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"ollama-tiny-chat/server/internal/tools"
+)
+
+const listTimeout = 30 * time.Second
+
+// toolNameSeparator joins the server and tool names in the name models see,
+// keeping tools of different servers apart.
+const toolNameSeparator = "__"
+
+// Config is the MCP configuration file: {"mcpServers": {"name": {...}}}.
+type Config struct {
+	Servers map[string]ServerConfig `json:"mcpServers"`
+}
+
+var serverName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// LoadConfig reads and validates an MCP configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mcp config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse mcp config: %w", err)
+	}
+	for name, server := range config.Servers {
+		if !serverName.MatchString(name) {
+			return nil, fmt.Errorf("invalid mcp server name %q: use letters, digits, '-' and '_'", name)
+		}
+		if server.Command == "" {
+			return nil, fmt.Errorf("mcp server %s has no command", name)
+		}
+	}
+	return &config, nil
+}
+
+// ServerStatus describes a configured server for the API.
+type ServerStatus struct {
+	Name    string   `json:"name"`
+	Running bool     `json:"running"`
+	Error   string   `json:"error,omitempty"`
+	Tools   []string `json:"tools"`
+}
+
+// server is one configured MCP server. The client is replaced when the
+// process has died and a tool call needs it again.
+type server struct {
+	name   string
+	config ServerConfig
+
+	mu      sync.Mutex
+	client  *Client
+	lastErr error
+	tools   []string
+}
+
+var (
+	serversMu sync.Mutex
+	servers   = map[string]*server{}
+)
+
+// StartServers launches every configured server and registers its tools with
+// registry. A server that fails to start is logged and skipped so the others,
+// and the chat itself, keep working.
+func StartServers(config *Config, registry *tools.Registry) {
+	for name, serverConfig := range config.Servers {
+		srv := &server{name: name, config: serverConfig}
+
+		serversMu.Lock()
+		servers[name] = srv
+		serversMu.Unlock()
+
+		if err := srv.registerTools(registry); err != nil {
+			log.Printf("MCP server %s unavailable: %v", name, err)
+			srv.mu.Lock()
+			srv.lastErr = err
+			srv.mu.Unlock()
+			continue
+		}
+		log.Printf("MCP server %s started with %d tools", name, len(srv.tools))
+	}
+}
+
+// StopServers shuts down every running server.
+func StopServers() {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	for _, srv := range servers {
+		srv.mu.Lock()
+		if srv.client != nil {
+			srv.client.Close()
+		}
+		srv.mu.Unlock()
+	}
+}
+
+// Servers lists the configured servers sorted by name.
+func Servers() []ServerStatus {
+	serversMu.Lock()
+	list := make([]*server, 0, len(servers))
+	for _, srv := range servers {
+		list = append(list, srv)
+	}
+	serversMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	statuses := make([]ServerStatus, len(list))
+	for i, srv := range list {
+		srv.mu.Lock()
+		statuses[i] = ServerStatus{
+			Name:    srv.name,
+			Running: srv.client != nil && srv.client.Running(),
+			Tools:   append([]string{}, srv.tools...),
+		}
+		if srv.lastErr != nil {
+			statuses[i].Error = srv.lastErr.Error()
+		}
+		srv.mu.Unlock()
+	}
+	return statuses
+}
+
+func (s *server) registerTools(registry *tools.Registry) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	definitions, err := client.ListTools(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, definition := range definitions {
+		tool := &Tool{server: s, definition: definition}
+		if err := registry.Register(tool); err != nil {
+			log.Printf("Skipping MCP tool %s: %v", tool.Name(), err)
+			continue
+		}
+		s.mu.Lock()
+		s.tools = append(s.tools, definition.Name)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// connect returns the running client, starting the process if it isn't.
+func (s *server) connect() (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil && s.client.Running() {
+		return s.client, nil
+	}
+	if s.client != nil {
+		log.Printf("MCP server %s is not running, restarting it", s.name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), initTimeout)
+	defer cancel()
+
+	client, err := Start(ctx, s.name, s.config)
+	s.lastErr = err
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+// Tool exposes one MCP tool to models through the tools registry.
+type Tool struct {
+	server     *server
+	definition ToolDefinition
+}
+
+func (t *Tool) Name() string {
+	return t.server.name + toolNameSeparator + t.definition.Name
+}
+
+// Server is the name of the MCP server providing the tool, which is what
+// conversations enable and disable.
+func (t *Tool) Server() string {
+	return t.server.name
+}
+
+func (t *Tool) Description() string {
+	return t.definition.Description
+}
+
+func (t *Tool) Parameters() json.RawMessage {
+	if len(t.definition.InputSchema) == 0 {
+		return json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return t.definition.InputSchema
+}
+
+func (t *Tool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	client, err := t.server.connect()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := client.CallTool(ctx, t.definition.Name, args)
+	if err != nil {
+		return "", err
+	}
+	if result.IsError {
+		return "", errors.New(result.Text())
+	}
+	return result.Text(), nil
+}
@@ -0,0 +1,104 @@
+// fakemcp is a minimal MCP server for tests. It speaks newline-delimited
+// JSON-RPC on stdin and stdout and offers three tools across two pages of
+// tools/list: "echo" returns its text argument, "fail" returns an error
+// result, and "crash" exits the process without answering.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   any             `json:"error,omitempty"`
+}
+
+var out = json.NewEncoder(os.Stdout)
+
+func reply(id json.RawMessage, result any) {
+	out.Encode(message{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func text(s string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]string{{"type": "text", "text": s}},
+		"isError": isError,
+	}
+}
+
+func main() {
+	fmt.Fprintln(os.Stderr, "fakemcp starting")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		switch msg.Method {
+		case "initialize":
+			reply(msg.ID, map[string]any{
+				"protocolVersion": "2024-11-05",
+				"capabilities":    map[string]any{"tools": map[string]any{}},
+				"serverInfo":      map[string]string{"name": "fakemcp", "version": "0.0.1"},
+			})
+		case "notifications/initialized":
+			// Exercise the client's handling of server-initiated requests.
+			out.Encode(message{JSONRPC: "2.0", ID: json.RawMessage(`"server-1"`), Method: "ping"})
+		case "tools/list":
+			var params struct {
+				Cursor string `json:"cursor"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			if params.Cursor == "" {
+				reply(msg.ID, map[string]any{
+					"tools": []map[string]any{{
+						"name":        "echo",
+						"description": "Echo the text argument",
+						"inputSchema": map[string]any{
+							"type":       "object",
+							"properties": map[string]any{"text": map[string]string{"type": "string"}},
+							"required":   []string{"text"},
+						},
+					}},
+					"nextCursor": "page2",
+				})
+			} else {
+				reply(msg.ID, map[string]any{
+					"tools": []map[string]any{
+						{"name": "fail", "description": "Always fails"},
+						{"name": "crash", "description": "Exits the server"},
+					},
+				})
+			}
+		case "tools/call":
+			var params struct {
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			switch params.Name {
+			case "echo":
+				reply(msg.ID, text(fmt.Sprint(params.Arguments["text"]), false))
+			case "fail":
+				reply(msg.ID, text("something went wrong", true))
+			case "crash":
+				os.Exit(1)
+			default:
+				out.Encode(message{JSONRPC: "2.0", ID: msg.ID, Error: map[string]any{"code": -32602, "message": "unknown tool"}})
+			}
+		default:
+			if msg.ID != nil && msg.Method != "" {
+				out.Encode(message{JSONRPC: "2.0", ID: msg.ID, Error: map[string]any{"code": -32601, "message": "method not found"}})
+			}
+		}
+	}
+}
@@ -2,16 +2,17 @@ package ollama
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 )
 
 const (
 	defaultBaseUrl = "http://localhost:11434"
 	modelListPath  = "/api/tags"
-	generatePath   = "/api/generate"
+	chatPath       = "/api/chat"
 )
 
 type Client struct {
@@ -20,29 +21,91 @@ type Client struct {
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"` // reasoning, sent separately when the request sets Think
+	Images    []string   `json:"images,omitempty"`   // base64-encoded, for multimodal models
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"` // set on "tool" messages carrying a result
 }
 
-type GenerateRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+// Tool describes a function the model may call, in the shape of OpenAI's
+// function-calling schema that Ollama accepts.
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
 }
 
-type GenerateResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type ChatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+	Options  *Options  `json:"options,omitempty"`
+	Tools    []Tool    `json:"tools,omitempty"`
+
+	// Think asks a reasoning model to think, or not, before answering. Its
+	// reasoning then arrives in Message.Thinking instead of <think> tags.
+	// Nil leaves it to the model's default.
+	Think *bool `json:"think,omitempty"`
+}
+
+type ChatResponse struct {
+	Model      string  `json:"model"`
+	Message    Message `json:"message"`
+	Done       bool    `json:"done"`
+	DoneReason string  `json:"done_reason,omitempty"` // "stop", or "length" when num_predict ran out
+	Error      string  `json:"error,omitempty"`
+
+	Metrics
+}
+
+// Metrics are the token counts and timings Ollama reports on the final chunk
+// of a response. Durations are in nanoseconds.
+type Metrics struct {
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"`
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int   `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+}
+
+// TokensPerSecond is the generation speed, excluding prompt processing and
+// model loading. It is zero when Ollama reported no generation time.
+func (m Metrics) TokensPerSecond() float64 {
+	if m.EvalDuration <= 0 {
+		return 0
+	}
+	return float64(m.EvalCount) / (float64(m.EvalDuration) / 1e9)
 }
 
 type ModelDetails struct {
-	ParameterSize string `json:"parameter_size"`
+	ParameterSize     string `json:"parameter_size"`
+	Family            string `json:"family,omitempty"`
+	Format            string `json:"format,omitempty"`
+	QuantizationLevel string `json:"quantization_level,omitempty"`
 }
 
 type ModelInfo struct {
-	Name    string       `json:"name"`
-	Model   string       `json:"model"`
-	Details ModelDetails `json:"details"`
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt *time.Time   `json:"modified_at,omitempty"`
+	Details    ModelDetails `json:"details"`
 }
 
 type ListModelResponse struct {
@@ -60,8 +123,14 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
-func (c *Client) ListModels() ([]ModelInfo, error) {
-	resp, err := c.httpClient.Get(c.baseURL + modelListPath)
+// ListModels returns the models Ollama has pulled. It doubles as a health
+// check, so an error status is an error.
+func (c *Client) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+modelListPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(httpReq)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get models: %w", err)
@@ -69,6 +138,10 @@ func (c *Client) ListModels() ([]ModelInfo, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
 	var response ListModelResponse
 
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
@@ -78,51 +151,70 @@ func (c *Client) ListModels() ([]ModelInfo, error) {
 	return response.Models, nil
 }
 
-func (c *Client) GenerateStream(model string, messages []Message) (*http.Response, error) {
-
-	var prompt strings.Builder
-
-	for _, msg := range messages {
-		prompt.WriteString(fmt.Sprintf("%s: %s\n", msg.Role, msg.Content))
-	}
-
-	reqBody := GenerateRequest{
-		Model:  model,
-		Prompt: prompt.String(),
-		Stream: true,
-	}
+// ChatStream sends the conversation as role-tagged messages to /api/chat so the
+// model's own chat template is applied. The caller owns the returned body,
+// which streams one ChatResponse per line.
+// Cancelling ctx aborts the upstream request, including a stream in progress.
+func (c *Client) ChatStream(ctx context.Context, req ChatRequest) (*http.Response, error) {
+	req.Stream = true
 
-	jsonData, err := json.Marshal(reqBody)
+	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := c.httpClient.Post(c.baseURL+generatePath, "application/json",
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+chatPath,
 		bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+
 	return resp, nil
 }
 
-// func (c *Client) GenerateStreamWithHistory(model string, messages []Message) (*http.Response, error) {
-// 	reqBody := GenerateRequest{
-// 		Model:    model,
-// 		Messages: messages,
-// 		Stream:   true,
-// 	}
+// Chat sends a non-streaming chat request and returns the complete reply.
+func (c *Client) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	req.Stream = false
+
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+chatPath,
+		bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
 
-// 	jsonData, err := json.Marshal(reqBody)
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to marshal request: %w", err)
-// 	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
 
-// 	resp, err := c.httpClient.Post(c.baseURL+generatePath, "application/json",
-// 		bytes.NewBuffer(jsonData))
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to send request: %w", err)
-// 	}
+	var chatResp ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if chatResp.Error != "" {
+			return nil, fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, chatResp.Error)
+		}
+		return nil, fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
 
-// 	return resp, nil
-// }
+	return &chatResp, nil
+}
@@ -1,6 +1,9 @@
 package ollama
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -31,7 +34,7 @@ func TestModelSuccess(t *testing.T) {
 
 	client := NewClient(ts.URL)
 
-	models, err := client.ListModels()
+	models, err := client.ListModels(context.Background())
 
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
@@ -57,7 +60,7 @@ func TestListModelsDecodeError(t *testing.T) {
 	defer ts.Close()
 
 	client := NewClient(ts.URL)
-	_, err := client.ListModels()
+	_, err := client.ListModels(context.Background())
 	if err == nil {
 		t.Fatal("expected an error decoding JSON, got nil")
 	}
@@ -66,3 +69,195 @@ func TestListModelsDecodeError(t *testing.T) {
 		t.Errorf("unexpected error message: %v", err)
 	}
 }
+
+func TestListModelsStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, `{"error": "server busy"}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	_, err := client.ListModels(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "server busy") {
+		t.Errorf("expected the status error, got %v", err)
+	}
+}
+
+func TestChatStreamSendsMessages(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != chatPath {
+			t.Fatalf("expected path %q, got %q", chatPath, r.URL.Path)
+		}
+
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Model != "testModel" || !req.Stream {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		if len(req.Messages) != 3 {
+			t.Fatalf("expected 3 messages, got %d", len(req.Messages))
+		}
+		if req.Messages[1].Role != "assistant" || req.Messages[1].Content != "hello" {
+			t.Errorf("unexpected second message: %+v", req.Messages[1])
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model":"testModel","message":{"role":"assistant","content":"Hi "},"done":false}`)
+		fmt.Fprintln(w, `{"model":"testModel","message":{"role":"assistant","content":"there"},"done":true}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	resp, err := client.ChatStream(context.Background(), ChatRequest{
+		Model: "testModel",
+		Messages: []Message{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+			{Role: "user", Content: "how are you?"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var chunk ChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			t.Fatalf("failed to decode chunk: %v", err)
+		}
+		content.WriteString(chunk.Message.Content)
+	}
+
+	if content.String() != "Hi there" {
+		t.Errorf("expected streamed content 'Hi there', got '%s'", content.String())
+	}
+}
+
+func TestChatStreamErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, `{"error":"model 'missing' not found"}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	_, err := client.ChatStream(context.Background(), ChatRequest{
+		Model:    "missing",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	})
+	if err == nil {
+		t.Fatal("expected an error for non-200 status, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "not found") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestChatStreamCancel(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model":"testModel","message":{"role":"assistant","content":"partial"},"done":false}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+
+	defer ts.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewClient(ts.URL)
+	resp, err := client.ChatStream(ctx, ChatRequest{
+		Model:    "testModel",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() {
+		t.Fatalf("expected a first chunk, got error %v", scanner.Err())
+	}
+
+	cancel()
+
+	if scanner.Scan() {
+		t.Fatalf("expected the stream to end after cancel, got %q", scanner.Text())
+	}
+	if scanner.Err() == nil {
+		t.Error("expected a read error after cancel, got nil")
+	}
+}
+
+func TestChat(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Stream {
+			t.Error("expected a non-streaming request")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"model":"testModel","message":{"role":"assistant","content":"Full answer"},"done":true}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	resp, err := client.Chat(context.Background(), ChatRequest{
+		Model:    "testModel",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resp.Message.Content != "Full answer" {
+		t.Errorf("expected content 'Full answer', got '%s'", resp.Message.Content)
+	}
+}
+
+func TestChatResponseMetrics(t *testing.T) {
+	final := `{"model":"testModel","message":{"role":"assistant","content":""},"done":true,` +
+		`"total_duration":2500000000,"load_duration":500000000,"prompt_eval_count":12,` +
+		`"prompt_eval_duration":300000000,"eval_count":40,"eval_duration":1600000000}`
+
+	var chunk ChatResponse
+	if err := json.Unmarshal([]byte(final), &chunk); err != nil {
+		t.Fatalf("failed to decode chunk: %v", err)
+	}
+
+	want := Metrics{
+		TotalDuration:      2500000000,
+		LoadDuration:       500000000,
+		PromptEvalCount:    12,
+		PromptEvalDuration: 300000000,
+		EvalCount:          40,
+		EvalDuration:       1600000000,
+	}
+	if chunk.Metrics != want {
+		t.Errorf("expected metrics %+v, got %+v", want, chunk.Metrics)
+	}
+	if tps := chunk.TokensPerSecond(); tps != 25 {
+		t.Errorf("expected 25 tokens/s, got %v", tps)
+	}
+	if tps := (Metrics{EvalCount: 5}).TokensPerSecond(); tps != 0 {
+		t.Errorf("expected 0 tokens/s without a duration, got %v", tps)
+	}
+}
@@ -0,0 +1,176 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	pullPath    = "/api/pull"
+	deletePath  = "/api/delete"
+	showPath    = "/api/show"
+	runningPath = "/api/ps"
+)
+
+type modelRequest struct {
+	Model  string `json:"model"`
+	Stream *bool  `json:"stream,omitempty"`
+}
+
+// PullProgress is one line of the /api/pull stream. Total and Completed are
+// byte counts for the layer named by Digest and are zero for plain status
+// updates such as "pulling manifest".
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ShowModelResponse struct {
+	License      string                 `json:"license,omitempty"`
+	Modelfile    string                 `json:"modelfile,omitempty"`
+	Parameters   string                 `json:"parameters,omitempty"`
+	Template     string                 `json:"template,omitempty"`
+	System       string                 `json:"system,omitempty"`
+	Details      ModelDetails           `json:"details"`
+	ModelInfo    map[string]interface{} `json:"model_info,omitempty"`
+	Capabilities []string               `json:"capabilities,omitempty"`
+	ModifiedAt   *time.Time             `json:"modified_at,omitempty"`
+}
+
+type RunningModel struct {
+	Name      string       `json:"name"`
+	Model     string       `json:"model"`
+	Size      int64        `json:"size"`
+	SizeVRAM  int64        `json:"size_vram"`
+	Digest    string       `json:"digest"`
+	Details   ModelDetails `json:"details"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+type ListRunningResponse struct {
+	Models []RunningModel `json:"models"`
+}
+
+// statusError turns a non-200 Ollama response into an error, using the
+// {"error": "..."} body Ollama sends when there is one.
+func statusError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body.Error)
+	}
+	return fmt.Errorf("ollama returned status %d", resp.StatusCode)
+}
+
+func (c *Client) postJSON(ctx context.Context, method, path string, payload interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// PullModel downloads a model, calling onProgress for every progress line
+// Ollama streams. It returns once the pull has succeeded, failed, or ctx is
+// cancelled.
+func (c *Client) PullModel(ctx context.Context, model string, onProgress func(PullProgress)) error {
+	stream := true
+	resp, err := c.postJSON(ctx, http.MethodPost, pullPath, modelRequest{Model: model, Stream: &stream})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var progress PullProgress
+		if err := json.Unmarshal(scanner.Bytes(), &progress); err != nil {
+			return fmt.Errorf("failed to decode progress: %w", err)
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("pull failed: %s", progress.Error)
+		}
+		onProgress(progress)
+		if progress.Status == "success" {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read progress: %w", err)
+	}
+	return fmt.Errorf("pull ended without success")
+}
+
+func (c *Client) DeleteModel(model string) error {
+	resp, err := c.postJSON(context.Background(), http.MethodDelete, deletePath, modelRequest{Model: model})
+	if err != nil {
+		return fmt.Errorf("failed to delete model: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ShowModel returns a model's template, parameters, license and capabilities.
+func (c *Client) ShowModel(model string) (*ShowModelResponse, error) {
+	resp, err := c.postJSON(context.Background(), http.MethodPost, showPath, modelRequest{Model: model})
+	if err != nil {
+		return nil, fmt.Errorf("failed to show model: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response ShowModelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &response, nil
+}
+
+// ListRunningModels returns the models currently loaded into memory.
+func (c *Client) ListRunningModels(ctx context.Context) ([]RunningModel, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+runningPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get running models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var response ListRunningResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return response.Models, nil
+}
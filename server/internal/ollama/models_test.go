@@ -0,0 +1,130 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPullModelReportsProgress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != pullPath {
+			t.Fatalf("expected path %q, got %q", pullPath, r.URL.Path)
+		}
+
+		var req modelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Model != "tiny:1b" {
+			t.Errorf("expected model 'tiny:1b', got '%s'", req.Model)
+		}
+
+		fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+		fmt.Fprintln(w, `{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":40}`)
+		fmt.Fprintln(w, `{"status":"success"}`)
+	}))
+
+	defer ts.Close()
+
+	var updates []PullProgress
+	client := NewClient(ts.URL)
+	err := client.PullModel(context.Background(), "tiny:1b", func(p PullProgress) {
+		updates = append(updates, p)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("expected 3 progress updates, got %d", len(updates))
+	}
+	if updates[1].Completed != 40 || updates[1].Total != 100 {
+		t.Errorf("unexpected progress: %+v", updates[1])
+	}
+}
+
+func TestPullModelStreamError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+		fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	err := client.PullModel(context.Background(), "missing", func(PullProgress) {})
+	if err == nil || !strings.Contains(err.Error(), "file does not exist") {
+		t.Errorf("expected pull error, got %v", err)
+	}
+}
+
+func TestDeleteModel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != deletePath {
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, `{"error":"model 'gone' not found"}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	err := client.DeleteModel("gone")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestShowModel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != showPath {
+			t.Fatalf("expected path %q, got %q", showPath, r.URL.Path)
+		}
+		fmt.Fprintln(w, `{
+			"license": "MIT",
+			"parameters": "temperature 0.7",
+			"template": "{{ .Prompt }}",
+			"details": {"parameter_size": "8B", "family": "llama"},
+			"capabilities": ["completion", "vision"]
+		}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	info, err := client.ShowModel("llava")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if info.License != "MIT" || info.Template != "{{ .Prompt }}" || info.Details.Family != "llama" {
+		t.Errorf("unexpected model info: %+v", info)
+	}
+	if len(info.Capabilities) != 2 || info.Capabilities[1] != "vision" {
+		t.Errorf("unexpected capabilities: %v", info.Capabilities)
+	}
+}
+
+func TestListRunningModels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != runningPath {
+			t.Fatalf("expected path %q, got %q", runningPath, r.URL.Path)
+		}
+		fmt.Fprintln(w, `{"models":[{"name":"llama3:8b","model":"llama3:8b","size":5000,"size_vram":4000}]}`)
+	}))
+
+	defer ts.Close()
+
+	client := NewClient(ts.URL)
+	models, err := client.ListRunningModels(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(models) != 1 || models[0].Name != "llama3:8b" || models[0].SizeVRAM != 4000 {
+		t.Errorf("unexpected running models: %+v", models)
+	}
+}
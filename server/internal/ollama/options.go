@@ -0,0 +1,89 @@
+package ollama
+
+import "fmt"
+
+// Options mirrors the subset of Ollama's model parameters that can be tuned
+// per conversation. Nil fields are omitted so the model's own defaults apply.
+type Options struct {
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	NumCtx        *int     `json:"num_ctx,omitempty"`
+	NumPredict    *int     `json:"num_predict,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+}
+
+// Merge returns a copy of o with every field set in override taking
+// precedence. Either side may be nil.
+func (o *Options) Merge(override *Options) *Options {
+	if o == nil && override == nil {
+		return nil
+	}
+
+	merged := Options{}
+	if o != nil {
+		merged = *o
+	}
+	if override == nil {
+		return &merged
+	}
+
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopK != nil {
+		merged.TopK = override.TopK
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.NumCtx != nil {
+		merged.NumCtx = override.NumCtx
+	}
+	if override.NumPredict != nil {
+		merged.NumPredict = override.NumPredict
+	}
+	if override.RepeatPenalty != nil {
+		merged.RepeatPenalty = override.RepeatPenalty
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	if override.Stop != nil {
+		merged.Stop = override.Stop
+	}
+	return &merged
+}
+
+// Validate rejects values Ollama would either refuse or silently misbehave on.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Temperature != nil && *o.Temperature < 0 {
+		return fmt.Errorf("temperature must not be negative")
+	}
+	if o.TopK != nil && *o.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative")
+	}
+	if o.TopP != nil && (*o.TopP < 0 || *o.TopP > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1")
+	}
+	if o.NumCtx != nil && *o.NumCtx <= 0 {
+		return fmt.Errorf("num_ctx must be positive")
+	}
+	if o.NumPredict != nil && *o.NumPredict < -2 {
+		return fmt.Errorf("num_predict must be -1, -2 or a non-negative count")
+	}
+	if o.RepeatPenalty != nil && *o.RepeatPenalty < 0 {
+		return fmt.Errorf("repeat_penalty must not be negative")
+	}
+	for _, stop := range o.Stop {
+		if stop == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package ollama
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOptionsMerge(t *testing.T) {
+	temp, seed, overrideSeed := 0.7, 1, 42
+	base := &Options{Temperature: &temp, Seed: &seed, Stop: []string{"###"}}
+
+	merged := base.Merge(&Options{Seed: &overrideSeed})
+
+	if merged.Temperature == nil || *merged.Temperature != 0.7 {
+		t.Errorf("expected temperature to be kept, got %v", merged.Temperature)
+	}
+	if merged.Seed == nil || *merged.Seed != 42 {
+		t.Errorf("expected seed override 42, got %v", merged.Seed)
+	}
+	if len(merged.Stop) != 1 || merged.Stop[0] != "###" {
+		t.Errorf("expected stop sequences to be kept, got %v", merged.Stop)
+	}
+	if *base.Seed != 1 {
+		t.Errorf("expected base options to be left untouched, got seed %d", *base.Seed)
+	}
+
+	var nilOptions *Options
+	if nilOptions.Merge(nil) != nil {
+		t.Error("expected merging two nil options to be nil")
+	}
+}
+
+func TestOptionsOmitUnset(t *testing.T) {
+	numCtx := 8192
+	data, err := json.Marshal(&Options{NumCtx: &numCtx})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(data) != `{"num_ctx":8192}` {
+		t.Errorf("unexpected encoding: %s", data)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	topP := 1.5
+	if err := (&Options{TopP: &topP}).Validate(); err == nil {
+		t.Error("expected an error for top_p above 1, got nil")
+	}
+	if err := (&Options{Stop: []string{""}}).Validate(); err == nil {
+		t.Error("expected an error for an empty stop sequence, got nil")
+	}
+	if err := (*Options)(nil).Validate(); err != nil {
+		t.Errorf("expected nil options to be valid, got %v", err)
+	}
+}
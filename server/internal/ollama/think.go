@@ -0,0 +1,87 @@
+package ollama
+
+import "strings"
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// Segment is a run of streamed text that is either reasoning or answer.
+type Segment struct {
+	Thinking bool
+	Text     string
+}
+
+// ThinkParser splits a streamed reply into reasoning, inside <think> tags,
+// and the answer around it. Tags may be split across chunks and may share a
+// chunk with text on either side; a chunk ending in what could be the start
+// of a tag is held back until the next one settles it.
+type ThinkParser struct {
+	thinking bool
+	pending  string
+}
+
+// Thinking reports whether the parser is inside a <think> block.
+func (p *ThinkParser) Thinking() bool {
+	return p.thinking
+}
+
+// Feed parses the next chunk and returns the text that is now certain, in
+// order. The tags themselves are dropped.
+func (p *ThinkParser) Feed(chunk string) []Segment {
+	var segments []Segment
+	emit := func(text string) {
+		if text == "" {
+			return
+		}
+		if n := len(segments); n > 0 && segments[n-1].Thinking == p.thinking {
+			segments[n-1].Text += text
+			return
+		}
+		segments = append(segments, Segment{Thinking: p.thinking, Text: text})
+	}
+
+	text := p.pending + chunk
+	p.pending = ""
+	for text != "" {
+		tag := thinkOpenTag
+		if p.thinking {
+			tag = thinkCloseTag
+		}
+
+		if i := strings.Index(text, tag); i >= 0 {
+			emit(text[:i])
+			p.thinking = !p.thinking
+			text = text[i+len(tag):]
+			continue
+		}
+
+		keep := partialTagLength(text, tag)
+		emit(text[:len(text)-keep])
+		p.pending = text[len(text)-keep:]
+		break
+	}
+	return segments
+}
+
+// Flush returns text held back as a possible tag once the stream has ended.
+func (p *ThinkParser) Flush() []Segment {
+	if p.pending == "" {
+		return nil
+	}
+	text := p.pending
+	p.pending = ""
+	return []Segment{{Thinking: p.thinking, Text: text}}
+}
+
+// partialTagLength returns the length of the longest suffix of text that is
+// a proper prefix of tag.
+func partialTagLength(text, tag string) int {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
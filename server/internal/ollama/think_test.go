@@ -0,0 +1,99 @@
+package ollama
+
+import (
+	"reflect"
+	"testing"
+)
+
+// feedAll runs chunks through a parser and merges adjacent segments, so
+// tests don't depend on where chunk boundaries fall.
+func feedAll(chunks ...string) []Segment {
+	var parser ThinkParser
+	var segments []Segment
+	add := func(more []Segment) {
+		for _, segment := range more {
+			if n := len(segments); n > 0 && segments[n-1].Thinking == segment.Thinking {
+				segments[n-1].Text += segment.Text
+				continue
+			}
+			segments = append(segments, segment)
+		}
+	}
+	for _, chunk := range chunks {
+		add(parser.Feed(chunk))
+	}
+	add(parser.Flush())
+	return segments
+}
+
+func TestThinkParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []Segment
+	}{
+		{
+			name:   "no tags",
+			chunks: []string{"Hello", " world"},
+			want:   []Segment{{Text: "Hello world"}},
+		},
+		{
+			name:   "tags in their own chunks",
+			chunks: []string{"<think>", "hmm", "</think>", "Hello"},
+			want:   []Segment{{Thinking: true, Text: "hmm"}, {Text: "Hello"}},
+		},
+		{
+			name:   "text on both sides of tags",
+			chunks: []string{"<think>let me see", " more</think>Answer: ", "42"},
+			want:   []Segment{{Thinking: true, Text: "let me see more"}, {Text: "Answer: 42"}},
+		},
+		{
+			name:   "everything in one chunk",
+			chunks: []string{"before<think>inside</think>after"},
+			want:   []Segment{{Text: "before"}, {Thinking: true, Text: "inside"}, {Text: "after"}},
+		},
+		{
+			name:   "tags split across chunks",
+			chunks: []string{"<th", "ink>ra", "w</th", "in", "k>do", "ne"},
+			want:   []Segment{{Thinking: true, Text: "raw"}, {Text: "done"}},
+		},
+		{
+			name:   "partial tag that turns out to be text",
+			chunks: []string{"a <th", "ing> b <", "/p>"},
+			want:   []Segment{{Text: "a <thing> b </p>"}},
+		},
+		{
+			name:   "unclosed block is flushed as thinking",
+			chunks: []string{"<think>still going</thi"},
+			want:   []Segment{{Thinking: true, Text: "still going</thi"}},
+		},
+		{
+			name:   "stream ending in a partial open tag",
+			chunks: []string{"answer <"},
+			want:   []Segment{{Text: "answer <"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := feedAll(tt.chunks...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestThinkParserHoldsBackPartialTag(t *testing.T) {
+	var parser ThinkParser
+
+	if got := parser.Feed("Hi <thi"); !reflect.DeepEqual(got, []Segment{{Text: "Hi "}}) {
+		t.Errorf("expected only the text before the partial tag, got %+v", got)
+	}
+	if got := parser.Feed("nk>"); got != nil {
+		t.Errorf("expected nothing for the rest of the tag, got %+v", got)
+	}
+	if !parser.Thinking() {
+		t.Error("expected parser to be inside the thinking block")
+	}
+}
@@ -0,0 +1,349 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"ollama-tiny-chat/server/internal/auth"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+	"ollama-tiny-chat/server/internal/ws"
+)
+
+const (
+	// ConversationHeader names the conversation to save an exchange to, as
+	// an alternative to the conversation_id field. Responses carry it back.
+	ConversationHeader = "X-Conversation-ID"
+
+	// NewConversation asks for the exchange to be saved as a new
+	// conversation.
+	NewConversation = "new"
+
+	maxRequestSize = 64 << 20 // room for a few base64 images
+)
+
+func RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/chat/completions", auth.Scope(database.ScopeChat)(ChatCompletions)).Methods("POST")
+	r.HandleFunc("/models", ListModels).Methods("GET")
+}
+
+// reply is what Ollama answered, whole or as far as it got.
+type reply struct {
+	content     string
+	rawContent  string
+	thinking    string
+	toolCalls   []ollama.ToolCall
+	metrics     ollama.Metrics
+	doneReason  string
+	interrupted bool
+}
+
+// ChatCompletions answers a chat completion request with the named Ollama
+// model, streamed as server-sent events when the request asks for it. With
+// a conversation ID the exchange is also saved, so it shows up in the app.
+func ChatCompletions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var req ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	chatReq, err := req.chatRequest()
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if header := r.Header.Get(ConversationHeader); header != "" {
+		req.ConversationID = header
+	}
+	ctx := r.Context()
+	var rec *recorder
+	if req.ConversationID != "" {
+		rec, err = newRecorder(auth.UserID(r.Context()), req.ConversationID, chatReq)
+		if errors.Is(err, database.ErrNotFound) {
+			sendError(w, http.StatusNotFound, "Conversation not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to prepare conversation for API chat: %v", err)
+			sendError(w, http.StatusInternalServerError, "Failed to save conversation")
+			return
+		}
+
+		// The conversation may be open in the app, where a reply can't be
+		// written into it at the same time.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		release, ok := ws.Reserve(rec.convoID, rec.userID, cancel)
+		if !ok {
+			sendError(w, http.StatusConflict, "A response is already being generated for this conversation")
+			return
+		}
+		defer release()
+		w.Header().Set(ConversationHeader, rec.convoID)
+	}
+
+	completion := ChatCompletion{
+		ID:      "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	if rec != nil {
+		completion.ConversationID = rec.convoID
+	}
+
+	var result reply
+	var ok bool
+	if req.Stream {
+		includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		result, ok = streamCompletion(ctx, w, chatReq, completion, includeUsage)
+	} else {
+		result, ok = completeChat(ctx, w, chatReq, completion)
+	}
+
+	if rec != nil {
+		rec.save(result, ok)
+	}
+}
+
+// completeChat sends the whole reply at once.
+func completeChat(ctx context.Context, w http.ResponseWriter, chatReq ollama.ChatRequest, completion ChatCompletion) (reply, bool) {
+	resp, err := provider.Chat(ctx, chatReq)
+	if err != nil {
+		sendUpstreamError(ctx, w, err)
+		return reply{}, false
+	}
+
+	var parser ollama.ThinkParser
+	result := reply{
+		rawContent: resp.Message.Content,
+		thinking:   resp.Message.Thinking,
+		toolCalls:  resp.Message.ToolCalls,
+		metrics:    resp.Metrics,
+		doneReason: resp.DoneReason,
+	}
+	for _, segment := range append(parser.Feed(resp.Message.Content), parser.Flush()...) {
+		if segment.Thinking {
+			result.thinking += segment.Text
+		} else {
+			result.content += segment.Text
+		}
+	}
+
+	message := ReplyMessage{
+		Role:             database.RoleAssistant,
+		ReasoningContent: result.thinking,
+	}
+	if result.content != "" || len(result.toolCalls) == 0 {
+		message.Content = &result.content
+	}
+	for i, call := range result.toolCalls {
+		message.ToolCalls = append(message.ToolCalls, toolCall(call, i))
+	}
+	reason := finishReason(result)
+	completion.Choices = []Choice{{Message: &message, FinishReason: &reason}}
+	completion.Usage = newUsage(result.metrics)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(completion)
+	return result, true
+}
+
+// streamCompletion forwards the reply as chunks while the model writes it.
+// The stream starts with the first chunk, so a request the provider refuses
+// still gets an error status; a failure after that ends the stream with an
+// error event. A client that disconnects cancels the request, and what
+// arrived until then is returned as interrupted.
+func streamCompletion(ctx context.Context, w http.ResponseWriter, chatReq ollama.ChatRequest, completion ChatCompletion, includeUsage bool) (reply, bool) {
+	flusher, _ := w.(http.Flusher)
+
+	completion.Object = "chat.completion.chunk"
+	send := func(choices []Choice, usage *Usage) {
+		completion.Choices = choices
+		completion.Usage = usage
+		data, _ := json.Marshal(completion)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	sendDelta := func(delta Delta) {
+		send([]Choice{{Delta: &delta}}, nil)
+	}
+
+	var result reply
+	var parser ollama.ThinkParser
+	var content, rawContent, thinking strings.Builder
+	write := func(segments []ollama.Segment) {
+		for _, segment := range segments {
+			if segment.Thinking {
+				thinking.WriteString(segment.Text)
+				sendDelta(Delta{ReasoningContent: segment.Text})
+			} else {
+				content.WriteString(segment.Text)
+				sendDelta(Delta{Content: segment.Text})
+			}
+		}
+	}
+
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		sendDelta(Delta{Role: database.RoleAssistant})
+	}
+
+	done := false
+	var streamErr error
+	err := provider.StreamChat(ctx, chatReq, func(chunk ollama.ChatResponse) {
+		if chunk.Error != "" {
+			log.Printf("Model stream error: %s", chunk.Error)
+			streamErr = errors.New(chunk.Error)
+			return
+		}
+		start()
+
+		if chunk.Message.Thinking != "" {
+			write([]ollama.Segment{{Thinking: true, Text: chunk.Message.Thinking}})
+		}
+		if chunk.Message.Content != "" {
+			rawContent.WriteString(chunk.Message.Content)
+			write(parser.Feed(chunk.Message.Content))
+		}
+		for _, call := range chunk.Message.ToolCalls {
+			index := len(result.toolCalls)
+			result.toolCalls = append(result.toolCalls, call)
+			delta := toolCall(call, index)
+			delta.Index = &index
+			sendDelta(Delta{ToolCalls: []ToolCall{delta}})
+		}
+
+		if chunk.Done {
+			result.metrics = chunk.Metrics
+			result.doneReason = chunk.DoneReason
+			done = true
+		}
+	})
+	if err == nil {
+		err = streamErr
+	}
+	if err != nil && !started {
+		sendUpstreamError(ctx, w, err)
+		return reply{}, false
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("API chat stream failed: %v", err)
+		data, _ := json.Marshal(errorResponse{Error: apiError{Message: err.Error(), Type: "server_error"}})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return reply{}, false
+	}
+	start()
+	write(parser.Flush())
+
+	result.content = content.String()
+	result.rawContent = rawContent.String()
+	result.thinking = thinking.String()
+	result.interrupted = !done
+	if ctx.Err() != nil {
+		log.Printf("API chat stream cancelled by the client")
+		return result, true
+	}
+
+	reason := finishReason(result)
+	send([]Choice{{Delta: &Delta{}, FinishReason: &reason}}, nil)
+	if includeUsage {
+		send([]Choice{}, newUsage(result.metrics))
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return result, true
+}
+
+func finishReason(result reply) string {
+	switch {
+	case len(result.toolCalls) > 0:
+		return "tool_calls"
+	case result.doneReason == "length":
+		return "length"
+	default:
+		return "stop"
+	}
+}
+
+// toolCall gives an Ollama tool call the ID OpenAI clients answer it by.
+func toolCall(call ollama.ToolCall, index int) ToolCall {
+	return ToolCall{
+		ID:   fmt.Sprintf("call_%d_%s", index, strings.ReplaceAll(uuid.New().String(), "-", "")[:12]),
+		Type: "function",
+		Function: ToolCallFunction{
+			Name:      call.Function.Name,
+			Arguments: string(call.Function.Arguments),
+		},
+	}
+}
+
+// ListModels lists the models of every provider.
+func ListModels(w http.ResponseWriter, r *http.Request) {
+	models, err := provider.ListModels(r.Context())
+	if err != nil {
+		log.Printf("Failed to list models: %v", err)
+		sendError(w, http.StatusBadGateway, "Failed to fetch models")
+		return
+	}
+
+	list := ModelList{Object: "list", Data: []Model{}}
+	for _, model := range models {
+		entry := Model{ID: model.Name, Object: "model", OwnedBy: model.Provider}
+		if model.ModifiedAt != nil {
+			entry.Created = model.ModifiedAt.Unix()
+		}
+		list.Data = append(list.Data, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// sendUpstreamError reports a request Ollama refused or that never reached
+// it. There is no one to tell when the client has gone away.
+func sendUpstreamError(ctx context.Context, w http.ResponseWriter, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	log.Printf("Ollama request failed: %v", err)
+	sendError(w, http.StatusBadGateway, err.Error())
+}
+
+// sendError sends an error in OpenAI's format, which its clients show.
+func sendError(w http.ResponseWriter, statusCode int, message string) {
+	errorType := "invalid_request_error"
+	if statusCode >= http.StatusInternalServerError {
+		errorType = "server_error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiError{Message: message, Type: errorType}})
+}
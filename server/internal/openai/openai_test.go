@@ -0,0 +1,317 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/ws"
+)
+
+// A 1x1 transparent PNG.
+const pixel = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func TestChatRequest(t *testing.T) {
+	body := `{
+		"model": "llama3",
+		"messages": [
+			{"role": "developer", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,` + pixel + `"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{\"q\":\"pixel\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "a pixel"}
+		],
+		"stop": "\n\n",
+		"max_tokens": 64,
+		"reasoning_effort": "none"
+	}`
+	var req ChatCompletionRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("failed to decode request: %v", err)
+	}
+	chatReq, err := req.chatRequest()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	messages := chatReq.Messages
+	if len(messages) != 4 || messages[0].Role != "system" {
+		t.Fatalf("expected 4 messages starting with the system prompt, got %+v", messages)
+	}
+	if messages[1].Content != "What is this?" || len(messages[1].Images) != 1 || messages[1].Images[0] != pixel {
+		t.Errorf("expected text and image parts to be split, got %+v", messages[1])
+	}
+	if calls := messages[2].ToolCalls; len(calls) != 1 || string(calls[0].Function.Arguments) != `{"q":"pixel"}` {
+		t.Errorf("expected the tool call's arguments as JSON, got %+v", calls)
+	}
+	if messages[3].ToolName != "lookup" {
+		t.Errorf("expected the tool result to name its tool, got %q", messages[3].ToolName)
+	}
+	if chatReq.Options == nil || *chatReq.Options.NumPredict != 64 || len(chatReq.Options.Stop) != 1 {
+		t.Errorf("expected max_tokens and stop as options, got %+v", chatReq.Options)
+	}
+	if chatReq.Think == nil || *chatReq.Think {
+		t.Errorf("expected reasoning to be turned off")
+	}
+
+	for _, invalid := range []string{
+		`{"messages": [{"role": "user", "content": "hi"}]}`,
+		`{"model": "llama3", "messages": [{"role": "robot", "content": "hi"}]}`,
+		`{"model": "llama3", "messages": [{"role": "user", "content": "hi"}], "temperature": -1}`,
+	} {
+		var req ChatCompletionRequest
+		if err := json.Unmarshal([]byte(invalid), &req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if _, err := req.chatRequest(); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+
+	var remote ChatCompletionRequest
+	err = json.Unmarshal([]byte(`{"model": "llama3", "messages": [{"role": "user", "content": [
+		{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}]}]}`), &remote)
+	if err == nil {
+		t.Errorf("expected remote images to be refused")
+	}
+}
+
+// fakeOllama answers every chat with a reasoning block and "Hello there",
+// streamed a word at a time when asked to. A prompt of "fail" gets an error
+// chunk instead, and "fail later" gets it after the first word.
+func fakeOllama(t *testing.T, requests *[]ollama.ChatRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			fmt.Fprint(w, `{"models": [{"name": "llama3:latest", "model": "llama3:latest"}]}`)
+			return
+		}
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var req ollama.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		*requests = append(*requests, req)
+
+		switch req.Messages[len(req.Messages)-1].Content {
+		case "fail later":
+			fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": "Hello"}, "done": false}`+"\n", req.Model)
+			fallthrough
+		case "fail":
+			fmt.Fprint(w, `{"error": "model runner has unexpectedly stopped"}`+"\n")
+			return
+		}
+
+		final := `"done": true, "done_reason": "stop", "prompt_eval_count": 12, "eval_count": 4, "total_duration": 1000, "eval_duration": 500`
+		if !req.Stream {
+			fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": "<think>hmm</think>Hello there"}, %s}`, req.Model, final)
+			return
+		}
+		for _, chunk := range []string{"<think>hmm</think>", "Hello", " there"} {
+			fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": %q}, "done": false}`+"\n", req.Model, chunk)
+		}
+		fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": ""}, %s}`+"\n", req.Model, final)
+	}))
+}
+
+func TestChatCompletions(t *testing.T) {
+	var requests []ollama.ChatRequest
+	ts := fakeOllama(t, &requests)
+	defer ts.Close()
+
+	cfg := config.Get()
+	cfg.OllamaURL = ts.URL
+	cfg.DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	post := func(body, conversation string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		if conversation != "" {
+			req.Header.Set(ConversationHeader, conversation)
+		}
+		rec := httptest.NewRecorder()
+		ChatCompletions(rec, req)
+		return rec
+	}
+
+	// Not saved without a conversation.
+	rec := post(`{"model": "llama3", "messages": [{"role": "user", "content": "Hi"}]}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var completion ChatCompletion
+	if err := json.Unmarshal(rec.Body.Bytes(), &completion); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	message := completion.Choices[0].Message
+	if *message.Content != "Hello there" || message.ReasoningContent != "hmm" {
+		t.Errorf("expected the reasoning to be split from the answer, got %+v", message)
+	}
+	if *completion.Choices[0].FinishReason != "stop" || completion.Usage.TotalTokens != 16 {
+		t.Errorf("expected finish reason and usage, got %+v", completion)
+	}
+	if convos, _ := database.ListConversations("", false); len(convos) != 0 {
+		t.Errorf("expected nothing to be saved, got %d conversations", len(convos))
+	}
+
+	// Streamed into a new conversation.
+	rec = post(`{"model": "llama3", "stream": true, "stream_options": {"include_usage": true},
+		"messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Hi"}]}`, NewConversation)
+	convoID := rec.Header().Get(ConversationHeader)
+	if rec.Code != http.StatusOK || convoID == "" {
+		t.Fatalf("expected 200 with a conversation ID, got %d: %s", rec.Code, rec.Body)
+	}
+	if !requests[len(requests)-1].Stream {
+		t.Errorf("expected the request to Ollama to stream")
+	}
+
+	var content, reasoning strings.Builder
+	var usage *Usage
+	var last string
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		last = data
+		if data == "[DONE]" {
+			break
+		}
+		var chunk ChatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("failed to decode chunk %s: %v", data, err)
+		}
+		if chunk.Object != "chat.completion.chunk" || chunk.ConversationID != convoID {
+			t.Errorf("unexpected chunk %s", data)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			reasoning.WriteString(choice.Delta.ReasoningContent)
+		}
+	}
+	if content.String() != "Hello there" || reasoning.String() != "hmm" {
+		t.Errorf("expected the streamed answer and reasoning, got %q and %q", content.String(), reasoning.String())
+	}
+	if usage == nil || usage.CompletionTokens != 4 || last != "[DONE]" {
+		t.Errorf("expected a usage chunk and [DONE], got %+v and %q", usage, last)
+	}
+
+	// The next turn only adds what is new.
+	rec = post(`{"model": "llama3", "messages": [
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": "Hi"},
+		{"role": "assistant", "content": "Hello there"},
+		{"role": "user", "content": "Again"}]}`, convoID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	convo, err := database.GetConversationByID(convoID)
+	if err != nil || convo == nil {
+		t.Fatalf("expected the conversation to be saved, got %v", err)
+	}
+	if convo.Title != "Hi" || convo.SystemPrompt != "Be brief." {
+		t.Errorf("expected title and system prompt from the request, got %q and %q", convo.Title, convo.SystemPrompt)
+	}
+	var roles []string
+	for _, msg := range convo.Messages {
+		roles = append(roles, msg.Role+":"+msg.Content)
+	}
+	want := "user:Hi assistant:Hello there user:Again assistant:Hello there"
+	if got := strings.Join(roles, " "); got != want {
+		t.Errorf("expected messages %q, got %q", want, got)
+	}
+	reply := convo.Messages[len(convo.Messages)-1]
+	if reply.Thinking == nil || *reply.Thinking != "hmm" || reply.Model != "llama3" || reply.Stats == nil {
+		t.Errorf("expected the reply with its reasoning, model and stats, got %+v", reply)
+	}
+
+	// A reply being written in the app keeps the API out of the conversation.
+	release, ok := ws.Reserve(convoID, "", func() {})
+	if !ok {
+		t.Fatalf("expected the conversation to be free")
+	}
+	if rec := post(`{"model": "llama3", "messages": [{"role": "user", "content": "Hi"}]}`, convoID); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 while a reply is being generated, got %d", rec.Code)
+	}
+	release()
+	if messages, _ := database.GetAllMessages(convoID); len(messages) != 4 {
+		t.Errorf("expected the refused request to save nothing, got %d messages", len(messages))
+	}
+	if rec := post(`{"model": "llama3", "messages": [{"role": "user", "content": "Hi"}]}`, convoID); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 once the reply is done, got %d", rec.Code)
+	}
+	release, ok = ws.Reserve(convoID, "", func() {})
+	if !ok {
+		t.Errorf("expected the API request to release the conversation")
+	} else {
+		release()
+	}
+
+	if rec := post(`{"model": "llama3", "messages": [{"role": "user", "content": "Hi"}]}`, "missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown conversation, got %d", rec.Code)
+	}
+	if rec := post(`{"model": "llama3", "messages": []}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without messages, got %d", rec.Code)
+	} else if !strings.Contains(rec.Body.String(), `"invalid_request_error"`) {
+		t.Errorf("expected an OpenAI error, got %s", rec.Body)
+	}
+}
+
+func TestChatCompletionsStreamError(t *testing.T) {
+	var requests []ollama.ChatRequest
+	ts := fakeOllama(t, &requests)
+	defer ts.Close()
+
+	cfg := config.Get()
+	cfg.OllamaURL = ts.URL
+	cfg.DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	post := func(prompt string) *httptest.ResponseRecorder {
+		body := `{"model": "llama3", "stream": true, "messages": [{"role": "user", "content": "` + prompt + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set(ConversationHeader, NewConversation)
+		rec := httptest.NewRecorder()
+		ChatCompletions(rec, req)
+		return rec
+	}
+
+	rec := post("fail")
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "unexpectedly stopped") {
+		t.Errorf("expected 502 with the model's error, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = post("fail later")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the stream to have started, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `"error":{"message":"model runner has unexpectedly stopped"`) || strings.Contains(body, "[DONE]") {
+		t.Errorf("expected the stream to end with an error event, got %s", body)
+	}
+
+	if convos, _ := database.ListConversations("", false); len(convos) != 0 {
+		t.Errorf("expected failed replies not to be saved, got %d conversations", len(convos))
+	}
+}
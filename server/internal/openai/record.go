@@ -0,0 +1,178 @@
+package openai
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// untitled names conversations started without a user message.
+const untitled = "API chat"
+
+// recorder saves an exchange to a conversation once the reply is complete.
+// Clients send the whole history every time, so only what follows the last
+// assistant message of the request is new to a conversation saved before.
+type recorder struct {
+	convoID string
+	created bool // started for this request, and dropped if it fails
+	userID  string
+	model   string
+	pending []ollama.Message
+}
+
+// newRecorder starts a conversation for ref "new" or checks the caller owns
+// the conversation ref names, returning database.ErrNotFound otherwise.
+func newRecorder(userID, ref string, chatReq ollama.ChatRequest) (*recorder, error) {
+	rec := &recorder{userID: userID, model: chatReq.Model}
+
+	if ref == NewConversation {
+		var system []string
+		title := ""
+		for _, msg := range chatReq.Messages {
+			if msg.Role == database.RoleSystem {
+				system = append(system, msg.Content)
+				continue
+			}
+			if title == "" && msg.Role == database.RoleUser {
+				title = database.TitleFromMessage(msg.Content)
+			}
+			rec.pending = append(rec.pending, msg)
+		}
+		if title == "" {
+			title = untitled
+		}
+
+		convoID, err := database.CreateConversation(userID, title, chatReq.Model, strings.Join(system, "\n\n"), nil)
+		if err != nil {
+			return nil, err
+		}
+		rec.convoID = convoID
+		rec.created = true
+		return rec, nil
+	}
+
+	convo, err := database.GetConversationMetadata(ref)
+	if err != nil {
+		return nil, err
+	}
+	if convo == nil || convo.UserID != userID {
+		return nil, database.ErrNotFound
+	}
+	rec.convoID = convo.ID
+
+	start := 0
+	for i, msg := range chatReq.Messages {
+		if msg.Role == database.RoleAssistant {
+			start = i + 1
+		}
+	}
+	for _, msg := range chatReq.Messages[start:] {
+		if msg.Role != database.RoleSystem {
+			rec.pending = append(rec.pending, msg)
+		}
+	}
+	return rec, nil
+}
+
+// save appends the new messages of the request and the reply to the active
+// branch. A failed or empty reply saves nothing.
+func (rec *recorder) save(result reply, ok bool) {
+	if !ok || (result.rawContent == "" && result.thinking == "" && len(result.toolCalls) == 0) {
+		if rec.created {
+			if err := database.DeleteConversation(rec.convoID); err != nil {
+				log.Printf("Failed to delete unused conversation %s: %v", rec.convoID, err)
+			}
+		}
+		return
+	}
+
+	if err := rec.saveExchange(result); err != nil {
+		log.Printf("Failed to save API chat to conversation %s: %v", rec.convoID, err)
+		return
+	}
+	log.Printf("Saved API chat to conversation %s", rec.convoID)
+}
+
+func (rec *recorder) saveExchange(result reply) error {
+	convo, err := database.GetConversationMetadata(rec.convoID)
+	if err != nil {
+		return err
+	}
+	if convo == nil {
+		return database.ErrNotFound
+	}
+	parentID := convo.ActiveLeafID
+
+	for _, msg := range rec.pending {
+		if msg.Role == database.RoleUser {
+			images, err := rec.saveImages(msg.Images)
+			if err != nil {
+				return err
+			}
+			saved, err := database.AddMessage(rec.convoID, database.RoleUser, msg.Content, images)
+			if err != nil {
+				return err
+			}
+			parentID = &saved.ID
+			continue
+		}
+
+		message := database.Message{
+			ConversationID: rec.convoID,
+			ParentID:       parentID,
+			Role:           msg.Role,
+			Content:        msg.Content,
+			RawContent:     msg.Content,
+			ToolCalls:      msg.ToolCalls,
+			ToolName:       msg.ToolName,
+		}
+		if err := database.AddMessageWithThinking(&message); err != nil {
+			return err
+		}
+		parentID = &message.ID
+	}
+
+	return database.AddMessageWithThinking(&database.Message{
+		ConversationID: rec.convoID,
+		ParentID:       parentID,
+		Role:           database.RoleAssistant,
+		Content:        result.content,
+		RawContent:     result.rawContent,
+		Thinking:       pointerString(result.thinking),
+		ToolCalls:      result.toolCalls,
+		Interrupted:    result.interrupted,
+		Model:          rec.model,
+		Stats:          database.NewMessageStats(result.metrics),
+	})
+}
+
+// saveImages stores the base64 images of a message as attachments, which
+// the message then claims.
+func (rec *recorder) saveImages(images []string) ([]string, error) {
+	var ids []string
+	for i, image := range images {
+		data, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		attachment, err := database.CreateAttachment(rec.userID, fmt.Sprintf("image-%d", i+1),
+			http.DetectContentType(data), data)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, attachment.ID)
+	}
+	return ids, nil
+}
+
+func pointerString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,299 @@
+// Package openai serves the parts of OpenAI's API that scripts and editor
+// plugins use to chat, translating them to and from Ollama's.
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// Image formats Ollama's vision models accept.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+// ChatCompletionRequest is the subset of OpenAI's chat completion request
+// that maps onto Ollama. Unknown fields are ignored.
+type ChatCompletionRequest struct {
+	Model               string         `json:"model"`
+	Messages            []Message      `json:"messages"`
+	Stream              bool           `json:"stream"`
+	StreamOptions       *StreamOptions `json:"stream_options,omitempty"`
+	Temperature         *float64       `json:"temperature,omitempty"`
+	TopP                *float64       `json:"top_p,omitempty"`
+	MaxTokens           *int           `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int           `json:"max_completion_tokens,omitempty"`
+	Seed                *int           `json:"seed,omitempty"`
+	Stop                Stop           `json:"stop,omitempty"`
+	Tools               []ollama.Tool  `json:"tools,omitempty"`
+
+	// ReasoningEffort "none" turns reasoning off for models that support
+	// it, and any other value turns it on. Ollama has no finer control.
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+
+	// ConversationID saves the exchange as a conversation: "new" starts
+	// one, anything else names one of the caller's conversations. The
+	// X-Conversation-ID header does the same.
+	ConversationID string `json:"conversation_id,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// Stop accepts a single sequence or a list, like OpenAI.
+type Stop []string
+
+func (s *Stop) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*s = Stop{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("stop must be a string or a list of strings")
+	}
+	*s = many
+	return nil
+}
+
+type Message struct {
+	Role       string     `json:"role"`
+	Content    Content    `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"` // the call a "tool" message answers
+}
+
+// Content is the text and images of a request message, sent either as a
+// string or as a list of parts. Images must be data URLs; remote ones
+// aren't fetched.
+type Content struct {
+	Text   string
+	Images []string // base64-encoded
+}
+
+type contentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+func (c *Content) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if json.Unmarshal(data, &c.Text) == nil {
+		return nil
+	}
+
+	var parts []contentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or a list of parts")
+	}
+	var text []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			text = append(text, part.Text)
+		case "image_url":
+			image, err := decodeImageURL(part.ImageURL.URL)
+			if err != nil {
+				return err
+			}
+			c.Images = append(c.Images, image)
+		default:
+			return fmt.Errorf("unsupported content part %q", part.Type)
+		}
+	}
+	c.Text = strings.Join(text, "\n")
+	return nil
+}
+
+// decodeImageURL checks a data URL holds a supported image and returns its
+// base64 payload.
+func decodeImageURL(url string) (string, error) {
+	header, payload, ok := strings.Cut(url, ",")
+	if !ok || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return "", errors.New("images must be base64 data URLs")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errors.New("image data is not valid base64")
+	}
+	if !imageTypes[http.DetectContentType(data)] {
+		return "", errors.New("unsupported image type")
+	}
+	return payload, nil
+}
+
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"` // position in the list, only in stream deltas
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON encoded, unlike Ollama's
+}
+
+// chatRequest translates the request into Ollama's. Tool results are
+// matched to the call they answer by ID, since Ollama identifies them by
+// the tool's name instead.
+func (req *ChatCompletionRequest) chatRequest() (ollama.ChatRequest, error) {
+	chatReq := ollama.ChatRequest{
+		Model: req.Model,
+		Tools: req.Tools,
+	}
+	if req.Model == "" {
+		return chatReq, errors.New("model is required")
+	}
+	if len(req.Messages) == 0 {
+		return chatReq, errors.New("messages must not be empty")
+	}
+
+	toolNames := map[string]string{}
+	for _, msg := range req.Messages {
+		converted := ollama.Message{
+			Role:    msg.Role,
+			Content: msg.Content.Text,
+			Images:  msg.Content.Images,
+		}
+		switch msg.Role {
+		case "system", "developer":
+			converted.Role = "system"
+		case "user":
+		case "assistant":
+			for _, call := range msg.ToolCalls {
+				arguments := json.RawMessage(call.Function.Arguments)
+				if len(bytes.TrimSpace(arguments)) == 0 {
+					arguments = json.RawMessage("{}")
+				}
+				if !json.Valid(arguments) {
+					return chatReq, fmt.Errorf("arguments of tool call %s are not valid JSON", call.ID)
+				}
+				toolNames[call.ID] = call.Function.Name
+				converted.ToolCalls = append(converted.ToolCalls, ollama.ToolCall{
+					Function: ollama.ToolCallFunction{Name: call.Function.Name, Arguments: arguments},
+				})
+			}
+		case "tool":
+			converted.ToolName = toolNames[msg.ToolCallID]
+		default:
+			return chatReq, fmt.Errorf("unsupported role %q", msg.Role)
+		}
+		chatReq.Messages = append(chatReq.Messages, converted)
+	}
+
+	options := &ollama.Options{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		NumPredict:  req.MaxTokens,
+		Seed:        req.Seed,
+		Stop:        req.Stop,
+	}
+	if req.MaxCompletionTokens != nil {
+		options.NumPredict = req.MaxCompletionTokens
+	}
+	if err := options.Validate(); err != nil {
+		return chatReq, err
+	}
+	if options.Temperature != nil || options.TopP != nil || options.NumPredict != nil ||
+		options.Seed != nil || len(options.Stop) > 0 {
+		chatReq.Options = options
+	}
+
+	if req.ReasoningEffort != "" {
+		think := req.ReasoningEffort != "none"
+		chatReq.Think = &think
+	}
+	return chatReq, nil
+}
+
+// ChatCompletion is both a complete response and, with Object set to
+// "chat.completion.chunk", one event of a stream.
+type ChatCompletion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+
+	// ConversationID is the conversation the exchange was saved to, if any.
+	ConversationID string `json:"conversation_id,omitempty"`
+}
+
+type Choice struct {
+	Index        int           `json:"index"`
+	Message      *ReplyMessage `json:"message,omitempty"`
+	Delta        *Delta        `json:"delta,omitempty"`
+	FinishReason *string       `json:"finish_reason"`
+}
+
+type ReplyMessage struct {
+	Role             string     `json:"role"`
+	Content          *string    `json:"content"` // null when the reply only calls tools
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type Delta struct {
+	Role             string     `json:"role,omitempty"`
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newUsage(metrics ollama.Metrics) *Usage {
+	return &Usage{
+		PromptTokens:     metrics.PromptEvalCount,
+		CompletionTokens: metrics.EvalCount,
+		TotalTokens:      metrics.PromptEvalCount + metrics.EvalCount,
+	}
+}
+
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type ModelList struct {
+	Object string  `json:"object"`
+	Data   []Model `json:"data"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// Config is the providers configuration file:
+// {"providers": {"name": {"url": "...", "api_key": "..."}}}.
+type Config struct {
+	Providers map[string]ProviderConfig `json:"providers"`
+}
+
+// ProviderConfig describes an OpenAI-compatible server. APIKey may refer to
+// environment variables as $NAME or ${NAME}, to keep secrets out of the file.
+type ProviderConfig struct {
+	URL    string `json:"url"` // base URL including the version, e.g. http://localhost:1234/v1
+	APIKey string `json:"api_key,omitempty"`
+}
+
+var providerName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// LoadConfig reads and validates a providers configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read providers config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse providers config: %w", err)
+	}
+	for name, provider := range config.Providers {
+		if !providerName.MatchString(name) {
+			return nil, fmt.Errorf("invalid provider name %q: use letters, digits, '-' and '_'", name)
+		}
+		if name == OllamaName {
+			return nil, fmt.Errorf("provider name %q is reserved", OllamaName)
+		}
+		parsed, err := url.Parse(provider.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("provider %s needs an http or https url", name)
+		}
+	}
+	return &config, nil
+}
+
+// RegisterProviders adds the configured providers.
+func RegisterProviders(config *Config) error {
+	for name, provider := range config.Providers {
+		if err := Register(NewOpenAI(name, provider.URL, os.ExpandEnv(provider.APIKey))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+const (
+	// checkInterval is how long a host's health and model list are trusted
+	// before /api/tags is asked again.
+	checkInterval = 15 * time.Second
+
+	// checkTimeout bounds a health check and the /api/ps query made while
+	// choosing a host.
+	checkTimeout = 3 * time.Second
+)
+
+// Ollama is the pool of Ollama hosts set with -ollama-url. Each host is
+// health-checked through /api/tags, which also tells the models it holds. A
+// request goes to a healthy host holding its model, preferring one that has
+// it in memory, and moves on to the next when a host can't be reached. With
+// a single host this is the same as talking to it directly.
+type Ollama struct{}
+
+func (Ollama) Name() string {
+	return OllamaName
+}
+
+// host is one Ollama server of the pool.
+type host struct {
+	url    string
+	client *ollama.Client
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error // of the last check; nil while healthy
+	models  []ollama.ModelInfo
+}
+
+var (
+	poolMu   sync.Mutex
+	poolURLs string
+	pool     []*host
+)
+
+// hosts returns the configured hosts, keeping their state for as long as the
+// configuration stays the same.
+func hosts() []*host {
+	cfg := config.Get()
+
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if pool == nil || cfg.OllamaURL != poolURLs {
+		urls := cfg.OllamaURLs()
+		if len(urls) == 0 {
+			urls = []string{config.DefaultOllamaURL}
+		}
+		pool = nil
+		for _, u := range urls {
+			pool = append(pool, &host{url: u, client: ollama.NewClient(u)})
+		}
+		poolURLs = cfg.OllamaURL
+	}
+	return pool
+}
+
+// check asks the host for its models once the last answer is older than
+// checkInterval. It doesn't take the caller's context, so a request that
+// goes away can't mark a host as down.
+func (h *host) check() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.checked) < checkInterval {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	models, err := h.client.ListModels(ctx)
+	switch {
+	case err != nil && h.err == nil:
+		log.Printf("Ollama host %s is unhealthy: %v", h.url, err)
+	case err == nil && h.err != nil:
+		log.Printf("Ollama host %s is healthy again", h.url)
+	}
+	h.models, h.err, h.checked = models, err, time.Now()
+}
+
+// status returns the host's models, or why it is unhealthy.
+func (h *host) status() ([]ollama.ModelInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.models, h.err
+}
+
+// fail marks the host unhealthy until the next check.
+func (h *host) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	log.Printf("Ollama host %s is unhealthy: %v", h.url, err)
+	h.err, h.checked = err, time.Now()
+}
+
+// checkAll checks the hosts at once.
+func checkAll(list []*host) {
+	var wg sync.WaitGroup
+	for _, h := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.check()
+		}()
+	}
+	wg.Wait()
+}
+
+// RefreshOllama makes the next request check every host again, after models
+// were pulled or deleted.
+func RefreshOllama() {
+	for _, h := range hosts() {
+		h.mu.Lock()
+		h.checked = time.Time{}
+		h.mu.Unlock()
+	}
+}
+
+// modelKey is the name Ollama resolves model to: one without a tag is the
+// "latest" one.
+func modelKey(model string) string {
+	if !strings.Contains(model[strings.LastIndex(model, "/")+1:], ":") {
+		return model + ":latest"
+	}
+	return model
+}
+
+func hasModel(models []ollama.ModelInfo, model string) bool {
+	key := modelKey(model)
+	for _, info := range models {
+		if modelKey(info.Name) == key || modelKey(info.Model) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the healthy hosts holding model, in the configured
+// order. When none holds it, every healthy host is returned, so Ollama
+// reports the missing model as it would with a single host. When none is
+// healthy, every host is returned to try anyway.
+func candidates(model string) []*host {
+	list := hosts()
+	checkAll(list)
+
+	var healthy, holding []*host
+	for _, h := range list {
+		models, err := h.status()
+		if err != nil {
+			continue
+		}
+		healthy = append(healthy, h)
+		if hasModel(models, model) {
+			holding = append(holding, h)
+		}
+	}
+	switch {
+	case len(holding) > 0:
+		return holding
+	case len(healthy) > 0:
+		return healthy
+	default:
+		return list
+	}
+}
+
+// route orders the hosts a request for model goes to, moving those that have
+// the model in memory to the front.
+func route(ctx context.Context, model string) []*host {
+	list := candidates(model)
+	if len(list) < 2 {
+		return list
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	key := modelKey(model)
+	loaded := make([]bool, len(list))
+	var wg sync.WaitGroup
+	for i, h := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			running, err := h.client.ListRunningModels(ctx)
+			if err != nil {
+				return
+			}
+			for _, info := range running {
+				if modelKey(info.Name) == key || modelKey(info.Model) == key {
+					loaded[i] = true
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	ordered := make([]*host, 0, len(list))
+	for i, h := range list {
+		if loaded[i] {
+			ordered = append(ordered, h)
+		}
+	}
+	for i, h := range list {
+		if !loaded[i] {
+			ordered = append(ordered, h)
+		}
+	}
+	return ordered
+}
+
+// OllamaClient returns a client for the host a request for model would go
+// to. An empty model gives the first healthy host, where models are pulled.
+func OllamaClient(ctx context.Context, model string) *ollama.Client {
+	return route(ctx, model)[0].client
+}
+
+// OllamaClients returns a client for every healthy host holding model, or
+// for every healthy host when none holds it or model is empty.
+func OllamaClients(model string) []*ollama.Client {
+	var clients []*ollama.Client
+	for _, h := range candidates(model) {
+		clients = append(clients, h.client)
+	}
+	return clients
+}
+
+// OllamaHolders returns a client for every healthy host holding model, and
+// none when no healthy host lists it.
+func OllamaHolders(model string) []*ollama.Client {
+	list := hosts()
+	checkAll(list)
+
+	var clients []*ollama.Client
+	for _, h := range list {
+		if models, err := h.status(); err == nil && hasModel(models, model) {
+			clients = append(clients, h.client)
+		}
+	}
+	return clients
+}
+
+// ListModels merges the models of the healthy hosts, listing each once.
+func (Ollama) ListModels(ctx context.Context) ([]ollama.ModelInfo, error) {
+	list := hosts()
+	checkAll(list)
+
+	models := []ollama.ModelInfo{}
+	seen := map[string]bool{}
+	var errs []error
+	for _, h := range list {
+		hostModels, err := h.status()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.url, err))
+			continue
+		}
+		for _, info := range hostModels {
+			if key := modelKey(info.Name); !seen[key] {
+				seen[key] = true
+				models = append(models, info)
+			}
+		}
+	}
+	if len(errs) == len(list) {
+		return nil, errors.Join(errs...)
+	}
+	return models, nil
+}
+
+// StreamChat sends the request to the best host for its model, and to the
+// next one whenever a host can't be reached.
+func (Ollama) StreamChat(ctx context.Context, req ollama.ChatRequest, fn func(ollama.ChatResponse)) error {
+	var err error
+	for _, h := range route(ctx, req.Model) {
+		err = h.streamChat(ctx, req, fn)
+		if !unreachable(ctx, err) {
+			return err
+		}
+		h.fail(err)
+	}
+	return err
+}
+
+// unreachable reports whether err means the host couldn't be reached, rather
+// than Ollama refusing the request, so that another host may be tried.
+func unreachable(ctx context.Context, err error) bool {
+	var urlErr *url.Error
+	return err != nil && ctx.Err() == nil && errors.As(err, &urlErr)
+}
+
+func (h *host) streamChat(ctx context.Context, req ollama.ChatRequest, fn func(ollama.ChatResponse)) error {
+	resp, err := h.client.ChatStream(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		var chunk ollama.ChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			log.Printf("Error unmarshaling response chunk: %v", err)
+			continue
+		}
+		fn(chunk)
+		if chunk.Done || chunk.Error != "" {
+			return nil
+		}
+	}
+	// A cancelled request ends the body early too, which isn't a failure.
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+	return errors.New("Ollama ended the reply before it was complete")
+}
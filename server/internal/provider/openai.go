@@ -0,0 +1,383 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// maxEventSize bounds one event of a streamed reply: a server-sent event, or
+// a line from Ollama.
+const maxEventSize = 1 << 20
+
+// OpenAI is a server speaking OpenAI's chat completions API, such as
+// llama.cpp's server, vLLM or LM Studio. Ollama-only options like top_k and
+// num_ctx aren't part of that API and are left out.
+type OpenAI struct {
+	name       string
+	baseURL    string // up to and including the version, e.g. http://host:1234/v1
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewOpenAI(name, baseURL, apiKey string) *OpenAI {
+	return &OpenAI{
+		name:       name,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+	}
+}
+
+func (o *OpenAI) Name() string {
+	return o.name
+}
+
+type openAIModelList struct {
+	Data []struct {
+		ID      string `json:"id"`
+		Created int64  `json:"created"`
+	} `json:"data"`
+}
+
+func (o *OpenAI) ListModels(ctx context.Context) ([]ollama.ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := o.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var list openAIModelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	models := make([]ollama.ModelInfo, 0, len(list.Data))
+	for _, entry := range list.Data {
+		info := ollama.ModelInfo{Name: entry.ID, Model: entry.ID}
+		if entry.Created > 0 {
+			created := time.Unix(entry.Created, 0).UTC()
+			info.ModifiedAt = &created
+		}
+		models = append(models, info)
+	}
+	return models, nil
+}
+
+type openAIRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Stream        bool            `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	MaxTokens   *int          `json:"max_tokens,omitempty"`
+	Seed        *int          `json:"seed,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	Tools       []ollama.Tool `json:"tools,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    any              `json:"content"` // text, or a list of parts when there are images
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content          string           `json:"content"`
+			ReasoningContent string           `json:"reasoning_content"`
+			Reasoning        string           `json:"reasoning"`
+			ToolCalls        []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error json.RawMessage `json:"error"` // sent in place of a chunk when generation fails
+}
+
+// openAIRequestFrom translates a chat request. Ollama has no IDs for tool
+// calls, so calls are numbered and each tool result answers the oldest
+// unanswered call to its tool.
+func openAIRequestFrom(req ollama.ChatRequest) openAIRequest {
+	out := openAIRequest{
+		Model:  req.Model,
+		Stream: true,
+		Tools:  req.Tools,
+	}
+	out.StreamOptions.IncludeUsage = true
+	if options := req.Options; options != nil {
+		out.Temperature = options.Temperature
+		out.TopP = options.TopP
+		if options.NumPredict != nil && *options.NumPredict > 0 {
+			out.MaxTokens = options.NumPredict
+		}
+		out.Seed = options.Seed
+		out.Stop = options.Stop
+	}
+
+	var pending []openAIToolCall
+	for i, msg := range req.Messages {
+		converted := openAIMessage{Role: msg.Role, Content: msg.Content}
+		if len(msg.Images) > 0 {
+			parts := []openAIPart{{Type: "text", Text: msg.Content}}
+			for _, image := range msg.Images {
+				parts = append(parts, openAIPart{Type: "image_url", ImageURL: &openAIImageURL{URL: dataURL(image)}})
+			}
+			converted.Content = parts
+		}
+
+		for j, call := range msg.ToolCalls {
+			toolCall := openAIToolCall{Index: j, ID: fmt.Sprintf("call_%d_%d", i, j), Type: "function"}
+			toolCall.Function.Name = call.Function.Name
+			toolCall.Function.Arguments = string(call.Function.Arguments)
+			converted.ToolCalls = append(converted.ToolCalls, toolCall)
+			pending = append(pending, toolCall)
+		}
+		if msg.Role == "tool" && len(pending) > 0 {
+			answered := 0
+			for k, call := range pending {
+				if call.Function.Name == msg.ToolName {
+					answered = k
+					break
+				}
+			}
+			converted.ToolCallID = pending[answered].ID
+			pending = append(pending[:answered], pending[answered+1:]...)
+		}
+		out.Messages = append(out.Messages, converted)
+	}
+	return out
+}
+
+// dataURL turns a base64 image into the data URL OpenAI's API takes.
+func dataURL(image string) string {
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(image))
+	head, _ := io.ReadAll(io.LimitReader(decoder, 512))
+	return "data:" + http.DetectContentType(head) + ";base64," + image
+}
+
+func (o *OpenAI) StreamChat(ctx context.Context, req ollama.ChatRequest, fn func(ollama.ChatResponse)) error {
+	body, err := json.Marshal(openAIRequestFrom(req))
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := o.do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Tool calls arrive in fragments, keyed by their index.
+	calls := map[int]*openAIToolCall{}
+	var finishReason string
+	var metrics ollama.Metrics
+	var firstToken time.Time
+	finished := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			finished = true
+			break
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Printf("Error unmarshaling response chunk from %s: %v", o.name, err)
+			continue
+		}
+		if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+			message := errorMessage(chunk.Error)
+			if message == "" {
+				message = string(chunk.Error)
+			}
+			return fmt.Errorf("%s: %s", o.name, message)
+		}
+		if chunk.Usage != nil {
+			metrics.PromptEvalCount = chunk.Usage.PromptTokens
+			metrics.EvalCount = chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			delta := choice.Delta
+			thinking := delta.ReasoningContent + delta.Reasoning
+			if (delta.Content != "" || thinking != "") && firstToken.IsZero() {
+				firstToken = time.Now()
+			}
+			if delta.Content != "" || thinking != "" {
+				fn(ollama.ChatResponse{
+					Model:   req.Model,
+					Message: ollama.Message{Role: "assistant", Content: delta.Content, Thinking: thinking},
+				})
+			}
+			for _, fragment := range delta.ToolCalls {
+				call, ok := calls[fragment.Index]
+				if !ok {
+					call = &openAIToolCall{Index: fragment.Index}
+					calls[fragment.Index] = call
+				}
+				if fragment.ID != "" {
+					call.ID = fragment.ID
+				}
+				call.Function.Name += fragment.Function.Name
+				call.Function.Arguments += fragment.Function.Arguments
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				finishReason = *choice.FinishReason
+				finished = true
+			}
+		}
+	}
+	// A cancelled request ends the body early too, which isn't a failure.
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+	if !finished {
+		return fmt.Errorf("%s ended the reply before it was complete", o.name)
+	}
+
+	final := ollama.ChatResponse{
+		Model:      req.Model,
+		Message:    ollama.Message{Role: "assistant", ToolCalls: toolCalls(calls)},
+		Done:       true,
+		DoneReason: "stop",
+	}
+	if finishReason == "length" {
+		final.DoneReason = "length"
+	}
+	metrics.TotalDuration = time.Since(start).Nanoseconds()
+	if !firstToken.IsZero() {
+		metrics.EvalDuration = time.Since(firstToken).Nanoseconds()
+		metrics.PromptEvalDuration = firstToken.Sub(start).Nanoseconds()
+	}
+	final.Metrics = metrics
+	fn(final)
+	return nil
+}
+
+// toolCalls orders the assembled calls by index.
+func toolCalls(calls map[int]*openAIToolCall) []ollama.ToolCall {
+	indexes := make([]int, 0, len(calls))
+	for index := range calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var list []ollama.ToolCall
+	for _, index := range indexes {
+		call := calls[index]
+		arguments := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(arguments) {
+			arguments = json.RawMessage("{}")
+		}
+		list = append(list, ollama.ToolCall{
+			Function: ollama.ToolCallFunction{Name: call.Function.Name, Arguments: arguments},
+		})
+	}
+	return list
+}
+
+// do sends the request with the API key, turning error statuses into
+// errors carrying the server's message.
+func (o *OpenAI) do(req *http.Request) (*http.Response, error) {
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	message := ""
+	if json.Unmarshal(data, &body) == nil {
+		message = errorMessage(body.Error)
+		if message == "" {
+			message = body.Message
+		}
+	}
+	if message == "" {
+		return nil, fmt.Errorf("%s returned status %d", o.name, resp.StatusCode)
+	}
+	return nil, fmt.Errorf("%s returned status %d: %s", o.name, resp.StatusCode, message)
+}
+
+// errorMessage reads an "error" field, which servers send either as an
+// object with a message or as a plain string.
+func errorMessage(raw json.RawMessage) string {
+	var nested struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(raw, &nested) == nil && nested.Message != "" {
+		return nested.Message
+	}
+	var message string
+	json.Unmarshal(raw, &message)
+	return message
+}
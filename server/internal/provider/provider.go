@@ -0,0 +1,184 @@
+// Package provider puts the backends that serve models behind one
+// interface. Ollama serves models under their own names, and every other
+// configured provider serves them as "<provider>/<model>".
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// OllamaName is the provider of unprefixed model names.
+const OllamaName = "ollama"
+
+// separator joins a provider's name and its model's name.
+const separator = "/"
+
+// Provider is a backend that lists models and chats with them. Requests and
+// replies use Ollama's types, which the rest of the server speaks.
+type Provider interface {
+	Name() string
+	ListModels(ctx context.Context) ([]ollama.ModelInfo, error)
+
+	// StreamChat calls fn with every chunk of the reply as it arrives, the
+	// last one having Done set. The error is only set when the request
+	// failed; a stream cut short by ctx ends early without one.
+	StreamChat(ctx context.Context, req ollama.ChatRequest, fn func(ollama.ChatResponse)) error
+}
+
+// Model is an entry of the merged model list, named the way requests
+// should name it.
+type Model struct {
+	ollama.ModelInfo
+	Provider string `json:"provider"`
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+
+	// defaultProvider serves the models without a prefix.
+	defaultProvider Provider = Ollama{}
+)
+
+// Register adds a provider whose models are named "<name>/<model>".
+func Register(p Provider) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p.Name() == OllamaName {
+		return fmt.Errorf("provider name %q is reserved", OllamaName)
+	}
+	if _, ok := providers[p.Name()]; ok {
+		return fmt.Errorf("provider %s is already registered", p.Name())
+	}
+	providers[p.Name()] = p
+	return nil
+}
+
+// Unregister removes a provider added with Register.
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(providers, name)
+}
+
+// Resolve returns the provider serving model and the name the provider
+// knows the model by. Names without a registered prefix go to Ollama, whose
+// own names may contain slashes.
+func Resolve(model string) (Provider, string) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if name, rest, ok := strings.Cut(model, separator); ok {
+		if p, ok := providers[name]; ok {
+			return p, rest
+		}
+	}
+	return defaultProvider, model
+}
+
+// all returns Ollama followed by the other providers by name.
+func all() []Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := []Provider{defaultProvider}
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		list = append(list, providers[name])
+	}
+	return list
+}
+
+// ListModels merges the models of every provider, asking them all at once.
+// A provider that fails is logged and left out; the error is only returned
+// when none answered.
+func ListModels(ctx context.Context) ([]Model, error) {
+	list := all()
+	results := make([][]ollama.ModelInfo, len(list))
+	errs := make([]error, len(list))
+
+	var wg sync.WaitGroup
+	for i, p := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = p.ListModels(ctx)
+		}()
+	}
+	wg.Wait()
+
+	models := []Model{}
+	failed := 0
+	for i, p := range list {
+		if errs[i] != nil {
+			log.Printf("Failed to list models of provider %s: %v", p.Name(), errs[i])
+			failed++
+			continue
+		}
+		for _, info := range results[i] {
+			if p != defaultProvider {
+				info.Name = p.Name() + separator + info.Name
+				info.Model = p.Name() + separator + info.Model
+			}
+			models = append(models, Model{ModelInfo: info, Provider: p.Name()})
+		}
+	}
+	if failed == len(list) {
+		return nil, errors.Join(errs...)
+	}
+	return models, nil
+}
+
+// StreamChat sends the request to the provider of its model.
+func StreamChat(ctx context.Context, req ollama.ChatRequest, fn func(ollama.ChatResponse)) error {
+	p, model := Resolve(req.Model)
+	req.Model = model
+	return p.StreamChat(ctx, req, fn)
+}
+
+// Chat sends the request to the provider of its model and returns the
+// whole reply.
+func Chat(ctx context.Context, req ollama.ChatRequest) (*ollama.ChatResponse, error) {
+	resp := ollama.ChatResponse{Model: req.Model}
+	var content, thinking strings.Builder
+	err := StreamChat(ctx, req, func(chunk ollama.ChatResponse) {
+		content.WriteString(chunk.Message.Content)
+		thinking.WriteString(chunk.Message.Thinking)
+		resp.Message.ToolCalls = append(resp.Message.ToolCalls, chunk.Message.ToolCalls...)
+		if chunk.Error != "" {
+			resp.Error = chunk.Error
+		}
+		if chunk.Done {
+			resp.Done = true
+			resp.DoneReason = chunk.DoneReason
+			resp.Metrics = chunk.Metrics
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("reply failed: %s", resp.Error)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	resp.Message.Role = "assistant"
+	resp.Message.Content = content.String()
+	resp.Message.Thinking = thinking.String()
+	return &resp, nil
+}
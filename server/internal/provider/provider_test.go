@@ -0,0 +1,373 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// fakeOpenAI serves two models and answers every chat with reasoning, a
+// sentence and a tool call split into fragments.
+func fakeOpenAI(t *testing.T, requests *[]openAIRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"message": "bad key"}}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			fmt.Fprint(w, `{"object": "list", "data": [{"id": "qwen3", "created": 1700000000}, {"id": "gemma3"}]}`)
+		case "/v1/chat/completions":
+			var req openAIRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			*requests = append(*requests, req)
+			for _, chunk := range []string{
+				`{"choices": [{"delta": {"role": "assistant", "reasoning_content": "hmm"}}]}`,
+				`{"choices": [{"delta": {"content": "Hello"}}]}`,
+				`{"choices": [{"delta": {"content": " there"}}]}`,
+				`{"choices": [{"delta": {"tool_calls": [{"index": 0, "id": "c1", "function": {"name": "lookup", "arguments": "{\"q\":"}}]}}]}`,
+				`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"x\"}"}}]}}]}`,
+				`{"choices": [{"delta": {}, "finish_reason": "tool_calls"}]}`,
+				`{"choices": [], "usage": {"prompt_tokens": 9, "completion_tokens": 3}}`,
+				`[DONE]`,
+			} {
+				fmt.Fprintf(w, "data: %s\n\n", chunk)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// failing is a provider whose every request fails.
+type failing struct{ name string }
+
+func (f failing) Name() string { return f.name }
+
+func (f failing) ListModels(ctx context.Context) ([]ollama.ModelInfo, error) {
+	return nil, fmt.Errorf("%s is down", f.name)
+}
+
+func (f failing) StreamChat(ctx context.Context, req ollama.ChatRequest, fn func(ollama.ChatResponse)) error {
+	return fmt.Errorf("%s is down", f.name)
+}
+
+func TestProviders(t *testing.T) {
+	var requests []openAIRequest
+	ts := fakeOpenAI(t, &requests)
+	defer ts.Close()
+
+	if err := Register(NewOpenAI("local", ts.URL+"/v1/", "secret")); err != nil {
+		t.Fatalf("failed to register provider: %v", err)
+	}
+	defer Unregister("local")
+	if err := Register(NewOpenAI("local", ts.URL, "")); err == nil {
+		t.Errorf("expected a second provider with the same name to be refused")
+	}
+	if err := Register(failing{name: OllamaName}); err == nil {
+		t.Errorf("expected the Ollama name to be reserved")
+	}
+
+	for model, want := range map[string][2]string{
+		"local/qwen3":        {"local", "qwen3"},
+		"llama3.2":           {OllamaName, "llama3.2"},
+		"hf.co/org/model:q4": {OllamaName, "hf.co/org/model:q4"},
+	} {
+		p, name := Resolve(model)
+		if p.Name() != want[0] || name != want[1] {
+			t.Errorf("expected %s to resolve to %v, got %s and %s", model, want, p.Name(), name)
+		}
+	}
+
+	// Ollama isn't running here, so only the provider's models are listed.
+	defaultProvider = failing{name: OllamaName}
+	defer func() { defaultProvider = Ollama{} }()
+	models, err := ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("expected the failing provider to be skipped, got %v", err)
+	}
+	if len(models) != 2 || models[0].Name != "local/qwen3" || models[0].Provider != "local" || models[0].ModifiedAt == nil {
+		t.Errorf("expected the provider's models with its prefix, got %+v", models)
+	}
+
+	Unregister("local")
+	if _, err := ListModels(context.Background()); err == nil {
+		t.Errorf("expected an error when no provider answers")
+	}
+	Register(NewOpenAI("local", ts.URL+"/v1", "secret"))
+
+	numPredict := 32
+	resp, err := Chat(context.Background(), ollama.ChatRequest{
+		Model: "local/qwen3",
+		Messages: []ollama.Message{
+			{Role: "user", Content: "Hi"},
+		},
+		Options: &ollama.Options{NumPredict: &numPredict},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resp.Message.Content != "Hello there" || resp.Message.Thinking != "hmm" || !resp.Done {
+		t.Errorf("expected the streamed reply, got %+v", resp.Message)
+	}
+	if calls := resp.Message.ToolCalls; len(calls) != 1 || calls[0].Function.Name != "lookup" || string(calls[0].Function.Arguments) != `{"q":"x"}` {
+		t.Errorf("expected the tool call's fragments to be joined, got %+v", calls)
+	}
+	if resp.Metrics.PromptEvalCount != 9 || resp.Metrics.EvalCount != 3 {
+		t.Errorf("expected the usage as metrics, got %+v", resp.Metrics)
+	}
+	if len(requests) != 1 || requests[0].Model != "qwen3" || *requests[0].MaxTokens != 32 {
+		t.Errorf("expected the model without its prefix and num_predict as max_tokens, got %+v", requests)
+	}
+
+	Unregister("local")
+	Register(NewOpenAI("local", ts.URL+"/v1", "wrong"))
+	if _, err := Chat(context.Background(), ollama.ChatRequest{Model: "local/qwen3"}); err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Errorf("expected the server's error message, got %v", err)
+	}
+}
+
+func TestOpenAIRequestFrom(t *testing.T) {
+	pixel := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+	req := openAIRequestFrom(ollama.ChatRequest{
+		Model: "qwen3",
+		Messages: []ollama.Message{
+			{Role: "user", Content: "What is this?", Images: []string{pixel}},
+			{Role: "assistant", ToolCalls: []ollama.ToolCall{
+				{Function: ollama.ToolCallFunction{Name: "lookup", Arguments: json.RawMessage(`{"q":"a"}`)}},
+				{Function: ollama.ToolCallFunction{Name: "fetch", Arguments: json.RawMessage(`{}`)}},
+			}},
+			{Role: "tool", ToolName: "fetch", Content: "page"},
+			{Role: "tool", ToolName: "lookup", Content: "result"},
+		},
+	})
+
+	parts, ok := req.Messages[0].Content.([]openAIPart)
+	if !ok || len(parts) != 2 || parts[1].ImageURL.URL != "data:image/png;base64,"+pixel {
+		t.Errorf("expected text and image parts, got %+v", req.Messages[0].Content)
+	}
+	calls := req.Messages[1].ToolCalls
+	if len(calls) != 2 || calls[0].Function.Arguments != `{"q":"a"}` {
+		t.Fatalf("expected both tool calls, got %+v", calls)
+	}
+	if req.Messages[2].ToolCallID != calls[1].ID || req.Messages[3].ToolCallID != calls[0].ID {
+		t.Errorf("expected each tool result to answer its call, got %q and %q", req.Messages[2].ToolCallID, req.Messages[3].ToolCallID)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TEST_PROVIDER_KEY", "secret")
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "providers.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		return path
+	}
+
+	config, err := LoadConfig(write(`{"providers": {"lm-studio": {"url": "http://localhost:1234/v1", "api_key": "${TEST_PROVIDER_KEY}"}}}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := RegisterProviders(config); err != nil {
+		t.Fatalf("failed to register providers: %v", err)
+	}
+	defer Unregister("lm-studio")
+	if p, _ := Resolve("lm-studio/qwen3"); p.(*OpenAI).apiKey != "secret" {
+		t.Errorf("expected the API key from the environment")
+	}
+
+	for _, invalid := range []string{
+		`{"providers": {"bad name": {"url": "http://localhost:1234/v1"}}}`,
+		`{"providers": {"ollama": {"url": "http://localhost:1234/v1"}}}`,
+		`{"providers": {"local": {"url": "localhost:1234"}}}`,
+		`not json`,
+	} {
+		if _, err := LoadConfig(write(invalid)); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+// fakeHost is an Ollama server holding models, some of them in memory. It
+// counts the chats it answers, and drops the connection instead while
+// broken is set.
+type fakeHost struct {
+	models, loaded []string
+	chats          int
+	broken         bool
+}
+
+func (f *fakeHost) start(t *testing.T) *httptest.Server {
+	list := func(names []string) string {
+		var entries []string
+		for _, name := range names {
+			entries = append(entries, fmt.Sprintf(`{"name": %q, "model": %q}`, name, name))
+		}
+		return `{"models": [` + strings.Join(entries, ", ") + `]}`
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, list(f.models))
+		case "/api/ps":
+			fmt.Fprint(w, list(f.loaded))
+		case "/api/chat":
+			if f.broken {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			f.chats++
+			fmt.Fprint(w, `{"message": {"role": "assistant", "content": "hi"}, "done": true}`+"\n")
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestOllamaHosts(t *testing.T) {
+	a := &fakeHost{models: []string{"llama3:latest", "gemma3:4b"}}
+	tsA := a.start(t)
+	defer tsA.Close()
+	b := &fakeHost{models: []string{"llama3:latest", "qwen3:8b"}, loaded: []string{"llama3:latest"}}
+	tsB := b.start(t)
+	defer tsB.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := config.Get()
+	previous := cfg.OllamaURL
+	cfg.OllamaURL = strings.Join([]string{down.URL, tsA.URL, tsB.URL}, ",")
+	defer func() { cfg.OllamaURL = previous }()
+
+	models, err := Ollama{}.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("expected the unhealthy host to be skipped, got %v", err)
+	}
+	var names []string
+	for _, info := range models {
+		names = append(names, info.Name)
+	}
+	if got := strings.Join(names, " "); got != "llama3:latest gemma3:4b qwen3:8b" {
+		t.Errorf("expected each model once, got %q", got)
+	}
+
+	if holders := OllamaHolders("gemma3:4b"); len(holders) != 1 || holders[0] != hosts()[1].client {
+		t.Errorf("expected only the host with gemma3 to hold it, got %d", len(holders))
+	}
+	if holders := OllamaHolders("mistral"); len(holders) != 0 {
+		t.Errorf("expected no host to hold a missing model, got %d", len(holders))
+	}
+
+	chat := func(model string) {
+		t.Helper()
+		if _, err := Chat(context.Background(), ollama.ChatRequest{Model: model}); err != nil {
+			t.Fatalf("chat with %s failed: %v", model, err)
+		}
+	}
+	chat("llama3")
+	if b.chats != 1 || a.chats != 0 {
+		t.Errorf("expected the host with llama3 in memory to answer, got %d and %d chats", a.chats, b.chats)
+	}
+	chat("gemma3:4b")
+	if a.chats != 1 {
+		t.Errorf("expected the only host with gemma3 to answer")
+	}
+
+	// A host that can't be reached is left for the next one, and skipped
+	// until it is checked again.
+	b.broken = true
+	chat("llama3")
+	if a.chats != 2 {
+		t.Errorf("expected the request to move to the remaining host")
+	}
+	if _, err := hosts()[2].status(); err == nil {
+		t.Errorf("expected the broken host to be marked unhealthy")
+	}
+}
+
+func TestOllamaStreamErrors(t *testing.T) {
+	long := strings.Repeat("a", 100*1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			fmt.Fprint(w, `{"models": [{"name": "llama3:latest", "model": "llama3:latest"}]}`)
+			return
+		}
+		var req ollama.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Messages[0].Content == "long" {
+			fmt.Fprintf(w, `{"message": {"role": "assistant", "content": %q}, "done": true}`+"\n", long)
+			return
+		}
+		// The connection drops before the reply is complete.
+		w.Header().Set("Content-Length", "1000")
+		fmt.Fprint(w, `{"message": {"role": "assistant", "content": "Hel"}, "done": false}`+"\n")
+	}))
+	defer ts.Close()
+
+	cfg := config.Get()
+	previous := cfg.OllamaURL
+	cfg.OllamaURL = ts.URL
+	defer func() { cfg.OllamaURL = previous }()
+
+	chat := func(prompt string) (*ollama.ChatResponse, error) {
+		return Chat(context.Background(), ollama.ChatRequest{
+			Model:    "llama3",
+			Messages: []ollama.Message{{Role: "user", Content: prompt}},
+		})
+	}
+	resp, err := chat("long")
+	if err != nil || len(resp.Message.Content) != len(long) {
+		t.Errorf("expected a chunk larger than the default buffer to be read, got %v", err)
+	}
+	if _, err := chat("cut"); err == nil {
+		t.Errorf("expected a truncated reply to be an error")
+	}
+}
+
+func TestOpenAIStreamErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, "data: {\"choices\": [{\"delta\": {\"content\": \"Hel\"}}]}\n\n")
+		switch req.Messages[0].Content {
+		case "fail":
+			fmt.Fprint(w, "data: {\"error\": {\"message\": \"out of memory\"}}\n\n")
+		case "cut":
+			// The stream ends without a finish reason or [DONE].
+		}
+	}))
+	defer ts.Close()
+
+	p := NewOpenAI("local", ts.URL, "")
+	chat := func(prompt string) (string, error) {
+		var content string
+		err := p.StreamChat(context.Background(), ollama.ChatRequest{
+			Model:    "qwen3",
+			Messages: []ollama.Message{{Role: "user", Content: prompt}},
+		}, func(resp ollama.ChatResponse) {
+			content += resp.Message.Content
+		})
+		return content, err
+	}
+
+	if content, err := chat("fail"); err == nil || !strings.Contains(err.Error(), "out of memory") || content != "Hel" {
+		t.Errorf("expected the error event as an error after the first chunk, got %q, %v", content, err)
+	}
+	if _, err := chat("cut"); err == nil {
+		t.Errorf("expected a reply that breaks off to be an error")
+	}
+}
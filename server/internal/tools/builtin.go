@@ -0,0 +1,51 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CurrentTime tells the model the server's date and time, which it has no
+// other way of knowing.
+type CurrentTime struct{}
+
+func (CurrentTime) Name() string { return "current_time" }
+
+func (CurrentTime) Description() string {
+	return "Get the current date and time, optionally in a given IANA time zone such as Europe/Paris."
+}
+
+func (CurrentTime) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"timezone": {"type": "string", "description": "IANA time zone name; defaults to the server's zone"}
+		}
+	}`)
+}
+
+func (CurrentTime) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	now := time.Now()
+	if params.Timezone != "" {
+		location, err := time.LoadLocation(params.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("unknown time zone %q", params.Timezone)
+		}
+		now = now.In(location)
+	}
+	return now.Format("Monday, 2 January 2006 15:04:05 MST"), nil
+}
+
+// RegisterBuiltins adds the tools that ship with the server to r.
+func RegisterBuiltins(r *Registry) error {
+	return r.Register(CurrentTime{})
+}
@@ -0,0 +1,119 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// Tool is a function the model may call during a chat. Parameters is a JSON
+// schema object describing the arguments Execute receives.
+type Tool interface {
+	Name() string
+	Description() string
+	Parameters() json.RawMessage
+	Execute(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// Registry holds the tools offered to models, keyed by name.
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]Tool{}}
+}
+
+// Default is the registry generateResponse offers to models.
+var Default = NewRegistry()
+
+// Register adds a tool, refusing to shadow one with the same name.
+func (r *Registry) Register(tool Tool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tools[tool.Name()]; exists {
+		return fmt.Errorf("tool %q is already registered", tool.Name())
+	}
+	r.tools[tool.Name()] = tool
+	return nil
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tools, name)
+}
+
+func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// List returns the registered tools sorted by name.
+func (r *Registry) List() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Tool, 0, len(r.tools))
+	for _, tool := range r.tools {
+		list = append(list, tool)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// Subset returns a registry holding the tools for which keep returns true.
+func (r *Registry) Subset(keep func(Tool) bool) *Registry {
+	subset := NewRegistry()
+	for _, tool := range r.List() {
+		if keep(tool) {
+			subset.tools[tool.Name()] = tool
+		}
+	}
+	return subset
+}
+
+// Definitions describes the registered tools in the form Ollama's chat
+// request expects.
+func (r *Registry) Definitions() []ollama.Tool {
+	list := r.List()
+	definitions := make([]ollama.Tool, len(list))
+	for i, tool := range list {
+		definitions[i] = Definition(tool)
+	}
+	return definitions
+}
+
+func Definition(tool Tool) ollama.Tool {
+	return ollama.Tool{
+		Type: "function",
+		Function: ollama.ToolFunction{
+			Name:        tool.Name(),
+			Description: tool.Description(),
+			Parameters:  tool.Parameters(),
+		},
+	}
+}
+
+// Call runs the named tool. Unknown tools and execution failures are
+// reported as errors for the caller to hand back to the model.
+func (r *Registry) Call(ctx context.Context, call ollama.ToolCall) (string, error) {
+	tool, ok := r.Get(call.Function.Name)
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", call.Function.Name)
+	}
+
+	args := call.Function.Arguments
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	return tool.Execute(ctx, args)
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+type echoTool struct{}
+
+func (echoTool) Name() string                { return "echo" }
+func (echoTool) Description() string         { return "Echo the text argument" }
+func (echoTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+
+func (echoTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	return params.Text, nil
+}
+
+func TestRegistryCall(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(echoTool{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := registry.Register(echoTool{}); err == nil {
+		t.Error("expected an error registering a duplicate tool, got nil")
+	}
+
+	result, err := registry.Call(context.Background(), ollama.ToolCall{
+		Function: ollama.ToolCallFunction{Name: "echo", Arguments: json.RawMessage(`{"text":"hi"}`)},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result != "hi" {
+		t.Errorf("expected result 'hi', got '%s'", result)
+	}
+
+	_, err = registry.Call(context.Background(), ollama.ToolCall{
+		Function: ollama.ToolCallFunction{Name: "missing"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("expected unknown tool error, got %v", err)
+	}
+}
+
+func TestRegistryDefinitions(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(echoTool{})
+	registry.Register(CurrentTime{})
+
+	definitions := registry.Definitions()
+	if len(definitions) != 2 {
+		t.Fatalf("expected 2 definitions, got %d", len(definitions))
+	}
+	if definitions[0].Function.Name != "current_time" || definitions[1].Function.Name != "echo" {
+		t.Errorf("expected definitions sorted by name, got %+v", definitions)
+	}
+	if definitions[1].Type != "function" || string(definitions[1].Function.Parameters) != `{"type":"object"}` {
+		t.Errorf("unexpected definition: %+v", definitions[1])
+	}
+}
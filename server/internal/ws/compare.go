@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+// maxCompareModels caps how many models answer one "compare" request, as
+// they all run at once.
+const maxCompareModels = 4
+
+// compareResponses streams the same history to every model of req at once.
+// The events of each answer carry its model, so the client can show them in
+// columns, and each answer is saved below the prompt as soon as it is
+// complete. Tools aren't offered, so every model answers in a single reply.
+func compareResponses(ctx context.Context, gen *generation, convoID string, req WSRequest) {
+	log.Printf("Starting comparison of %v for ConvoID: %s", req.Models, convoID)
+
+	convo, err := database.GetConversationMetadata(convoID)
+	if err != nil || convo == nil {
+		log.Printf("Error fetching conversation %s: %v", convoID, err)
+		sendError(gen, "Failed to get conversation")
+		return
+	}
+
+	branch, err := database.GetBranchMessages(convo.ID, convo.ActiveLeafID)
+	if err != nil {
+		log.Printf("Error fetching history: %v", err)
+		sendError(gen, "Failed to get conversation history")
+		return
+	}
+	if len(branch) == 0 || branch[len(branch)-1].Role != database.RoleUser {
+		sendError(gen, "Nothing to compare")
+		return
+	}
+	promptID := branch[len(branch)-1].ID
+
+	// Every model gets the same history, fitted once to the smallest budget
+	// among them with the first model writing the summary if one is needed.
+	options := convo.Options.Merge(req.Options)
+	budget := 0
+	for _, model := range req.Models {
+		if b := historyBudget(model, options); b > 0 && (budget == 0 || b < budget) {
+			budget = b
+		}
+	}
+	branch, summary, trim := fitHistory(ctx, gen, convo, req.Models[0], branch,
+		budget, fixedTokens(convo, nil))
+	if trim != nil {
+		gen.send(WSResponse{
+			Type:    "context_trimmed",
+			Content: "",
+			Data:    trim,
+		})
+	}
+	history, err := buildHistory(convo, summary, branch)
+	if err != nil {
+		log.Printf("Error fetching history: %v", err)
+		sendError(gen, "Failed to get conversation history")
+		return
+	}
+
+	comparison, err := database.CreateComparison(convoID, promptID, req.Models)
+	if err != nil {
+		log.Printf("Error saving comparison: %v", err)
+		sendError(gen, "Failed to compare models")
+		return
+	}
+	gen.send(WSResponse{
+		Type:    "compare_started",
+		Content: comparison.ID,
+		Data:    map[string]any{"models": req.Models},
+	})
+
+	answers := make([]*database.Message, len(req.Models))
+	var wg sync.WaitGroup
+	for i, model := range req.Models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answers[i] = compareAnswer(ctx, gen, comparison, ollama.ChatRequest{
+				Model:    model,
+				Messages: history,
+				Options:  options,
+				Think:    req.Think,
+			})
+		}()
+	}
+	wg.Wait()
+
+	// Each answer became the active leaf when it was saved; settle on the
+	// first model's, whichever finished last.
+	var leaf *string
+	for _, answer := range answers {
+		if answer != nil {
+			leaf = &answer.ID
+			break
+		}
+	}
+	if leaf == nil {
+		if err := database.DeleteComparison(comparison.ID); err != nil {
+			log.Printf("Error deleting empty comparison: %v", err)
+		}
+	} else if err := database.SetActiveLeaf(convoID, leaf); err != nil {
+		log.Printf("Error selecting the first answer: %v", err)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		gen.send(WSResponse{
+			Type:    "cancelled",
+			Content: "",
+		})
+	case leaf == nil:
+		sendError(gen, "Failed to generate response")
+	default:
+		log.Printf("Comparison complete for conversation: %s", convoID)
+		gen.send(WSResponse{
+			Type:    "done",
+			Content: comparison.ID,
+		})
+	}
+}
+
+// compareAnswer streams and saves one model's answer of a comparison. It
+// returns nil when the model failed or had nothing to say, after telling the
+// client with a "compare_failed" event.
+func compareAnswer(ctx context.Context, gen *generation, comparison *database.Comparison, chatReq ollama.ChatRequest) *database.Message {
+	model := chatReq.Model
+	send := func(resp WSResponse) error {
+		resp.Model = model
+		return gen.send(resp)
+	}
+	fail := func(message string) *database.Message {
+		send(WSResponse{
+			Type:    "compare_failed",
+			Content: message,
+		})
+		return nil
+	}
+
+	result, err := streamReply(ctx, send, chatReq)
+	if err != nil && chatReq.Think != nil && strings.Contains(err.Error(), "does not support thinking") {
+		log.Printf("Model %s does not support thinking, retrying without it", model)
+		chatReq.Think = nil
+		result, err = streamReply(ctx, send, chatReq)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("Chat request to %s failed: %v", model, err)
+		return fail("Failed to generate response")
+	}
+
+	interrupted := ctx.Err() != nil
+	if result.content == "" && !(interrupted && result.rawContent != "") {
+		log.Printf("Warning: Empty response from %s in comparison %s", model, comparison.ID)
+		if interrupted {
+			return nil
+		}
+		return fail("The model returned an empty response")
+	}
+
+	message := database.Message{
+		ConversationID: comparison.ConversationID,
+		ParentID:       &comparison.PromptID,
+		Role:           database.RoleAssistant,
+		Content:        result.content,
+		RawContent:     result.rawContent,
+		Thinking:       pointerString(result.thinking),
+		ThinkingTime:   &result.thinkingTime,
+		Interrupted:    interrupted,
+		Model:          model,
+		Stats:          result.stats,
+		ComparisonID:   &comparison.ID,
+	}
+	if err := database.AddMessageWithThinking(&message); err != nil {
+		log.Printf("Error saving answer of %s: %v", model, err)
+		return fail("Failed to save response")
+	}
+
+	resp := WSResponse{
+		Type:    "compare_answer",
+		Content: message.ID,
+	}
+	if result.stats != nil {
+		resp.Data = result.stats
+	}
+	send(resp)
+	return &message
+}
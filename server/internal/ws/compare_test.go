@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+func TestCompare(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models": [{"name": "llama3:latest"}, {"name": "gemma3:latest"}, {"name": "broken:latest"}]}`)
+		case "/api/chat":
+			var req ollama.ChatRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Model == "broken" {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"error": "model failed to load"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"message": {"role": "assistant", "content": "Answer from %s"}, "done": false}`+"\n", req.Model)
+			fmt.Fprint(w, `{"message": {"role": "assistant", "content": ""}, "done": true, "eval_count": 3}`+"\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Get()
+	previous := cfg.OllamaURL
+	cfg.OllamaURL = ts.URL
+	defer func() { cfg.OllamaURL = previous }()
+	cfg.DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	convoID, err := database.CreateConversation("", "compare", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+
+	client, conn := connect(t)
+	client.currentConvoID = convoID
+	handleCompare(client, WSRequest{Type: "compare", Message: "Hi", Models: []string{"llama3", "gemma3", "broken"}})
+
+	// The answers stream at once, so only the first and last events have a
+	// fixed place.
+	var events []WSResponse
+	for len(events) == 0 || events[len(events)-1].Type != "done" {
+		events = append(events, receive(t, conn, 1)...)
+	}
+	if events[0].Type != "compare_started" {
+		t.Fatalf("expected the comparison to start first, got %+v", events[0])
+	}
+	comparisonID := events[0].Content
+	if done := events[len(events)-1]; done.Content != comparisonID {
+		t.Errorf("expected done to name the comparison %s, got %+v", comparisonID, done)
+	}
+
+	answers := map[string]string{}
+	content := map[string]string{}
+	var failed []string
+	for _, event := range events {
+		switch event.Type {
+		case "response_chunk":
+			content[event.Model] += event.Content
+		case "compare_answer":
+			answers[event.Model] = event.Content
+		case "compare_failed":
+			failed = append(failed, event.Model)
+		}
+	}
+	if len(answers) != 2 || content["llama3"] != "Answer from llama3" || content["gemma3"] != "Answer from gemma3" {
+		t.Errorf("expected both working models to answer, got %v and %v", answers, content)
+	}
+	if len(failed) != 1 || failed[0] != "broken" {
+		t.Errorf("expected only the broken model to fail, got %v", failed)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); isGenerating(convoID) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	leaf, err := database.GetActiveLeaf(convoID)
+	if err != nil || leaf == nil || leaf.ID != answers["llama3"] {
+		t.Fatalf("expected the first model's answer to be active, got %+v, %v", leaf, err)
+	}
+	siblings, err := database.GetSiblings(leaf.ID)
+	if err != nil || len(siblings) != 2 {
+		t.Errorf("expected the answers saved side by side, got %d, %v", len(siblings), err)
+	}
+
+	// Preferring the other answer continues the conversation from it.
+	handlePrefer(client, WSRequest{Type: "prefer", MessageID: answers["gemma3"]})
+	got := receive(t, conn, 1)[0]
+	if got.Type != "answer_preferred" || got.Content != answers["gemma3"] || got.Model != "gemma3" {
+		t.Errorf("expected the answer to be preferred, got %+v", got)
+	}
+	if leaf, _ := database.GetActiveLeaf(convoID); leaf == nil || leaf.ID != answers["gemma3"] {
+		t.Errorf("expected the preferred answer to become active, got %+v", leaf)
+	}
+	comparisons, err := database.ListComparisons("")
+	if err != nil || len(comparisons) != 1 || comparisons[0].ID != comparisonID || len(comparisons[0].Answers) != 2 {
+		t.Fatalf("expected the comparison with its two answers, got %+v, %v", comparisons, err)
+	}
+	if preferred := comparisons[0].PreferredID; preferred == nil || *preferred != answers["gemma3"] {
+		t.Errorf("expected the preference to be recorded, got %v", preferred)
+	}
+
+	// Only answers of a comparison can be preferred.
+	handlePrefer(client, WSRequest{Type: "prefer", MessageID: *leaf.ParentID})
+	if got := receive(t, conn, 1)[0]; got.Type != "error" || got.Content != "Only answers of a comparison can be preferred" {
+		t.Errorf("expected the prompt to be refused, got %+v", got)
+	}
+}
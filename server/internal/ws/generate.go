@@ -0,0 +1,452 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/mcp"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+	"ollama-tiny-chat/server/internal/tools"
+)
+
+const (
+	// maxToolRounds caps how many times in a row the model may answer with
+	// tool calls. The round after that is sent without tools so the model
+	// has to reply in text.
+	maxToolRounds = 8
+	toolTimeout   = 30 * time.Second
+)
+
+// toolless holds the models that refused tools, so that they aren't sent
+// them, and refused again, with every message.
+var toolless sync.Map
+
+// rejectsTools reports whether err is a model, or the server running it,
+// refusing a request because it has tools: Ollama's models without tool
+// support, vLLM without --enable-auto-tool-choice and llama.cpp without
+// --jinja.
+func rejectsTools(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "does not support tools") ||
+		strings.Contains(message, "--enable-auto-tool-choice") ||
+		strings.Contains(message, "--jinja")
+}
+
+// startGeneration runs generateResponse, or compareResponses for a
+// "compare" request, in the background so the read loop stays free to
+// receive a cancel request while the models are streaming. The generation
+// isn't tied to client's connection and carries on if it goes away.
+func startGeneration(client *Client, convoID string, req WSRequest) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gen := newGeneration(client, convoID, cancel)
+	if gen == nil {
+		cancel()
+		sendError(client, "A response is already being generated")
+		return
+	}
+
+	go func() {
+		defer func() {
+			gen.finish()
+			cancel()
+		}()
+		if req.Type == "compare" {
+			compareResponses(ctx, gen, convoID, req)
+			return
+		}
+		generateResponse(ctx, gen, convoID, req)
+	}()
+}
+
+// reply is what one streamed chat round produced.
+type reply struct {
+	content      string
+	rawContent   string
+	thinking     string
+	thinkingTime float64
+	toolCalls    []ollama.ToolCall
+	stats        *database.MessageStats
+}
+
+func generateResponse(ctx context.Context, gen *generation, convoID string, req WSRequest) {
+	log.Printf("Starting response generation for ConvoID: %s", convoID)
+
+	convo, err := database.GetConversationMetadata(convoID)
+	if err != nil || convo == nil {
+		log.Printf("Error fetching conversation %s: %v", convoID, err)
+		sendError(gen, "Failed to get conversation")
+		return
+	}
+
+	// Requests that don't name a model, such as a plain regenerate, reuse the
+	// one the conversation was started with.
+	model := req.Model
+	if model == "" {
+		model = convo.Model
+	}
+
+	log.Printf("Fetching conversation history for ID: %s", convo.ID)
+	branch, err := database.GetBranchMessages(convo.ID, convo.ActiveLeafID)
+	if err != nil {
+		log.Printf("Error fetching history: %v", err)
+		sendError(gen, "Failed to get conversation history")
+		return
+	}
+	prompt, isFirst := firstPrompt(branch)
+
+	options := convo.Options.Merge(req.Options)
+	registry := conversationTools(convo)
+	toolDefinitions := registry.Definitions()
+	if _, ok := toolless.Load(model); ok {
+		toolDefinitions = nil
+	}
+	think := req.Think
+
+	branch, summary, trim := fitHistory(ctx, gen, convo, model, branch,
+		historyBudget(model, options), fixedTokens(convo, toolDefinitions))
+	if trim != nil {
+		log.Printf("Trimmed %d messages from the history of conversation %s (summarized: %t)",
+			trim.OmittedMessages, convoID, trim.Summarized)
+		gen.send(WSResponse{
+			Type:    "context_trimmed",
+			Content: "",
+			Data:    trim,
+		})
+	}
+
+	ollamaMessages, err := buildHistory(convo, summary, branch)
+	if err != nil {
+		log.Printf("Error fetching history: %v", err)
+		sendError(gen, "Failed to get conversation history")
+		return
+	}
+	parentID := convo.ActiveLeafID
+	var stats *database.MessageStats
+
+	for round := 0; ; round++ {
+		if round == maxToolRounds {
+			log.Printf("Tool call limit reached for conversation %s, asking for a final answer", convoID)
+			toolDefinitions = nil
+		}
+
+		log.Printf("Sending request for %s with %d messages", model, len(ollamaMessages))
+		result, err := streamReply(ctx, gen.send, ollama.ChatRequest{
+			Model:    model,
+			Messages: ollamaMessages,
+			Options:  options,
+			Tools:    toolDefinitions,
+			Think:    think,
+		})
+		// Models reject features they lack instead of ignoring them, so drop
+		// the feature and ask again.
+		if err != nil && toolDefinitions != nil && rejectsTools(err) {
+			log.Printf("Model %s does not support tools, retrying without them", model)
+			toolless.Store(model, struct{}{})
+			toolDefinitions = nil
+			round--
+			continue
+		}
+		if err != nil && think != nil && strings.Contains(err.Error(), "does not support thinking") {
+			log.Printf("Model %s does not support thinking, retrying without it", model)
+			think = nil
+			round--
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Generation cancelled before the model responded")
+				gen.send(WSResponse{Type: "cancelled", Content: ""})
+				return
+			}
+			log.Printf("Chat request failed: %v", err)
+			sendError(gen, "Failed to generate response")
+			return
+		}
+
+		// A cancelled context surfaces as a read error on the body, which
+		// ends the stream early with whatever was produced so far. Tool
+		// calls only arrive complete, so a cut-off round never runs them.
+		interrupted := ctx.Err() != nil
+		if interrupted {
+			log.Printf("Generation cancelled for conversation: %s", convoID)
+			result.toolCalls = nil
+		}
+
+		log.Println("Stream complete, saving response")
+		if result.content == "" && len(result.toolCalls) == 0 && !(interrupted && result.rawContent != "") {
+			log.Printf("Warning: Empty response received for conversation: %s", convoID)
+			break
+		}
+
+		assistantMessage := database.Message{
+			ConversationID: convoID,
+			ParentID:       parentID,
+			Role:           database.RoleAssistant,
+			Content:        result.content,
+			RawContent:     result.rawContent,
+			ToolCalls:      result.toolCalls,
+			Thinking:       pointerString(result.thinking),
+			ThinkingTime:   &result.thinkingTime,
+			Interrupted:    interrupted,
+			Model:          model,
+			Stats:          result.stats,
+		}
+		if err := database.AddMessageWithThinking(&assistantMessage); err != nil {
+			log.Printf("Error saving response: %v", err)
+			sendError(gen, "Failed to save response")
+			return
+		}
+		log.Printf("Response saved successfully for conversation: %s", convoID)
+		parentID = &assistantMessage.ID
+		stats = sumStats(stats, result.stats)
+
+		if interrupted {
+			break
+		}
+
+		if len(result.toolCalls) == 0 {
+			if isFirst && config.Get().AutoTitle {
+				go generateTitle(convo, model, prompt, result.content)
+			}
+			break
+		}
+
+		ollamaMessages = append(ollamaMessages, ollama.Message{
+			Role:      database.RoleAssistant,
+			Content:   result.rawContent,
+			ToolCalls: result.toolCalls,
+		})
+		for _, call := range result.toolCalls {
+			toolMessage, err := runTool(ctx, gen, registry, convoID, parentID, call)
+			if err != nil {
+				log.Printf("Error saving tool result: %v", err)
+				sendError(gen, "Failed to save tool result")
+				return
+			}
+			parentID = &toolMessage.ID
+			ollamaMessages = append(ollamaMessages, ollama.Message{
+				Role:     database.RoleTool,
+				Content:  toolMessage.RawContent,
+				ToolName: toolMessage.ToolName,
+			})
+		}
+	}
+
+	if ctx.Err() != nil {
+		gen.send(WSResponse{
+			Type:    "cancelled",
+			Content: "",
+		})
+		return
+	}
+
+	log.Printf("Response generation complete for conversation: %s", convoID)
+	resp := WSResponse{
+		Type:    "done",
+		Content: "",
+	}
+	// Leave Data out rather than send null when Ollama reported nothing.
+	if stats != nil {
+		resp.Data = stats
+	}
+	gen.send(resp)
+}
+
+// streamReply sends one chat request and forwards the streamed thinking and
+// answer through send as they arrive. Reasoning is taken from the thinking
+// field when the model sends one and from <think> tags in the content
+// otherwise. The returned error is set when the request failed or the model
+// reported an error mid-stream; a stream cut short by ctx returns what
+// arrived.
+func streamReply(ctx context.Context, send func(WSResponse) error, chatReq ollama.ChatRequest) (reply, error) {
+	var result reply
+
+	var parser ollama.ThinkParser
+	var fullResponse strings.Builder
+	var thinking strings.Builder
+	var rawContent strings.Builder
+	isThinking := false
+	var thinkStartTime time.Time
+
+	// setThinking tells the client when reasoning starts and ends.
+	setThinking := func(on bool) {
+		if on == isThinking {
+			return
+		}
+		isThinking = on
+		if on {
+			log.Println("Entering thinking mode")
+			thinkStartTime = time.Now()
+			send(WSResponse{
+				Type:    "thinking_start",
+				Content: "",
+			})
+			return
+		}
+		log.Println("Exiting thinking mode")
+		result.thinkingTime += time.Since(thinkStartTime).Seconds()
+		send(WSResponse{
+			Type:    "thinking_end",
+			Content: thinking.String(),
+		})
+	}
+
+	write := func(segments []ollama.Segment) {
+		for _, segment := range segments {
+			setThinking(segment.Thinking)
+			if segment.Thinking {
+				thinking.WriteString(segment.Text)
+				send(WSResponse{
+					Type:    "thinking_chunk",
+					Content: segment.Text,
+				})
+			} else {
+				fullResponse.WriteString(segment.Text)
+				send(WSResponse{
+					Type:    "response_chunk",
+					Content: segment.Text,
+				})
+			}
+		}
+	}
+
+	log.Printf("Starting to process the stream of %s", chatReq.Model)
+	var streamErr error
+	err := provider.StreamChat(ctx, chatReq, func(chatResp ollama.ChatResponse) {
+		if chatResp.Error != "" {
+			log.Printf("Model stream error: %s", chatResp.Error)
+			streamErr = errors.New(chatResp.Error)
+			return
+		}
+		result.toolCalls = append(result.toolCalls, chatResp.Message.ToolCalls...)
+
+		if chatResp.Message.Thinking != "" {
+			write([]ollama.Segment{{Thinking: true, Text: chatResp.Message.Thinking}})
+		}
+		if chunk := chatResp.Message.Content; chunk != "" {
+			rawContent.WriteString(chunk)
+			write(parser.Feed(chunk))
+			// A chunk holding only a tag produces no text but still
+			// starts or ends the reasoning.
+			setThinking(parser.Thinking())
+		}
+
+		if chatResp.Done {
+			result.stats = database.NewMessageStats(chatResp.Metrics)
+			log.Printf("Full response so far: %s", fullResponse.String())
+			log.Println("Received done signal from the model")
+		}
+	})
+	if err == nil {
+		err = streamErr
+	}
+	if err != nil {
+		return result, err
+	}
+
+	write(parser.Flush())
+	setThinking(false)
+
+	result.content = fullResponse.String()
+	result.rawContent = rawContent.String()
+	result.thinking = thinking.String()
+	return result, nil
+}
+
+// sumStats adds the stats of one round of a tool loop to the total reported
+// with the "done" event.
+func sumStats(total, stats *database.MessageStats) *database.MessageStats {
+	if stats == nil {
+		return total
+	}
+	if total == nil {
+		sum := *stats
+		return &sum
+	}
+	total.PromptTokens += stats.PromptTokens
+	total.CompletionTokens += stats.CompletionTokens
+	total.TotalDuration += stats.TotalDuration
+	total.LoadDuration += stats.LoadDuration
+	total.PromptEvalDuration += stats.PromptEvalDuration
+	total.EvalDuration += stats.EvalDuration
+	total.TokensPerSecond = ollama.Metrics{
+		EvalCount:    total.CompletionTokens,
+		EvalDuration: total.EvalDuration,
+	}.TokensPerSecond()
+	return total
+}
+
+// runTool executes one tool call, persists its result as a "tool" message
+// below parentID and reports both ends of the call to the client. A failing
+// tool is not an error here: the failure is handed back to the model, which
+// can explain it or try again.
+func runTool(ctx context.Context, gen *generation, registry *tools.Registry, convoID string, parentID *string, call ollama.ToolCall) (*database.Message, error) {
+	name := call.Function.Name
+	log.Printf("Model called tool %s with arguments %s", name, call.Function.Arguments)
+	gen.send(WSResponse{
+		Type:    "tool_call",
+		Content: name,
+		Data: map[string]any{
+			"name":      name,
+			"arguments": call.Function.Arguments,
+		},
+	})
+
+	toolCtx, cancel := context.WithTimeout(ctx, toolTimeout)
+	defer cancel()
+
+	output, err := registry.Call(toolCtx, call)
+	failed := err != nil
+	if failed {
+		log.Printf("Tool %s failed: %v", name, err)
+		output = "Error: " + err.Error()
+	}
+
+	message := database.Message{
+		ConversationID: convoID,
+		ParentID:       parentID,
+		Role:           database.RoleTool,
+		Content:        output,
+		RawContent:     output,
+		ToolName:       name,
+	}
+	if err := database.AddMessageWithThinking(&message); err != nil {
+		return nil, err
+	}
+
+	gen.send(WSResponse{
+		Type:    "tool_result",
+		Content: output,
+		Data: map[string]any{
+			"name":       name,
+			"message_id": message.ID,
+			"error":      failed,
+		},
+	})
+	return &message, nil
+}
+
+// conversationTools returns the registered tools minus those of the MCP
+// servers the conversation has turned off. Calls are run against the same
+// subset, so a model can't reach a tool it wasn't offered.
+func conversationTools(convo *database.Conversation) *tools.Registry {
+	return tools.Default.Subset(func(tool tools.Tool) bool {
+		mcpTool, ok := tool.(*mcp.Tool)
+		return !ok || !slices.Contains(convo.DisabledMCPServers, mcpTool.Server())
+	})
+}
+
+func pointerString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
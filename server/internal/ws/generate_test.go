@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/ollama"
+)
+
+func TestStreamReplyError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models": [{"name": "llama3:latest", "model": "llama3:latest"}]}`)
+		case "/api/chat":
+			fmt.Fprint(w, `{"message": {"role": "assistant", "content": "Hel"}, "done": false}`+"\n")
+			fmt.Fprint(w, `{"error": "model runner has unexpectedly stopped"}`+"\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Get()
+	previous := cfg.OllamaURL
+	cfg.OllamaURL = ts.URL
+	defer func() { cfg.OllamaURL = previous }()
+
+	var events []WSResponse
+	send := func(resp WSResponse) error {
+		events = append(events, resp)
+		return nil
+	}
+	_, err := streamReply(context.Background(), send, ollama.ChatRequest{Model: "llama3"})
+	if err == nil || err.Error() != "model runner has unexpectedly stopped" {
+		t.Errorf("expected the model's error, got %v", err)
+	}
+	if len(events) != 1 || events[0].Content != "Hel" {
+		t.Errorf("expected the chunk before the error to be streamed, got %+v", events)
+	}
+}
+
+func TestRejectsTools(t *testing.T) {
+	cases := map[string]bool{
+		`registry.ollama.ai/library/gemma3:latest does not support tools`:                                                  true,
+		`vllm returned status 400: "auto" tool choice requires --enable-auto-tool-choice and --tool-call-parser to be set`: true,
+		`llamacpp returned status 500: tools param requires --jinja flag`:                                                  true,
+		`llama3 does not support thinking`:                                                                                 false,
+		`failed to send request: connection refused`:                                                                       false,
+	}
+	for message, expected := range cases {
+		if got := rejectsTools(errors.New(message)); got != expected {
+			t.Errorf("rejectsTools(%q): expected %t, got %t", message, expected, got)
+		}
+	}
+}
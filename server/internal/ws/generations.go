@@ -0,0 +1,201 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// A generation is a reply, or a comparison, being written for a conversation.
+// It runs apart from the connection that asked for it, so a reload or a
+// network blip doesn't lose it: its events are kept and passed on to every
+// client watching the conversation, and a client that comes back with
+// "resume_conversation" is sent what it missed before the rest. The reply is
+// saved whether or not anybody is still watching when it ends.
+type generation struct {
+	convoID string
+	userID  string
+	cancel  context.CancelFunc
+	done    chan struct{} // closed once the generation has finished
+
+	mu       sync.Mutex
+	events   []WSResponse
+	watchers map[*Client]struct{}
+}
+
+var (
+	generationsMu sync.Mutex
+	generations   = map[string]*generation{}
+)
+
+// newGeneration registers a generation for the conversation with client
+// watching it. It returns nil when the conversation already has one.
+func newGeneration(client *Client, convoID string, cancel context.CancelFunc) *generation {
+	generationsMu.Lock()
+	defer generationsMu.Unlock()
+
+	if _, ok := generations[convoID]; ok {
+		return nil
+	}
+	gen := &generation{
+		convoID:  convoID,
+		userID:   client.userID,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		watchers: map[*Client]struct{}{client: {}},
+	}
+	generations[convoID] = gen
+	return gen
+}
+
+// Reserve registers a reply written for the conversation outside a
+// WebSocket, such as one asked for over the OpenAI-compatible API, so that
+// no other generation writes into the conversation meanwhile and deleting it
+// waits for the reply to be saved. It returns false when the conversation
+// already has a generation; otherwise release must be called once the reply
+// is saved, which tells clients that resumed the conversation meanwhile to
+// fetch it.
+func Reserve(convoID, userID string, cancel context.CancelFunc) (release func(), ok bool) {
+	generationsMu.Lock()
+	defer generationsMu.Unlock()
+
+	if _, ok := generations[convoID]; ok {
+		return nil, false
+	}
+	gen := &generation{
+		convoID:  convoID,
+		userID:   userID,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		watchers: map[*Client]struct{}{},
+	}
+	generations[convoID] = gen
+	return func() {
+		gen.send(WSResponse{Type: "done", Content: ""})
+		gen.finish()
+	}, true
+}
+
+// generationFor returns the conversation's generation, or nil when no reply
+// is being written for it.
+func generationFor(convoID string) *generation {
+	generationsMu.Lock()
+	defer generationsMu.Unlock()
+	return generations[convoID]
+}
+
+func isGenerating(convoID string) bool {
+	return generationFor(convoID) != nil
+}
+
+// finish removes the generation from the registry once it has sent its last
+// event. Clients resuming the conversation afterwards find the reply saved.
+func (g *generation) finish() {
+	generationsMu.Lock()
+	defer generationsMu.Unlock()
+	if generations[g.convoID] == g {
+		delete(generations, g.convoID)
+	}
+	close(g.done)
+}
+
+// StopGeneration cancels the conversation's generation, if any, and waits
+// until it has saved what it had, so that the conversation can be deleted
+// without the reply being written into it afterwards.
+func StopGeneration(convoID string) {
+	if gen := generationFor(convoID); gen != nil {
+		gen.cancel()
+		<-gen.done
+	}
+}
+
+// StopUserGenerations does the same for every conversation of the user,
+// before the account is deleted.
+func StopUserGenerations(userID string) {
+	generationsMu.Lock()
+	var running []*generation
+	for _, gen := range generations {
+		if gen.userID == userID {
+			running = append(running, gen)
+		}
+	}
+	generationsMu.Unlock()
+
+	for _, gen := range running {
+		gen.cancel()
+		<-gen.done
+	}
+}
+
+// send keeps resp for clients that attach later and passes it on to the
+// watchers. A watcher whose connection fails is dropped, and the generation
+// carries on regardless, so the error is always nil.
+func (g *generation) send(resp WSResponse) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.keep(resp)
+	for client := range g.watchers {
+		if err := client.send(resp); err != nil {
+			log.Printf("Client stopped watching conversation %s: %v", g.convoID, err)
+			delete(g.watchers, client)
+		}
+	}
+	return nil
+}
+
+// keep appends resp to the events. A chunk continuing the previous chunk of
+// the same model is merged into it, so a long reply is replayed in a few
+// events rather than one per token.
+func (g *generation) keep(resp WSResponse) {
+	if resp.Type == "response_chunk" || resp.Type == "thinking_chunk" {
+		for i := len(g.events) - 1; i >= 0; i-- {
+			last := &g.events[i]
+			if last.Model != resp.Model {
+				continue
+			}
+			if last.Type == resp.Type {
+				last.Content += resp.Content
+				return
+			}
+			break
+		}
+	}
+	g.events = append(g.events, resp)
+}
+
+// attach sends client the events so far and then keeps it up to date with
+// the rest. The lock is held throughout, so no event is missed or sent twice
+// in between.
+func (g *generation) attach(client *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, resp := range g.events {
+		if err := client.send(resp); err != nil {
+			return
+		}
+	}
+	g.watchers[client] = struct{}{}
+}
+
+func (g *generation) detach(client *Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.watchers, client)
+}
+
+// stopWatching detaches client from every generation, when it moves to
+// another conversation or disconnects. The generations keep running.
+func stopWatching(client *Client) {
+	generationsMu.Lock()
+	running := make([]*generation, 0, len(generations))
+	for _, gen := range generations {
+		running = append(running, gen)
+	}
+	generationsMu.Unlock()
+
+	for _, gen := range running {
+		gen.detach(client)
+	}
+}
@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connect returns a server-side client and the connection reading what it
+// is sent.
+func connect(t *testing.T) (*Client, *websocket.Conn) {
+	clients := make(chan *Client, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		clients <- &Client{conn: conn}
+	}))
+	t.Cleanup(ts.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return <-clients, conn
+}
+
+// receive reads n events from conn.
+func receive(t *testing.T, conn *websocket.Conn, n int) []WSResponse {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	events := make([]WSResponse, n)
+	for i := range events {
+		if err := conn.ReadJSON(&events[i]); err != nil {
+			t.Fatalf("expected %d events, got %+v and %v", n, events[:i], err)
+		}
+	}
+	return events
+}
+
+func TestGenerationResume(t *testing.T) {
+	first, firstConn := connect(t)
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gen := newGeneration(first, "convo", cancel)
+	if gen == nil {
+		t.Fatalf("expected a generation to be registered")
+	}
+	defer gen.finish()
+	if newGeneration(first, "convo", cancel) != nil {
+		t.Errorf("expected a second generation of the conversation to be refused")
+	}
+
+	gen.send(WSResponse{Type: "thinking_start"})
+	gen.send(WSResponse{Type: "thinking_chunk", Content: "Hm"})
+	gen.send(WSResponse{Type: "thinking_chunk", Content: "m"})
+	gen.send(WSResponse{Type: "thinking_end", Content: "Hmm"})
+	gen.send(WSResponse{Type: "response_chunk", Content: "Hel"})
+	receive(t, firstConn, 5)
+
+	// The first tab goes away, the reply carries on without anybody watching.
+	stopWatching(first)
+	if len(gen.watchers) != 0 {
+		t.Errorf("expected the client to stop watching")
+	}
+	gen.send(WSResponse{Type: "response_chunk", Content: "lo"})
+
+	second, secondConn := connect(t)
+	generationFor("convo").attach(second)
+	gen.send(WSResponse{Type: "response_chunk", Content: "!"})
+	gen.send(WSResponse{Type: "done"})
+
+	got := receive(t, secondConn, 6)
+	var types []string
+	for _, resp := range got {
+		types = append(types, resp.Type)
+	}
+	expected := "thinking_start thinking_chunk thinking_end response_chunk response_chunk done"
+	if strings.Join(types, " ") != expected {
+		t.Fatalf("expected %q, got %+v", expected, got)
+	}
+	if got[1].Content != "Hmm" || got[3].Content != "Hello" || got[4].Content != "!" {
+		t.Errorf("expected the buffered chunks merged and the live tail after them, got %+v", got)
+	}
+}
+
+func TestGenerationKeepsModelsApart(t *testing.T) {
+	gen := &generation{}
+	gen.keep(WSResponse{Type: "response_chunk", Content: "A", Model: "a"})
+	gen.keep(WSResponse{Type: "response_chunk", Content: "B", Model: "b"})
+	gen.keep(WSResponse{Type: "response_chunk", Content: "a", Model: "a"})
+	gen.keep(WSResponse{Type: "compare_answer", Content: "id", Model: "b"})
+	gen.keep(WSResponse{Type: "response_chunk", Content: "b", Model: "b"})
+
+	if len(gen.events) != 4 || gen.events[0].Content != "Aa" || gen.events[1].Content != "B" || gen.events[3].Content != "b" {
+		t.Errorf("expected chunks merged per model up to another event, got %+v", gen.events)
+	}
+}
+
+func TestStopGeneration(t *testing.T) {
+	client, _ := connect(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	gen := newGeneration(client, "deleted", cancel)
+
+	saved := false
+	go func() {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond) // the interrupted reply being saved
+		saved = true
+		gen.finish()
+	}()
+
+	StopGeneration("deleted")
+	if !saved || isGenerating("deleted") {
+		t.Errorf("expected StopGeneration to wait for the generation to finish")
+	}
+	StopGeneration("deleted")
+}
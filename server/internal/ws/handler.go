@@ -1,40 +1,76 @@
 package ws
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/auth"
 	"ollama-tiny-chat/server/internal/database"
 	"ollama-tiny-chat/server/internal/ollama"
-	"strings"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+// writeTimeout bounds a single write to a client.
+const writeTimeout = 10 * time.Second
+
+// upgrader keeps gorilla's default origin check: the connection is
+// authenticated by the session cookie, which a browser sends along from any
+// page, so only pages served by this server may open it.
+var upgrader = websocket.Upgrader{}
 
 type Client struct {
 	conn           *websocket.Conn
 	currentConvoID string
+
+	// The signed-in user, fixed at the upgrade. Conversations of other
+	// users can't be resumed.
+	userID string
+
+	// What the connection may do, fixed at the upgrade. API keys are
+	// limited to their scopes.
+	chat         bool
+	manageModels bool
+
+	writeMu sync.Mutex // gorilla connections allow only one concurrent writer
 }
 
 type WSRequest struct {
-	Type    string `json:"type"` // "message", "start_conversation", "resume_conversation"
+	Type    string `json:"type"` // "message", "start_conversation", "resume_conversation", "regenerate", "edit_message", "compare", "prefer", "cancel", "pull_model"
 	Message string `json:"message"`
 	Model   string `json:"model"`
 	ConvoID string `json:"convo_id,omitempty"`
+
+	SystemPrompt string `json:"system_prompt,omitempty"` // only read by "start_conversation"
+	MessageID    string `json:"message_id,omitempty"`    // user message replaced by "edit_message", answer chosen by "prefer"
+
+	// Models answer the same prompt side by side on "compare".
+	Models []string `json:"models,omitempty"`
+
+	// Images are attachment IDs returned by POST /api/attachments, sent with
+	// the message on "start_conversation", "message" and "compare".
+	Images []string `json:"images,omitempty"`
+
+	// Options are stored as the conversation defaults by "start_conversation"
+	// and override them for a single reply on "message", "regenerate" and
+	// "compare".
+	Options *ollama.Options `json:"options,omitempty"`
+
+	// Think turns reasoning on or off for models that support it, for
+	// every request that generates a reply. Nil keeps the model's default.
+	Think *bool `json:"think,omitempty"`
 }
 
 type WSResponse struct {
 	Type    string `json:"type"`
 	Content string `json:"content"`
+	ConvoID string `json:"convo_id,omitempty"` // set on events that may concern another conversation
+	Model   string `json:"model,omitempty"`    // set on the events of each answer of a comparison
+	Data    any    `json:"data,omitempty"`     // structured payload for events that need more than Content
 }
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -51,9 +87,19 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	client := &Client{
 		conn:           conn,
 		currentConvoID: "",
+		userID:         auth.UserID(r.Context()),
+		chat:           auth.Allows(r.Context(), database.ScopeChat),
+		manageModels:   auth.IsAdmin(r.Context()) && auth.Allows(r.Context(), database.ScopeManageModels),
 	}
 	log.Printf("WebSocket client connected from: %s", r.RemoteAddr)
 
+	register(client)
+	defer unregister(client)
+
+	// A generation outlives the socket, so the reply is still saved and a
+	// reconnecting client can pick it up again.
+	defer stopWatching(client)
+
 	for {
 		var req WSRequest
 		if err := conn.ReadJSON(&req); err != nil {
@@ -64,6 +110,11 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Printf("Received message type: %s", req.Type)
 
+		if !client.chat && req.Type != "pull_model" && req.Type != "cancel" {
+			sendError(client, "API key lacks the "+database.ScopeChat+" scope")
+			continue
+		}
+
 		switch req.Type {
 		case "start_conversation":
 			log.Printf("Starting new conversation with model: %s", req.Model)
@@ -74,38 +125,72 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		case "message":
 			log.Printf("Handling message for conversation: %s", client.currentConvoID)
 			handleMessage(client, req)
+		case "regenerate":
+			log.Printf("Regenerating last reply for conversation: %s", client.currentConvoID)
+			handleRegenerate(client, req)
+		case "edit_message":
+			log.Printf("Editing message %s in conversation: %s", req.MessageID, client.currentConvoID)
+			handleEditMessage(client, req)
+		case "compare":
+			log.Printf("Comparing %v in conversation: %s", req.Models, client.currentConvoID)
+			handleCompare(client, req)
+		case "prefer":
+			log.Printf("Preferring answer %s in conversation: %s", req.MessageID, client.currentConvoID)
+			handlePrefer(client, req)
+		case "pull_model":
+			log.Printf("Pull requested for model: %s", req.Model)
+			handlePullModel(client, req)
+		case "cancel":
+			log.Printf("Cancelling generation for conversation: %s", client.currentConvoID)
+			handleCancel(client)
 		}
 	}
 }
 
+// send serialises writes to the connection, which is shared between the read
+// loop and the generations it watches. A peer that stops reading fails the
+// write after writeTimeout rather than holding up the generation.
+func (c *Client) send(resp WSResponse) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteJSON(resp)
+}
+
 func handleNewConversation(client *Client, req WSRequest) {
-	log.Printf("Creating new conversation with first message: %s", req.Message)
-	title := req.Message
-	if len(title) > 30 {
-		title = title[:30] + "..."
+	if err := req.Options.Validate(); err != nil {
+		sendError(client, "Invalid options: "+err.Error())
+		return
 	}
-	convoID, err := database.CreateConversation(title, req.Model)
+
+	log.Printf("Creating new conversation with first message: %s", req.Message)
+	title := database.TitleFromMessage(req.Message)
+	convoID, err := database.CreateConversation(client.userID, title, req.Model, req.SystemPrompt, req.Options)
 	if err != nil {
 		log.Printf("Failed to create conversation: %v", err)
 		sendError(client, "Failed to create conversation")
 		return
 	}
+	stopWatching(client)
 	client.currentConvoID = convoID
 	log.Printf("Created conversation with ID: %s", convoID)
 
 	log.Printf("Saving initial user message")
-	if err := database.AddMessage(convoID, "user", req.Message); err != nil {
+	if _, err := database.AddMessage(convoID, "user", req.Message, req.Images); err != nil {
 		log.Printf("Failed to save initial message: %v", err)
-		sendError(client, "Failed to save message")
+		// Don't leave an empty conversation behind in the sidebar.
+		database.DeleteConversation(convoID)
+		client.currentConvoID = ""
+		sendSaveError(client, err)
 		return
 	}
 
-	client.conn.WriteJSON(WSResponse{
+	client.send(WSResponse{
 		Type:    "conversation_started",
 		Content: convoID,
 	})
 
-	generateResponse(client, req, true)
+	startGeneration(client, convoID, req)
 }
 
 func handleResumeConversation(client *Client, req WSRequest) {
@@ -116,21 +201,32 @@ func handleResumeConversation(client *Client, req WSRequest) {
 		sendError(client, "Failed to resume conversation")
 		return
 	}
-	if convo == nil {
+	if convo == nil || convo.UserID != client.userID {
 		log.Printf("Conversation not found: %s", req.ConvoID)
 		sendError(client, "Conversation not found")
 		return
 	}
 
+	stopWatching(client)
+
 	// Set the conversation ID
 	client.currentConvoID = req.ConvoID
 	log.Printf("Resumed conversation: %s", req.ConvoID)
 
-	// Send success response
-	client.conn.WriteJSON(WSResponse{
+	// A reply still being written is replayed from its start and then
+	// followed live.
+	gen := generationFor(req.ConvoID)
+	resp := WSResponse{
 		Type:    "conversation_resumed",
 		Content: req.ConvoID,
-	})
+	}
+	if gen != nil {
+		resp.Data = map[string]bool{"generating": true}
+	}
+	client.send(resp)
+	if gen != nil {
+		gen.attach(client)
+	}
 }
 
 func handleMessage(client *Client, req WSRequest) {
@@ -139,163 +235,277 @@ func handleMessage(client *Client, req WSRequest) {
 		sendError(client, "No active conversation")
 		return
 	}
+	if isGenerating(client.currentConvoID) {
+		sendError(client, "A response is already being generated")
+		return
+	}
+	if err := req.Options.Validate(); err != nil {
+		sendError(client, "Invalid options: "+err.Error())
+		return
+	}
 	log.Printf("User sent Message: %s, For model: %s", req.Message,req.Model)
 	log.Printf("Saving user message to conversation: %s", client.currentConvoID)
-	if err := database.AddMessage(client.currentConvoID, "user", req.Message); err != nil {
+	if _, err := database.AddMessage(client.currentConvoID, "user", req.Message, req.Images); err != nil {
 		log.Printf("Failed to save user message: %v", err)
-		sendError(client, "Failed to save message")
+		sendSaveError(client, err)
 		return
 	}
 
-	generateResponse(client, req, false)
+	startGeneration(client, client.currentConvoID, req)
 }
 
-func generateResponse(client *Client, req WSRequest, isFirstMessage bool) {
-	log.Printf("Starting response generation - First Message: %v, ConvoID: %s", isFirstMessage, client.currentConvoID)
+// handleRegenerate streams a new reply to the last prompt as a sibling branch
+// of the current one. Model and Options on the request, if set, replace the
+// ones used for the previous take.
+func handleRegenerate(client *Client, req WSRequest) {
+	if client.currentConvoID == "" {
+		log.Printf("Received regenerate without active conversation")
+		sendError(client, "No active conversation")
+		return
+	}
+	if isGenerating(client.currentConvoID) {
+		sendError(client, "A response is already being generated")
+		return
+	}
+	if err := req.Options.Validate(); err != nil {
+		sendError(client, "Invalid options: "+err.Error())
+		return
+	}
 
-	var ollamaMessages []ollama.Message
+	found, err := rewindToPrompt(client.currentConvoID)
+	if err != nil {
+		log.Printf("Failed to rewind active branch: %v", err)
+		sendError(client, "Failed to regenerate response")
+		return
+	}
+	if !found {
+		sendError(client, "Nothing to regenerate")
+		return
+	}
 
-	if !isFirstMessage {
-		log.Printf("Fetching conversation history for ID: %s", client.currentConvoID)
-		messages, err := database.GetMessagesByConversationID(client.currentConvoID)
-		if err != nil {
-			log.Printf("Error fetching history: %v", err)
-			sendError(client, "Failed to get conversation history")
-			return
-		}
-		log.Printf("Found %d previous messages", len(messages))
+	startGeneration(client, client.currentConvoID, req)
+}
 
-		ollamaMessages = make([]ollama.Message, len(messages))
-		for i, msg := range messages {
-			ollamaMessages[i] = ollama.Message{
-				Role:    msg.Role,
-				Content: msg.RawContent,
-			}
+// rewindToPrompt moves the active leaf back to the last user message, so the
+// next reply becomes a sibling of the current one, dropping any tool calls
+// made along the way. A failed or empty generation leaves the prompt as the
+// leaf already, in which case it is simply answered again. It reports false
+// for a conversation without messages.
+func rewindToPrompt(convoID string) (bool, error) {
+	last, err := database.GetActiveLeaf(convoID)
+	if err != nil {
+		return false, err
+	}
+	if last == nil {
+		return false, nil
+	}
+	if last.Role == database.RoleUser {
+		return true, nil
+	}
+
+	branch, err := database.GetBranchMessages(convoID, &last.ID)
+	if err != nil {
+		return false, err
+	}
+	var prompt *string
+	for i := len(branch) - 1; i >= 0; i-- {
+		if branch[i].Role == database.RoleUser {
+			prompt = &branch[i].ID
+			break
 		}
 	}
+	return true, database.SetActiveLeaf(convoID, prompt)
+}
 
-	// Add current message
-	ollamaMessages = append(ollamaMessages, ollama.Message{
-		Role:    "user",
-		Content: req.Message,
-	})
-	log.Printf("Sending request to Ollama with %d messages", len(ollamaMessages))
+// handleEditMessage branches the conversation at an earlier user message: the
+// edited text becomes a sibling of the original and is answered afresh.
+func handleEditMessage(client *Client, req WSRequest) {
+	if client.currentConvoID == "" {
+		log.Printf("Received edit without active conversation")
+		sendError(client, "No active conversation")
+		return
+	}
+	if req.Message == "" {
+		sendError(client, "Edited message is empty")
+		return
+	}
+	if isGenerating(client.currentConvoID) {
+		sendError(client, "A response is already being generated")
+		return
+	}
+	if err := req.Options.Validate(); err != nil {
+		sendError(client, "Invalid options: "+err.Error())
+		return
+	}
 
-	ollamaClient := ollama.NewClient(config.Get().OllamaURL)
-	resp, err := ollamaClient.GenerateStream(req.Model, ollamaMessages)
+	original, err := database.GetMessageByID(req.MessageID)
 	if err != nil {
-		log.Printf("Ollama request failed: %v", err)
-		sendError(client, "Failed to generate response")
+		log.Printf("Failed to fetch message %s: %v", req.MessageID, err)
+		sendError(client, "Failed to edit message")
+		return
+	}
+	if original == nil || original.ConversationID != client.currentConvoID {
+		sendError(client, "Message not found")
 		return
 	}
-	defer resp.Body.Close()
-
-	scanner := bufio.NewScanner(resp.Body)
-	var fullResponse strings.Builder
-	var thinking strings.Builder
-	var rawContent strings.Builder
-	isThinking := false
-	var thinkStartTime time.Time
-	var thinkingDuration float64
 
-	log.Println("Starting to process Ollama stream")
-	for scanner.Scan() {
-		var genResp ollama.GenerateResponse
-		if err := json.Unmarshal(scanner.Bytes(), &genResp); err != nil {
-			log.Printf("Error unmarshaling response chunk: %v", err)
-			continue
+	edited, err := database.EditMessage(req.MessageID, req.Message)
+	if err != nil {
+		log.Printf("Failed to edit message: %v", err)
+		if errors.Is(err, database.ErrNotUserMessage) {
+			sendError(client, "Only user messages can be edited")
+			return
 		}
+		sendError(client, "Failed to edit message")
+		return
+	}
 
-		rawContent.WriteString(genResp.Response)
+	client.send(WSResponse{
+		Type:    "message_edited",
+		Content: edited.ID,
+	})
 
-		// Process the response chunk first
-		if strings.Contains(genResp.Response, "<think>") {
-			log.Println("Entering thinking mode")
-			isThinking = true
-			thinkStartTime = time.Now()
+	startGeneration(client, client.currentConvoID, req)
+}
 
-			// Notify client that thinking is starting
-			client.conn.WriteJSON(WSResponse{
-				Type:    "thinking_start",
-				Content: "",
-			})
+// handleCompare streams answers from several models at once. With a message
+// it is sent first, like "message"; without one the last prompt is answered
+// again, like "regenerate". Each answer becomes a sibling branch.
+func handleCompare(client *Client, req WSRequest) {
+	if client.currentConvoID == "" {
+		log.Printf("Received compare without active conversation")
+		sendError(client, "No active conversation")
+		return
+	}
+	if isGenerating(client.currentConvoID) {
+		sendError(client, "A response is already being generated")
+		return
+	}
+	if err := req.Options.Validate(); err != nil {
+		sendError(client, "Invalid options: "+err.Error())
+		return
+	}
 
-			continue
-		}
-		if strings.Contains(genResp.Response, "</think>") {
-			log.Println("Exiting thinking mode")
-			isThinking = false
-			thinkingDuration = time.Since(thinkStartTime).Seconds()
-
-			client.conn.WriteJSON(WSResponse{
-				Type:    "thinking_end",
-				Content: thinking.String(),
-			})
-			continue
+	var models []string
+	for _, model := range req.Models {
+		if model != "" && !slices.Contains(models, model) {
+			models = append(models, model)
 		}
+	}
+	if len(models) < 2 || len(models) > maxCompareModels {
+		sendError(client, fmt.Sprintf("Compare needs between 2 and %d different models", maxCompareModels))
+		return
+	}
+	req.Models = models
 
-		if isThinking {
-			thinking.WriteString(genResp.Response)
-			// Stream thinking content too
-			client.conn.WriteJSON(WSResponse{
-				Type:    "thinking_chunk",
-				Content: genResp.Response,
-			})
-		} else {
-			fullResponse.WriteString(genResp.Response)
-			client.conn.WriteJSON(WSResponse{
-				Type:    "response_chunk",
-				Content: genResp.Response,
-			})
+	if req.Message != "" {
+		if _, err := database.AddMessage(client.currentConvoID, "user", req.Message, req.Images); err != nil {
+			log.Printf("Failed to save user message: %v", err)
+			sendSaveError(client, err)
+			return
 		}
-
-		// Only break after processing the response
-		if genResp.Done {
-			log.Printf("Full response so far: %s", fullResponse.String())
-			log.Println("Received done signal from Ollama")
-			break
+	} else {
+		found, err := rewindToPrompt(client.currentConvoID)
+		if err != nil {
+			log.Printf("Failed to rewind active branch: %v", err)
+			sendError(client, "Failed to compare models")
+			return
+		}
+		if !found {
+			sendError(client, "Nothing to compare")
+			return
 		}
 	}
 
-	// Save final response
-	log.Println("Stream complete, saving response")
-	finalResponse := fullResponse.String()
-	if finalResponse != "" {
-		err := database.AddMessageWithThinking(
-			client.currentConvoID,
-			"assistant",
-			finalResponse,
-			rawContent.String(),
-			pointerString(thinking.String()),
-			&thinkingDuration,
-		)
-		if err != nil {
-			log.Printf("Error saving response: %v", err)
-			sendError(client, "Failed to save response")
+	startGeneration(client, client.currentConvoID, req)
+}
+
+// handlePrefer records which answer of a comparison the user liked best and
+// continues the conversation from it.
+func handlePrefer(client *Client, req WSRequest) {
+	if client.currentConvoID == "" {
+		log.Printf("Received prefer without active conversation")
+		sendError(client, "No active conversation")
+		return
+	}
+	if isGenerating(client.currentConvoID) {
+		sendError(client, "A response is already being generated")
+		return
+	}
+
+	message, err := database.GetMessageByID(req.MessageID)
+	if err != nil {
+		log.Printf("Failed to fetch message %s: %v", req.MessageID, err)
+		sendError(client, "Failed to prefer answer")
+		return
+	}
+	if message == nil || message.ConversationID != client.currentConvoID {
+		sendError(client, "Message not found")
+		return
+	}
+
+	comparison, err := database.PreferAnswer(message.ID)
+	if err != nil {
+		log.Printf("Failed to prefer answer: %v", err)
+		if errors.Is(err, database.ErrNotCompared) {
+			sendError(client, "Only answers of a comparison can be preferred")
 			return
 		}
-		log.Printf("Response saved successfully for conversation: %s", client.currentConvoID)
-	} else {
-		log.Printf("Warning: Empty response received for conversation: %s", client.currentConvoID)
+		sendError(client, "Failed to prefer answer")
+		return
+	}
+	if err := database.SwitchBranch(client.currentConvoID, message.ID); err != nil {
+		log.Printf("Failed to switch to preferred answer: %v", err)
 	}
 
-	log.Printf("Response generation complete for conversation: %s", client.currentConvoID)
-	client.conn.WriteJSON(WSResponse{
-		Type:    "done",
-		Content: "",
+	client.send(WSResponse{
+		Type:    "answer_preferred",
+		Content: message.ID,
+		Model:   message.Model,
+		Data:    map[string]string{"comparison_id": comparison.ID},
 	})
 }
 
-func pointerString(s string) *string {
-	if s == "" {
-		return nil
+func handlePullModel(client *Client, req WSRequest) {
+	if !client.manageModels {
+		sendError(client, "Not allowed to manage models")
+		return
+	}
+	if req.Model == "" {
+		sendError(client, "No model given")
+		return
+	}
+	if err := StartPull(req.Model); err != nil {
+		sendError(client, "Model is already being pulled")
+	}
+}
+
+func handleCancel(client *Client) {
+	gen := generationFor(client.currentConvoID)
+	if gen == nil {
+		log.Printf("Cancel requested but no generation is in progress")
+		return
+	}
+	gen.cancel()
+}
+
+func sendSaveError(client *Client, err error) {
+	if errors.Is(err, database.ErrInvalidAttachment) {
+		sendError(client, "Invalid image attachment")
+		return
 	}
-	return &s
+	sendError(client, "Failed to save message")
+}
+
+// sender is where events go: a client's connection, or a generation passing
+// them on to the clients watching it.
+type sender interface {
+	send(resp WSResponse) error
 }
 
-func sendError(client *Client, message string) {
+func sendError(to sender, message string) {
 	log.Printf("Sending error to client: %s", message)
-	client.conn.WriteJSON(WSResponse{
+	to.send(WSResponse{
 		Type:    "error",
 		Content: message,
 	})
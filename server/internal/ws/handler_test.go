@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRewindToPrompt(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	convoID, err := database.CreateConversation("", "regenerate", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+
+	add := func(role, content string) *database.Message {
+		t.Helper()
+		message, err := database.AddMessage(convoID, role, content, nil)
+		if err != nil {
+			t.Fatalf("failed to add message: %v", err)
+		}
+		return message
+	}
+	leaf := func() string {
+		t.Helper()
+		message, err := database.GetActiveLeaf(convoID)
+		if err != nil || message == nil {
+			t.Fatalf("expected an active leaf, got %v", err)
+		}
+		return message.ID
+	}
+
+	if found, err := rewindToPrompt(convoID); found || err != nil {
+		t.Errorf("expected nothing to regenerate in an empty conversation, got %t, %v", found, err)
+	}
+
+	// The last reply, tool calls included, is rewound to its prompt.
+	add(database.RoleUser, "hi")
+	add(database.RoleAssistant, "hello")
+	prompt := add(database.RoleUser, "what time is it?")
+	add(database.RoleAssistant, "")
+	add(database.RoleTool, "12:00")
+	add(database.RoleAssistant, "It is noon.")
+	if found, err := rewindToPrompt(convoID); !found || err != nil {
+		t.Fatalf("expected the reply to be regenerated, got %t, %v", found, err)
+	}
+	if got := leaf(); got != prompt.ID {
+		t.Errorf("expected the active leaf to be the last prompt %s, got %s", prompt.ID, got)
+	}
+
+	// A prompt left unanswered, by a failed generation, is answered again.
+	if found, err := rewindToPrompt(convoID); !found || err != nil {
+		t.Fatalf("expected the prompt to be answered again, got %t, %v", found, err)
+	}
+	if got := leaf(); got != prompt.ID {
+		t.Errorf("expected the active leaf to stay at the prompt %s, got %s", prompt.ID, got)
+	}
+}
+
+func TestRegenerateEmptyConversation(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	convoID, err := database.CreateConversation("", "empty", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+
+	client, conn := connect(t)
+	client.currentConvoID = convoID
+	handleRegenerate(client, WSRequest{Type: "regenerate"})
+
+	got := receive(t, conn, 1)
+	if got[0].Type != "error" || got[0].Content != "Nothing to regenerate" {
+		t.Errorf("expected a \"Nothing to regenerate\" error, got %+v", got[0])
+	}
+	if isGenerating(convoID) {
+		t.Errorf("expected no generation to start")
+	}
+}
+
+func TestUpgradeChecksOrigin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dial := func(origin string) error {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := dial(ts.URL); err != nil {
+		t.Errorf("expected a page of this server to connect, got %v", err)
+	}
+	if err := dial(""); err != nil {
+		t.Errorf("expected a client without an origin to connect, got %v", err)
+	}
+	if err := dial("https://evil.example"); err == nil {
+		t.Errorf("expected another site to be refused")
+	}
+}
+
+func TestEditMessageRejectsEmpty(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	convoID, err := database.CreateConversation("", "edit", "llama3", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	prompt, err := database.AddMessage(convoID, database.RoleUser, "hi", nil)
+	if err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+
+	client, conn := connect(t)
+	client.currentConvoID = convoID
+	handleEditMessage(client, WSRequest{Type: "edit_message", MessageID: prompt.ID})
+
+	got := receive(t, conn, 1)
+	if got[0].Type != "error" || got[0].Content != "Edited message is empty" {
+		t.Errorf("expected an empty edit to be refused, got %+v", got[0])
+	}
+	if siblings, _ := database.GetSiblings(prompt.ID); len(siblings) != 1 {
+		t.Errorf("expected no edit to be saved, got %d siblings", len(siblings))
+	}
+}
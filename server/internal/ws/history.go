@@ -0,0 +1,334 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+)
+
+// Token counts are estimated, as Ollama has no tokenize endpoint. Four
+// characters per token is close for English prose and errs on the safe
+// side for code.
+const (
+	charsPerToken   = 4
+	messageOverhead = 4   // role markers and separators added by the chat template
+	imageTokens     = 768 // vision models use a few hundred tokens per image
+)
+
+const (
+	summaryTimeout   = 2 * time.Minute
+	summaryMaxTokens = 512
+)
+
+const summaryPrompt = "You keep a running summary of a conversation between a user and an assistant. " +
+	"Update the summary with the new messages below. Keep facts, decisions, names, numbers and open questions; " +
+	"drop pleasantries. Write at most a few short paragraphs and reply with the summary only."
+
+// contextTrim is sent with the "context_trimmed" event when older messages
+// were left out of the request to fit the model's context.
+type contextTrim struct {
+	OmittedMessages int  `json:"omitted_messages"`
+	Summarized      bool `json:"summarized"` // the omitted messages were replaced by a summary
+	EstimatedTokens int  `json:"estimated_tokens"`
+	Budget          int  `json:"budget"`
+}
+
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+func messageTokens(msg database.Message) int {
+	tokens := messageOverhead + estimateTokens(msg.RawContent) + len(msg.Images)*imageTokens
+	for _, call := range msg.ToolCalls {
+		tokens += estimateTokens(call.Function.Name) + estimateTokens(string(call.Function.Arguments))
+	}
+	return tokens
+}
+
+// fixedTokens estimates what every request carries besides the history: the
+// system prompt and the tool definitions.
+func fixedTokens(convo *database.Conversation, toolDefinitions []ollama.Tool) int {
+	tokens := 0
+	if convo.SystemPrompt != "" {
+		tokens += messageOverhead + estimateTokens(convo.SystemPrompt)
+	}
+	if len(toolDefinitions) > 0 {
+		definitions, _ := json.Marshal(toolDefinitions)
+		tokens += estimateTokens(string(definitions))
+	}
+	return tokens
+}
+
+// historyBudget returns how many tokens a request to model may use. With
+// num_ctx set that is the context minus room for the reply: num_predict when
+// it is set, a quarter of the context otherwise. Without it the configured
+// budget applies to Ollama, whose default window is small, where zero means
+// unlimited. Other providers never receive num_ctx and manage their own
+// context, so their history is sent whole.
+func historyBudget(model string, options *ollama.Options) int {
+	if p, _ := provider.Resolve(model); p.Name() != provider.OllamaName {
+		return 0
+	}
+	if options == nil || options.NumCtx == nil {
+		return config.Get().ContextBudget
+	}
+
+	numCtx := *options.NumCtx
+	reserve := numCtx / 4
+	if options.NumPredict != nil && *options.NumPredict > 0 && *options.NumPredict < numCtx {
+		reserve = *options.NumPredict
+	}
+	return numCtx - reserve
+}
+
+// keepFrom returns the index of the oldest message to keep so the rest fit in
+// budget. Kept history always starts at a user message so replies and tool
+// results aren't cut off from their prompt, and the latest user message is
+// kept even when it doesn't fit on its own.
+func keepFrom(messages []database.Message, tokens []int, budget int) int {
+	last := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == database.RoleUser {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return 0
+	}
+
+	keep := last
+	total := sum(tokens[last:])
+	for i := last - 1; i >= 0; i-- {
+		total += tokens[i]
+		if total > budget {
+			break
+		}
+		if messages[i].Role == database.RoleUser {
+			keep = i
+		}
+	}
+	return keep
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+// fitHistory leaves out the oldest turns of branch that don't fit in budget
+// next to the fixed part of the request, summarising them first when that is
+// enabled. It returns the messages to send, the summary standing in for the
+// others, and what was trimmed, which is nil when everything fit.
+func fitHistory(ctx context.Context, gen *generation, convo *database.Conversation,
+	model string, branch []database.Message, budget, fixed int) ([]database.Message, string, *contextTrim) {
+	if budget <= 0 {
+		return branch, "", nil
+	}
+
+	available := budget - fixed
+	tokens := make([]int, len(branch))
+	for i, msg := range branch {
+		tokens[i] = messageTokens(msg)
+	}
+	if sum(tokens) <= available {
+		return branch, "", nil
+	}
+
+	keep, summary := 0, ""
+	if config.Get().SummarizeHistory {
+		keep, summary = summarizeHistory(ctx, gen, convo, model, branch, tokens, available)
+	}
+	if summary == "" {
+		keep = keepFrom(branch, tokens, available)
+	}
+	if keep == 0 {
+		return branch, "", nil
+	}
+
+	estimated := fixed + sum(tokens[keep:])
+	if summary != "" {
+		estimated += messageOverhead + estimateTokens(summary)
+	}
+	return branch[keep:], summary, &contextTrim{
+		OmittedMessages: keep,
+		Summarized:      summary != "",
+		EstimatedTokens: estimated,
+		Budget:          budget,
+	}
+}
+
+// summarizeHistory returns the index of the oldest message to keep and a
+// summary of everything before it. Summaries are cached by the last message
+// they cover and built on the previous one, so only the turns that dropped
+// out since are sent to the model. It returns an empty summary when it can't
+// produce one and the caller should simply drop the old turns.
+func summarizeHistory(ctx context.Context, gen *generation, convo *database.Conversation,
+	model string, branch []database.Message, tokens []int, available int) (int, string) {
+	summaries, err := database.GetSummaries(convo.ID)
+	if err != nil {
+		log.Printf("Failed to load summaries: %v", err)
+		return 0, ""
+	}
+
+	covered, previous := -1, ""
+	for i := len(branch) - 1; i >= 0; i-- {
+		if summary, ok := summaries[branch[i].ID]; ok {
+			covered, previous = i, summary.Content
+			break
+		}
+	}
+
+	// The cached summary is enough if what follows it still fits.
+	if covered >= 0 && covered+1 < len(branch) && branch[covered+1].Role == database.RoleUser &&
+		messageOverhead+estimateTokens(previous)+sum(tokens[covered+1:]) <= available {
+		return covered + 1, previous
+	}
+
+	// Cut deeper than needed so the next few turns fit without summarising
+	// again on every message.
+	keep := keepFrom(branch, tokens, available/2)
+	if keep <= covered+1 {
+		return 0, ""
+	}
+
+	gen.send(WSResponse{
+		Type:    "summarizing",
+		Content: "",
+	})
+	summary, err := summarize(ctx, model, previous, branch[covered+1:keep])
+	if err != nil {
+		log.Printf("Failed to summarize history of conversation %s: %v", convo.ID, err)
+		return 0, ""
+	}
+	if err := database.SaveSummary(convo.ID, branch[keep-1].ID, summary); err != nil {
+		log.Printf("Failed to cache summary: %v", err)
+	}
+
+	// A long summary can leave too little room for the turns kept after it.
+	room := available - messageOverhead - estimateTokens(summary)
+	if sum(tokens[keep:]) > room {
+		keep += keepFrom(branch[keep:], tokens[keep:], room)
+	}
+	return keep, summary
+}
+
+// summarize asks the model to fold messages into the previous summary.
+func summarize(ctx context.Context, model, previous string, messages []database.Message) (string, error) {
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Summary so far:\n" + previous + "\n\nNew messages:\n")
+	}
+	for _, msg := range messages {
+		switch {
+		case msg.Role == database.RoleTool:
+			fmt.Fprintf(&transcript, "Tool %s returned: %s\n", msg.ToolName, excerpt(msg.Content))
+		case len(msg.ToolCalls) > 0:
+			for _, call := range msg.ToolCalls {
+				fmt.Fprintf(&transcript, "Assistant called %s with %s\n", call.Function.Name, call.Function.Arguments)
+			}
+		case msg.Role == database.RoleAssistant:
+			fmt.Fprintf(&transcript, "Assistant: %s\n", excerpt(msg.Content))
+		default:
+			fmt.Fprintf(&transcript, "User: %s\n", excerpt(msg.Content))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	maxTokens := summaryMaxTokens
+	resp, err := provider.Chat(ctx, ollama.ChatRequest{
+		Model: model,
+		Messages: []ollama.Message{
+			{Role: database.RoleSystem, Content: summaryPrompt},
+			{Role: database.RoleUser, Content: transcript.String()},
+		},
+		Options: &ollama.Options{NumPredict: &maxTokens},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	summary := strings.TrimSpace(thinkBlock.ReplaceAllString(resp.Message.Content, ""))
+	if summary == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return summary, nil
+}
+
+// buildHistory assembles the messages sent to Ollama: the conversation's system
+// prompt, if any, and the summary of trimmed turns, followed by the given
+// turns with their images and tool calls. The user message being answered has
+// already been persisted, so it is included.
+func buildHistory(convo *database.Conversation, summary string, messages []database.Message) ([]ollama.Message, error) {
+	log.Printf("Building history from %d messages", len(messages))
+
+	var imageIDs []string
+	for _, msg := range messages {
+		imageIDs = append(imageIDs, msg.Images...)
+	}
+	images, err := database.GetAttachmentData(convo.ID, imageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ollamaMessages := make([]ollama.Message, 0, len(messages)+2)
+	if convo.SystemPrompt != "" {
+		ollamaMessages = append(ollamaMessages, ollama.Message{
+			Role:    database.RoleSystem,
+			Content: convo.SystemPrompt,
+		})
+	}
+	if summary != "" {
+		ollamaMessages = append(ollamaMessages, ollama.Message{
+			Role:    database.RoleSystem,
+			Content: "Summary of the earlier part of this conversation:\n\n" + summary,
+		})
+	}
+	for _, msg := range messages {
+		ollamaMsg := ollama.Message{
+			Role:      msg.Role,
+			Content:   msg.RawContent,
+			ToolCalls: msg.ToolCalls,
+			ToolName:  msg.ToolName,
+		}
+		for _, id := range msg.Images {
+			if data, ok := images[id]; ok {
+				ollamaMsg.Images = append(ollamaMsg.Images, base64.StdEncoding.EncodeToString(data))
+			}
+		}
+		ollamaMessages = append(ollamaMessages, ollamaMsg)
+	}
+	return ollamaMessages, nil
+}
+
+// firstPrompt returns the user message when the branch holds exactly one,
+// which is when a conversation gets its first reply.
+func firstPrompt(branch []database.Message) (string, bool) {
+	var prompt string
+	count := 0
+	for _, msg := range branch {
+		switch msg.Role {
+		case database.RoleUser:
+			prompt = msg.Content
+			count++
+		case database.RoleAssistant:
+			return "", false
+		}
+	}
+	return prompt, count == 1
+}
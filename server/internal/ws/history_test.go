@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	cases := map[string]int{
+		"":         0,
+		"abcd":     1,
+		"abcde":    2,
+		"ünïcödé!": 2,
+	}
+	for input, expected := range cases {
+		if got := estimateTokens(input); got != expected {
+			t.Errorf("estimateTokens(%q): expected %d, got %d", input, expected, got)
+		}
+	}
+}
+
+func TestKeepFrom(t *testing.T) {
+	roles := []string{
+		database.RoleUser, database.RoleAssistant, // 0, 1
+		database.RoleUser, database.RoleAssistant, database.RoleTool, database.RoleAssistant, // 2-5
+		database.RoleUser, // 6
+	}
+	messages := make([]database.Message, len(roles))
+	tokens := make([]int, len(roles))
+	for i, role := range roles {
+		messages[i] = database.Message{Role: role}
+		tokens[i] = 10
+	}
+
+	cases := map[int]int{
+		1000: 0, // everything fits
+		60:   2, // 6 messages fit, but keeping must start at a user message
+		45:   6, // only the latest prompt fits
+		5:    6, // the latest prompt is kept even when it doesn't fit
+	}
+	for budget, expected := range cases {
+		if got := keepFrom(messages, tokens, budget); got != expected {
+			t.Errorf("keepFrom with budget %d: expected %d, got %d", budget, expected, got)
+		}
+	}
+}
+
+func TestHistoryBudget(t *testing.T) {
+	config.Get().ContextBudget = 3072
+
+	numCtx, numPredict, unlimited := 8192, 1000, -1
+	cases := []struct {
+		options  *ollama.Options
+		expected int
+	}{
+		{nil, 3072},
+		{&ollama.Options{}, 3072},
+		{&ollama.Options{NumCtx: &numCtx}, 6144},
+		{&ollama.Options{NumCtx: &numCtx, NumPredict: &numPredict}, 7192},
+		{&ollama.Options{NumCtx: &numCtx, NumPredict: &unlimited}, 6144},
+	}
+	for _, c := range cases {
+		if got := historyBudget("llama3", c.options); got != c.expected {
+			t.Errorf("historyBudget(%+v): expected %d, got %d", c.options, c.expected, got)
+		}
+	}
+
+	// OpenAI-compatible providers are sent neither num_ctx nor a trimmed
+	// history.
+	if err := provider.Register(provider.NewOpenAI("local", "http://localhost:1", "")); err != nil {
+		t.Fatalf("failed to register provider: %v", err)
+	}
+	defer provider.Unregister("local")
+	for _, options := range []*ollama.Options{nil, {NumCtx: &numCtx}} {
+		if got := historyBudget("local/gpt-4o", options); got != 0 {
+			t.Errorf("historyBudget for another provider with %+v: expected 0, got %d", options, got)
+		}
+	}
+}
+
+func TestSystemPromptSurvivesTrimming(t *testing.T) {
+	cfg := config.Get()
+	cfg.DBPath = t.TempDir() + "/chat.db"
+	cfg.SummarizeHistory = false
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+
+	convo := &database.Conversation{ID: "convo", SystemPrompt: strings.Repeat("Be brief. ", 10)}
+	turn := strings.Repeat("word ", 20)
+	var branch []database.Message
+	for i, role := range []string{database.RoleUser, database.RoleAssistant, database.RoleUser, database.RoleAssistant, database.RoleUser} {
+		branch = append(branch, database.Message{ID: fmt.Sprint(i), Role: role, Content: turn, RawContent: turn})
+	}
+
+	// Every turn would fit on its own, but the system prompt takes its share
+	// of the budget, so the oldest exchange is dropped rather than the prompt.
+	fixed := fixedTokens(convo, nil)
+	budget := sum(messageTokensOf(branch)) + fixed/2
+	kept, summary, trim := fitHistory(context.Background(), nil, convo, "llama3", branch, budget, fixed)
+	if trim == nil || len(kept) != 3 || kept[0].ID != "2" || summary != "" {
+		t.Fatalf("expected the oldest exchange to be trimmed, got %d messages and %+v", len(kept), trim)
+	}
+
+	history, err := buildHistory(convo, summary, kept)
+	if err != nil {
+		t.Fatalf("failed to build history: %v", err)
+	}
+	if len(history) != 4 || history[0].Role != database.RoleSystem || history[0].Content != convo.SystemPrompt {
+		t.Fatalf("expected the system prompt first, got %+v", history)
+	}
+	if history[1].Role != database.RoleUser {
+		t.Errorf("expected the kept turns after the system prompt, got %+v", history[1])
+	}
+
+	history, err = buildHistory(convo, "earlier turns", kept)
+	if err != nil || history[0].Content != convo.SystemPrompt || !strings.Contains(history[1].Content, "earlier turns") {
+		t.Errorf("expected the system prompt before the summary, got %+v, %v", history, err)
+	}
+}
+
+func TestBuildHistoryImages(t *testing.T) {
+	config.Get().DBPath = t.TempDir() + "/chat.db"
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	convoID, err := database.CreateConversation("", "images", "llava", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	otherID, err := database.CreateConversation("", "other", "llava", "", nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+
+	upload := func(data string) string {
+		t.Helper()
+		attachment, err := database.CreateAttachment("", "image.png", "image/png", []byte(data))
+		if err != nil {
+			t.Fatalf("failed to create attachment: %v", err)
+		}
+		return attachment.ID
+	}
+	first, second, elsewhere := upload("first"), upload("second"), upload("elsewhere")
+	if _, err := database.AddMessage(otherID, database.RoleUser, "mine", []string{elsewhere}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	if _, err := database.AddMessage(convoID, database.RoleUser, "compare these", []string{first, second}); err != nil {
+		t.Fatalf("failed to add message: %v", err)
+	}
+	convo, err := database.GetConversationByID(convoID)
+	if err != nil {
+		t.Fatalf("failed to get conversation: %v", err)
+	}
+
+	// An image of another conversation named by a message is left out.
+	convo.Messages[0].Images = append(convo.Messages[0].Images, elsewhere)
+	history, err := buildHistory(convo, "", convo.Messages)
+	if err != nil {
+		t.Fatalf("failed to build history: %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("expected the prompt alone, got %+v", history)
+	}
+	expected := []string{base64.StdEncoding.EncodeToString([]byte("first")), base64.StdEncoding.EncodeToString([]byte("second"))}
+	if images := history[0].Images; len(images) != 2 || images[0] != expected[0] || images[1] != expected[1] {
+		t.Errorf("expected the images base64-encoded in order, got %v", images)
+	}
+}
+
+func messageTokensOf(messages []database.Message) []int {
+	tokens := make([]int, len(messages))
+	for i, msg := range messages {
+		tokens[i] = messageTokens(msg)
+	}
+	return tokens
+}
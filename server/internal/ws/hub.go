@@ -0,0 +1,52 @@
+package ws
+
+import "sync"
+
+// The hub tracks every connected client so server-wide events, such as model
+// pull progress, reach all open tabs allowed to see them rather than just the
+// one that asked.
+var (
+	clientsMu sync.Mutex
+	clients   = map[*Client]struct{}{}
+)
+
+func register(client *Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clients[client] = struct{}{}
+}
+
+func unregister(client *Client) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	delete(clients, client)
+}
+
+// notifyManagers sends resp to every connected client that may manage
+// models. Write errors are ignored; a dead connection is removed when its
+// read loop exits.
+func notifyManagers(resp WSResponse) {
+	sendTo(resp, func(client *Client) bool { return client.manageModels })
+}
+
+// notify sends resp to every connected client of the user. Events that
+// outlive a generation, such as a new title, go to wherever the user is now
+// rather than to the connection that started it.
+func notify(userID string, resp WSResponse) {
+	sendTo(resp, func(client *Client) bool { return client.userID == userID })
+}
+
+func sendTo(resp WSResponse, to func(*Client) bool) {
+	clientsMu.Lock()
+	targets := make([]*Client, 0, len(clients))
+	for client := range clients {
+		if to(client) {
+			targets = append(targets, client)
+		}
+	}
+	clientsMu.Unlock()
+
+	for _, client := range targets {
+		client.send(resp)
+	}
+}
@@ -0,0 +1,27 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyManagers(t *testing.T) {
+	admin, adminConn := connect(t)
+	admin.manageModels = true
+	user, userConn := connect(t)
+	register(admin)
+	defer unregister(admin)
+	register(user)
+	defer unregister(user)
+
+	notifyManagers(WSResponse{Type: "pull_done", Content: "llama3"})
+
+	if got := receive(t, adminConn, 1); got[0].Type != "pull_done" {
+		t.Errorf("expected the pull event, got %+v", got)
+	}
+	userConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var resp WSResponse
+	if err := userConn.ReadJSON(&resp); err == nil {
+		t.Errorf("expected a client without model management to get nothing, got %+v", resp)
+	}
+}
@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+)
+
+// ErrPullInProgress is returned when the same model is already being pulled.
+var ErrPullInProgress = errors.New("model is already being pulled")
+
+// Ollama reports progress for every chunk it downloads; forwarding all of
+// them would flood the sockets, so updates within a layer are throttled.
+const pullProgressInterval = 250 * time.Millisecond
+
+var (
+	pullsMu sync.Mutex
+	pulls   = map[string]struct{}{}
+)
+
+// StartPull downloads a model in the background and sends its progress to
+// every connected client that may manage models as "pull_progress" events,
+// followed by either "pull_done" or "pull_failed". Content always names the
+// model. With several Ollama hosts, the model goes to the first healthy one.
+func StartPull(model string) error {
+	pullsMu.Lock()
+	defer pullsMu.Unlock()
+
+	if _, ok := pulls[model]; ok {
+		return ErrPullInProgress
+	}
+	pulls[model] = struct{}{}
+
+	go runPull(model)
+	return nil
+}
+
+func runPull(model string) {
+	defer func() {
+		pullsMu.Lock()
+		delete(pulls, model)
+		pullsMu.Unlock()
+	}()
+
+	log.Printf("Pulling model: %s", model)
+
+	var lastStatus string
+	var lastSent time.Time
+
+	ollamaClient := provider.OllamaClient(context.Background(), "")
+	err := ollamaClient.PullModel(context.Background(), model, func(progress ollama.PullProgress) {
+		if progress.Status == lastStatus && time.Since(lastSent) < pullProgressInterval {
+			return
+		}
+		lastStatus = progress.Status
+		lastSent = time.Now()
+
+		notifyManagers(WSResponse{
+			Type:    "pull_progress",
+			Content: model,
+			Data:    progress,
+		})
+	})
+	if err != nil {
+		log.Printf("Pull of %s failed: %v", model, err)
+		notifyManagers(WSResponse{
+			Type:    "pull_failed",
+			Content: model,
+			Data:    map[string]string{"error": err.Error()},
+		})
+		return
+	}
+
+	log.Printf("Pulled model: %s", model)
+	provider.RefreshOllama()
+	notifyManagers(WSResponse{
+		Type:    "pull_done",
+		Content: model,
+	})
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"ollama-tiny-chat/server/internal/config"
+	"ollama-tiny-chat/server/internal/database"
+	"ollama-tiny-chat/server/internal/ollama"
+	"ollama-tiny-chat/server/internal/provider"
+)
+
+const (
+	titleTimeout      = 2 * time.Minute
+	maxTitleRunes     = 60
+	titleExcerptRunes = 1000
+)
+
+const titlePrompt = "You name chat conversations. Reply with a short, specific title of at most six words for the conversation below. " +
+	"Reply with the title only: no quotes, no punctuation at the end, no explanation."
+
+var thinkBlock = regexp.MustCompile(`(?s)<think>.*?(</think>|$)`)
+
+// generateTitle asks a model for a concise title after the first exchange of
+// a conversation and, unless the user renamed it meanwhile, stores it and
+// tells the user's clients with a "title_updated" event. It runs in the background
+// and only logs failures; the truncated first message stays as the title.
+func generateTitle(convo *database.Conversation, model, prompt, reply string) {
+	// Only replace the title derived from the first message; anything else
+	// was chosen by the user or generated already.
+	if convo.Title != database.TitleFromMessage(prompt) {
+		return
+	}
+
+	cfg := config.Get()
+	if cfg.TitleModel != "" {
+		model = cfg.TitleModel
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+
+	resp, err := provider.Chat(ctx, ollama.ChatRequest{
+		Model: model,
+		Messages: []ollama.Message{
+			{Role: database.RoleSystem, Content: titlePrompt},
+			{Role: database.RoleUser, Content: "User: " + excerpt(prompt) + "\n\nAssistant: " + excerpt(reply)},
+		},
+	})
+	if err != nil {
+		log.Printf("Title generation failed for conversation %s: %v", convo.ID, err)
+		return
+	}
+
+	title := cleanTitle(resp.Message.Content)
+	if title == "" {
+		log.Printf("Title generation returned nothing usable for conversation %s", convo.ID)
+		return
+	}
+
+	replaced, err := database.ReplaceTitle(convo.ID, convo.Title, title)
+	if err != nil {
+		log.Printf("Failed to store generated title: %v", err)
+		return
+	}
+	if !replaced {
+		log.Printf("Conversation %s was renamed meanwhile, keeping its title", convo.ID)
+		return
+	}
+
+	log.Printf("Generated title for conversation %s: %s", convo.ID, title)
+	notify(convo.UserID, WSResponse{
+		Type:    "title_updated",
+		Content: title,
+		ConvoID: convo.ID,
+	})
+}
+
+// cleanTitle reduces a model's answer to a single plain line, dropping any
+// reasoning block and the decoration small models like to add.
+func cleanTitle(answer string) string {
+	answer = thinkBlock.ReplaceAllString(answer, "")
+
+	var title string
+	for _, line := range strings.Split(answer, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			title = line
+			break
+		}
+	}
+
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(title, " \t\"'`*#")
+	title = strings.TrimRight(title, ".")
+	title = strings.Join(strings.Fields(title), " ")
+
+	if runes := []rune(title); len(runes) > maxTitleRunes {
+		title = strings.TrimSpace(string(runes[:maxTitleRunes])) + "..."
+	}
+	return title
+}
+
+func excerpt(text string) string {
+	text = thinkBlock.ReplaceAllString(text, "")
+	if runes := []rune(text); len(runes) > titleExcerptRunes {
+		return string(runes[:titleExcerptRunes]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,19 @@
+package ws
+
+import "testing"
+
+func TestCleanTitle(t *testing.T) {
+	cases := map[string]string{
+		"Sorting Lists in Go":                             "Sorting Lists in Go",
+		"  \"Sorting Lists in Go.\"  ":                    "Sorting Lists in Go",
+		"Title: **Sorting Lists**\n\nHere you go":         "Sorting Lists",
+		"<think>the user wants a title</think>\nGo Sorts": "Go Sorts",
+		"<think>never finished":                           "",
+	}
+
+	for input, expected := range cases {
+		if got := cleanTitle(input); got != expected {
+			t.Errorf("cleanTitle(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}